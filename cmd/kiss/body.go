@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/vmihailenco/msgpack"
+	"strings"
+	"unicode/utf8"
+)
+
+// body formats, a body argument may be prefixed with "format:" to override the default
+const (
+	formatRaw  = "raw"
+	formatHex  = "hex"
+	formatJson = "json"
+)
+
+// codec of json bodies and replies
+type codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// msgpack codec
+type codecMsgpack struct{}
+
+func (codecMsgpack) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (codecMsgpack) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// json codec
+type codecJson struct{}
+
+func (codecJson) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codecJson) Unmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// codec by name
+func newCodec(name string) (codec, error) {
+	switch name {
+	case "json":
+		return codecJson{}, nil
+	case "msgpack":
+		return codecMsgpack{}, nil
+	}
+	return nil, fmt.Errorf("unsupported codec %q, should be json or msgpack", name)
+}
+
+// encode body argument, format of the argument is the prefix or default format
+func encodeBody(arg string, defaultFormat string, c codec) ([]byte, error) {
+	format := defaultFormat
+	if pos := strings.Index(arg, ":"); pos > 0 {
+		switch arg[:pos] {
+		case formatRaw, formatHex, formatJson:
+			format, arg = arg[:pos], arg[pos+1:]
+		}
+	}
+	switch format {
+	case formatRaw:
+		return []byte(arg), nil
+	case formatHex:
+		return hex.DecodeString(strings.Join(strings.Fields(arg), ""))
+	case formatJson:
+		if arg == "" {
+			return nil, nil
+		}
+		var v interface{}
+		decoder := json.NewDecoder(strings.NewReader(arg))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			return nil, fmt.Errorf("invalid json body: %v", err)
+		}
+		return c.Marshal(v)
+	}
+	return nil, fmt.Errorf("unsupported body format %q", format)
+}
+
+// msgpack decodes maps with interface keys, which json can't encode
+func jsonable(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = jsonable(item)
+		}
+		return m
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = jsonable(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = jsonable(item)
+		}
+	case []byte:
+		return string(value)
+	}
+	return v
+}
+
+// decode body for printing: json by codec, printable text as is, otherwise hex
+func decodeBody(body []byte, c codec, forceHex bool) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if !forceHex {
+		var v interface{}
+		if err := c.Unmarshal(body, &v); err == nil {
+			if data, err := json.Marshal(jsonable(v)); err == nil {
+				return string(data)
+			}
+		}
+		if utf8.Valid(body) && strings.IndexFunc(string(body), isControl) < 0 {
+			return fmt.Sprintf("%q", body)
+		}
+	}
+	return "hex:" + hex.EncodeToString(body)
+}
+
+// control characters except whitespaces
+func isControl(r rune) bool {
+	return r < 0x20 && r != '\t' && r != '\n' && r != '\r'
+}
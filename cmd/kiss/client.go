@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/nothollyhigh/kiss/net"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// max received messages kept for expect
+const inboxSize = 1024
+
+// command usage
+const usage = `commands:
+  send <cmd> [ext=<ext>] [body]    send message, ext is 0 by default
+  call <method> [body]             rpc call by method name and print the reply
+  expect <cmd> [timeout]           wait for a received message of cmd, messages before it are dropped
+  wait <duration>                  wait and print received messages
+  help                             print this help
+  quit                             exit
+body is in the default format, or prefixed with raw:, hex: or json:, json bodies are encoded by the codec
+lines starting with # are comments`
+
+// plain cipher, messages are sent without encryption and compression
+type cipherPlain struct{}
+
+func (cipherPlain) Init() {}
+
+func (cipherPlain) Encrypt(seq int64, key uint32, data []byte) []byte {
+	return data
+}
+
+func (cipherPlain) Decrypt(seq int64, key uint32, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// client options
+type clientOpt struct {
+	Codec   codec
+	Format  string
+	Timeout time.Duration
+	Hex     bool
+	Plain   bool
+}
+
+// command line client
+type client struct {
+	rpc   net.IRpcClient
+	stop  func()
+	opt   *clientOpt
+	inbox chan net.IMessage
+
+	// output lock, received messages are printed by the reading goroutine
+	mtx sync.Mutex
+	out io.Writer
+}
+
+// print line
+func (c *client) printf(format string, v ...interface{}) {
+	c.mtx.Lock()
+	fmt.Fprintf(c.out, format+"\n", v...)
+	c.mtx.Unlock()
+}
+
+// print and keep received message
+func (c *client) onMessage(msg net.IMessage) {
+	c.printf("recv cmd %d ext %d len %d %s", msg.Cmd(), msg.Ext(), msg.BodyLen(), decodeBody(msg.Body(), c.opt.Codec, c.opt.Hex))
+	select {
+	case c.inbox <- msg:
+	default:
+	}
+}
+
+// send message
+func (c *client) send(args []string, body string) error {
+	if len(args) < 1 {
+		return errors.New("usage: send <cmd> [ext=<ext>] [body]")
+	}
+	cmd, err := strconv.ParseUint(args[0], 0, 32)
+	if err != nil || uint32(cmd) > net.CmdUserMax {
+		return fmt.Errorf("invalid cmd %q, should be 0-%d", args[0], net.CmdUserMax)
+	}
+	ext := uint64(0)
+	if len(args) > 1 && strings.HasPrefix(args[1], "ext=") {
+		if ext, err = strconv.ParseUint(args[1][4:], 0, 64); err != nil {
+			return fmt.Errorf("invalid ext %q", args[1])
+		}
+		body = strings.TrimSpace(strings.TrimPrefix(body, args[1]))
+	}
+	data, err := encodeBody(body, c.opt.Format, c.opt.Codec)
+	if err != nil {
+		return err
+	}
+	msg := net.NewMessage(uint32(cmd), data)
+	msg.SetExt(int64(ext))
+	return c.rpc.SendMsg(msg)
+}
+
+// rpc call
+func (c *client) call(args []string, body string) error {
+	if len(args) < 1 {
+		return errors.New("usage: call <method> [body]")
+	}
+	data, err := encodeBody(body, c.opt.Format, c.opt.Codec)
+	if err != nil {
+		return err
+	}
+	begin := time.Now()
+	rsp := []byte{}
+	if err = c.rpc.Call(args[0], data, &rsp, c.opt.Timeout); err != nil {
+		return fmt.Errorf("call %v failed: %v", args[0], err)
+	}
+	c.printf("reply %s %v len %d %s", args[0], time.Since(begin).Round(time.Microsecond), len(rsp), decodeBody(rsp, c.opt.Codec, c.opt.Hex))
+	return nil
+}
+
+// wait for received message of cmd
+func (c *client) expect(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: expect <cmd> [timeout]")
+	}
+	cmd, err := strconv.ParseUint(args[0], 0, 32)
+	if err != nil {
+		return fmt.Errorf("invalid cmd %q", args[0])
+	}
+	timeout := c.opt.Timeout
+	if len(args) > 1 {
+		if timeout, err = time.ParseDuration(args[1]); err != nil {
+			return fmt.Errorf("invalid timeout %q", args[1])
+		}
+	}
+	after := time.NewTimer(timeout)
+	defer after.Stop()
+	for {
+		select {
+		case msg := <-c.inbox:
+			if msg.Cmd() == uint32(cmd) {
+				return nil
+			}
+		case <-after.C:
+			return fmt.Errorf("expect cmd %d timeout", cmd)
+		}
+	}
+}
+
+// execute command line, returns io.EOF for quit
+func (c *client) exec(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	fields := strings.Fields(line)
+	name, args := fields[0], fields[1:]
+	// body is the rest of line after the first argument
+	body := ""
+	if len(args) > 0 {
+		rest := strings.TrimSpace(line[len(name):])
+		body = strings.TrimSpace(rest[len(args[0]):])
+	}
+	switch name {
+	case "send":
+		return c.send(args, body)
+	case "call":
+		return c.call(args, body)
+	case "expect":
+		return c.expect(args)
+	case "wait", "sleep":
+		if len(args) < 1 {
+			return errors.New("usage: wait <duration>")
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", args[0])
+		}
+		time.Sleep(d)
+		return nil
+	case "help":
+		c.printf("%s", usage)
+		return nil
+	case "quit", "exit":
+		return io.EOF
+	}
+	return fmt.Errorf("unknown command %q, try help", name)
+}
+
+// run commands from reader. in script mode, it returns on the first failed command with the line number,
+// otherwise errors are printed and a prompt is printed before each command
+func (c *client) run(r io.Reader, script bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineno := 1; ; lineno++ {
+		if !script {
+			c.mtx.Lock()
+			fmt.Fprint(c.out, "kiss> ")
+			c.mtx.Unlock()
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		err := c.exec(scanner.Text())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if script {
+				return fmt.Errorf("line %d: %v", lineno, err)
+			}
+			c.printf("error: %v", err)
+		}
+	}
+}
+
+// close connection
+func (c *client) close() {
+	c.stop()
+}
+
+// connect to tcp server, or websocket server if addr starts with ws:// or wss://
+func dial(addr string, opt *clientOpt, out io.Writer) (*client, error) {
+	c := &client{opt: opt, inbox: make(chan net.IMessage, inboxSize), out: out}
+	if strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://") {
+		rpc, err := net.NewWebsocketRpcClient(addr, nil, &net.CodecBytes{})
+		if err != nil {
+			return nil, err
+		}
+		c.rpc, c.stop = rpc, rpc.Stop
+	} else {
+		engine := net.NewTcpEngine()
+		if opt.Plain {
+			engine.HandleNewCipher(func() net.ICipher {
+				return cipherPlain{}
+			})
+		}
+		rpc, err := net.NewRpcClient(addr, engine, &net.CodecBytes{}, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.rpc, c.stop = rpc, func() { rpc.Stop() }
+	}
+	c.rpc.HandleNotify(c.onMessage)
+	return c, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"github.com/nothollyhigh/kiss/net"
+	stdnet "net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientScript(t *testing.T) {
+	const cmdEcho = uint32(4325)
+
+	l, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server := net.NewTcpServer("kiss")
+	server.Handle(cmdEcho, func(client *net.TcpClient, msg net.IMessage) {
+		client.SendMsg(net.NewMessage(cmdEcho, msg.Body()))
+	})
+	server.HandleRpcMethod("Hello", func(ctx *net.RpcContext) {
+		req := map[string]string{}
+		if err := ctx.Bind(&req); err != nil {
+			ctx.Error(err.Error())
+			return
+		}
+		ctx.Write(map[string]string{"hello": req["name"]})
+	})
+	go server.Start(addr)
+	defer server.Stop()
+
+	out := &bytes.Buffer{}
+	var cli *client
+	for i := 0; i < 50; i++ {
+		if cli, err = dial(addr, &clientOpt{Codec: codecJson{}, Format: formatJson, Timeout: time.Second * 3}, out); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer cli.close()
+
+	script := `
+# smoke test
+call Hello {"name":"kiss"}
+send 4325 ext=3 hex:01 02 ff
+expect 4325
+send 4325 raw:ping
+expect 4325 1s
+`
+	if err = cli.run(strings.NewReader(script), true); err != nil {
+		t.Fatalf("run script failed: %v", err)
+	}
+	cli.mtx.Lock()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	cli.mtx.Unlock()
+	if len(lines) != 3 {
+		t.Fatalf("output should be 3 lines, got: %q", lines)
+	}
+	if !strings.HasPrefix(lines[0], "reply Hello") || !strings.HasSuffix(lines[0], `{"hello":"kiss"}`) {
+		t.Fatalf("invalid reply: %v", lines[0])
+	}
+	if lines[1] != "recv cmd 4325 ext 0 len 3 hex:0102ff" || lines[2] != `recv cmd 4325 ext 0 len 4 "ping"` {
+		t.Fatalf("invalid received messages: %q", lines[1:])
+	}
+
+	if err = cli.run(strings.NewReader("send 4325 {}\nexpect 4326 100ms\n"), true); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("expect of cmd not received should fail at line 2, got %v", err)
+	}
+	if err = cli.run(strings.NewReader("call Hello not-json\n"), true); err == nil {
+		t.Fatalf("invalid json body should fail")
+	}
+}
@@ -0,0 +1,82 @@
+// kiss is a command line client of kiss tcp and websocket servers, for poking servers and smoke tests.
+// commands are read from stdin interactively, or from a script which stops on the first failed command.
+//
+//	kiss -addr 127.0.0.1:8888
+//	kiss -addr ws://127.0.0.1:8080/ws -script smoke.txt
+//	echo 'call Hello {"name":"kiss"}' | kiss -addr 127.0.0.1:8888 -script -
+//
+// run "help" in the client for commands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/nothollyhigh/kiss/log"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	addr    = flag.String("addr", "", "server address, host:port for tcp, ws:// or wss:// url for websocket")
+	script  = flag.String("script", "", "script file of commands, - for stdin, interactive if empty")
+	format  = flag.String("format", formatJson, "default body format: raw, hex or json")
+	codecs  = flag.String("codec", "json", "codec of json bodies and replies: json or msgpack")
+	timeout = flag.Duration("timeout", time.Second*5, "timeout of rpc calls and expect")
+	wait    = flag.Duration("wait", 0, "wait for messages before exit")
+	hexOut  = flag.Bool("hex", false, "print received bodies in hex")
+	plain   = flag.Bool("plain", false, "tcp without cipher, gzip cipher is used by default")
+	debug   = flag.Bool("debug", false, "print debug logs of kiss")
+)
+
+func main() {
+	flag.Parse()
+	if *addr == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !*debug {
+		log.SetLevel(log.LEVEL_WARN)
+	}
+	c, err := newCodec(*codecs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	switch *format {
+	case formatRaw, formatHex, formatJson:
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported body format %q, should be raw, hex or json\n", *format)
+		os.Exit(2)
+	}
+
+	cli, err := dial(*addr, &clientOpt{
+		Codec:   c,
+		Format:  *format,
+		Timeout: *timeout,
+		Hex:     *hexOut,
+		Plain:   *plain,
+	}, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect %v failed: %v\n", *addr, err)
+		os.Exit(1)
+	}
+
+	var in io.Reader = os.Stdin
+	if *script != "" && *script != "-" {
+		file, err := os.Open(*script)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		in = file
+	}
+	err = cli.run(in, *script != "")
+	time.Sleep(*wait)
+	cli.close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
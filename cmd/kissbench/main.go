@@ -0,0 +1,173 @@
+// kissbench runs load tests against kiss tcp and websocket servers and reports throughput and latency percentiles.
+//
+//	kissbench -addr 127.0.0.1:8888 -clients 10000 -ramp 20s -duration 30s
+//	kissbench -addr 127.0.0.1:8888 -clients 500 -duration 30s -mix "cmd=1001,reply=1001,size=64-512,weight=3;method=Hello,body=\"kiss\""
+//	kissbench -addr ws://127.0.0.1:8080/ws -clients 500 -duration 30s -mix @mix.json
+//
+// mix is a list of actions separated by ";", an action is fields of key=value separated by ",":
+//
+//	cmd      send message of cmd
+//	method   rpc call of method
+//	reply    cmd of reply to wait for after message sent
+//	weight   weight in mix, 1 by default
+//	size     random payload size, n or min-max
+//	body     payload, can't contain "," or ";", use a json file of []loadtest.Action for complex payloads
+//	name     name in report
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/nothollyhigh/kiss/loadtest"
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/net"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	addr     = flag.String("addr", "", "server address, host:port for tcp, ws:// or wss:// url for websocket")
+	clients  = flag.Int("clients", 100, "num of clients")
+	ramp     = flag.Duration("ramp", time.Second, "clients are connected evenly in ramp")
+	duration = flag.Duration("duration", time.Second*10, "duration of actions after all clients connected")
+	interval = flag.Duration("interval", 0, "interval between actions of a client")
+	timeout  = flag.Duration("timeout", loadtest.DefaultTimeout, "timeout of rpc calls and replies")
+	mix      = flag.String("mix", "", "mix of actions, or @file of json actions, clients only connect if empty")
+	plain    = flag.Bool("plain", false, "tcp without cipher, gzip cipher is used by default")
+	jsonOut  = flag.Bool("json", false, "print report in json")
+	debug    = flag.Bool("debug", false, "print debug logs of kiss")
+)
+
+// plain cipher, messages are sent without encryption and compression
+type cipherPlain struct{}
+
+func (cipherPlain) Init() {}
+
+func (cipherPlain) Encrypt(seq int64, key uint32, data []byte) []byte {
+	return data
+}
+
+func (cipherPlain) Decrypt(seq int64, key uint32, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// parse uint32 field
+func parseUint32(key, value string) (uint32, error) {
+	n, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v %q", key, value)
+	}
+	return uint32(n), nil
+}
+
+// parse action of key=value fields
+func parseAction(spec string) (*loadtest.Action, error) {
+	action := &loadtest.Action{}
+	for _, field := range strings.Split(spec, ",") {
+		pos := strings.Index(field, "=")
+		if pos <= 0 {
+			return nil, fmt.Errorf("invalid field %q, should be key=value", field)
+		}
+		key, value := strings.TrimSpace(field[:pos]), field[pos+1:]
+		var err error
+		switch key {
+		case "cmd":
+			action.Cmd, err = parseUint32(key, value)
+		case "method":
+			action.Method = value
+		case "reply":
+			action.Reply, err = parseUint32(key, value)
+		case "weight":
+			action.Weight, err = strconv.Atoi(value)
+		case "size":
+			sizes := strings.SplitN(value, "-", 2)
+			if action.MinSize, err = strconv.Atoi(sizes[0]); err == nil {
+				action.MaxSize = action.MinSize
+				if len(sizes) > 1 {
+					action.MaxSize, err = strconv.Atoi(sizes[1])
+				}
+			}
+		case "body":
+			action.Body = value
+		case "name":
+			action.Name = value
+		default:
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v %q", key, value)
+		}
+	}
+	return action, nil
+}
+
+// parse mix of actions
+func parseMix(mix string) ([]*loadtest.Action, error) {
+	actions := []*loadtest.Action{}
+	if strings.HasPrefix(mix, "@") {
+		data, err := ioutil.ReadFile(mix[1:])
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &actions)
+		return actions, err
+	}
+	for _, spec := range strings.Split(mix, ";") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		action, err := parseAction(spec)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+func main() {
+	flag.Parse()
+	if *addr == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !*debug {
+		log.SetLevel(log.LEVEL_WARN)
+	}
+
+	actions, err := parseMix(*mix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid mix: %v\n", err)
+		os.Exit(2)
+	}
+	cfg := &loadtest.Config{
+		Addr:     *addr,
+		Clients:  *clients,
+		Ramp:     *ramp,
+		Duration: *duration,
+		Interval: *interval,
+		Timeout:  *timeout,
+		Actions:  actions,
+	}
+	if *plain {
+		cfg.NewCipher = func() net.ICipher {
+			return cipherPlain{}
+		}
+	}
+
+	report, err := loadtest.Run(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *jsonOut {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Print(report)
+}
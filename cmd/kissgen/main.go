@@ -0,0 +1,81 @@
+// kissgen generates go and typescript code of message schema: cmd constants, payload types, typed send helpers and
+// handler registration for TcpEngin and WSEngine, and a websocket client for web frontend.
+//
+//	kissgen -schema msg.json -go msg/msg.go -ts web/src/msg.ts
+//	kissgen -schema msg.json -go msg/msg.go -ts web/src/msg.ts -check
+//
+// cmds are validated against each other and the reserved cmds (CmdPing...CmdRpcError and cmds > CmdUserMax),
+// with -check, generated files are compared with existing files instead of written, for ci.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/nothollyhigh/kiss/schema"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	schemaFile = flag.String("schema", "", "json schema file")
+	goOut      = flag.String("go", "", "go output file")
+	tsOut      = flag.String("ts", "", "typescript output file")
+	check      = flag.Bool("check", false, "check output files are up to date instead of writing them")
+)
+
+// write or check output file
+func output(path string, data []byte) error {
+	if *check {
+		old, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(old, data) {
+			return fmt.Errorf("%v is out of date, run kissgen again", path)
+		}
+		return nil
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func main() {
+	flag.Parse()
+	if *schemaFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s, err := schema.ParseFile(*schemaFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid schema %v:\n%v\n", *schemaFile, err)
+		os.Exit(1)
+	}
+
+	source := filepath.Base(*schemaFile)
+	for _, gen := range []struct {
+		path     string
+		generate func(string) ([]byte, error)
+	}{
+		{*goOut, s.GenerateGo},
+		{*tsOut, s.GenerateTypeScript},
+	} {
+		if gen.path == "" {
+			continue
+		}
+		data, err := gen.generate(source)
+		if err == nil {
+			err = output(gen.path, data)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// kissreplay dumps capture files written by net.Capture, or replays them to a tcp server.
+//
+//	kissreplay -file capture.kcap -dump
+//	kissreplay -file capture.kcap -addr 127.0.0.1:8888 -speed 1 -wait 3s
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/nothollyhigh/kiss/net"
+	"os"
+	"time"
+)
+
+var (
+	file   = flag.String("file", "", "capture file")
+	addr   = flag.String("addr", "", "tcp server address to replay to")
+	dump   = flag.Bool("dump", false, "print records instead of replaying")
+	speed  = flag.Float64("speed", 0, "replay speed relative to captured timing, 0 replays without delay")
+	wait   = flag.Duration("wait", time.Second, "wait for responses after replayed")
+	plain  = flag.Bool("plain", false, "replay without cipher, gzip cipher is used by default")
+	maxHex = flag.Int("hex", 32, "max body bytes printed in hex")
+)
+
+// body preview in hex
+func preview(body []byte) string {
+	if len(body) > *maxHex {
+		return hex.EncodeToString(body[:*maxHex]) + "..."
+	}
+	return hex.EncodeToString(body)
+}
+
+// print records
+func dumpRecords(records []*net.CaptureRecord) {
+	for _, rec := range records {
+		prefix := fmt.Sprintf("%s conn %d %-5s %-3s", rec.Time.Format("2006-01-02 15:04:05.000000"), rec.ConnId, rec.Direction, rec.Proto)
+		switch rec.Direction {
+		case net.CaptureOpen:
+			fmt.Printf("%s ip %s\n", prefix, rec.Ip)
+		case net.CaptureIn, net.CaptureOut:
+			fmt.Printf("%s cmd %d ext %d len %d %s\n", prefix, rec.Msg.Cmd(), rec.Msg.Ext(), rec.Msg.BodyLen(), preview(rec.Msg.Body()))
+		default:
+			fmt.Println(prefix)
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *file == "" || (!*dump && *addr == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	records, err := net.ReadCaptureFile(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read %v failed: %v\n", *file, err)
+		if len(records) == 0 {
+			os.Exit(1)
+		}
+	}
+
+	if *dump {
+		dumpRecords(records)
+		return
+	}
+
+	opt := &net.ReplayOpt{
+		Speed: *speed,
+		Wait:  *wait,
+		OnMessage: func(connId uint64, msg net.IMessage) {
+			fmt.Printf("conn %d recv cmd %d ext %d len %d %s\n", connId, msg.Cmd(), msg.Ext(), msg.BodyLen(), preview(msg.Body()))
+		},
+	}
+	if *plain {
+		opt.NewCipher = func() net.ICipher {
+			return nil
+		}
+	}
+	if err = net.Replay(*addr, records, opt); err != nil {
+		fmt.Fprintf(os.Stderr, "replay failed: %v\n", err)
+		os.Exit(1)
+	}
+}
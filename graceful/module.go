@@ -108,6 +108,13 @@ func (m *Module) Start() {
 	})
 }
 
+// num of funcs waiting in queue
+func (m *Module) QueueLen() int {
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.chFunc)
+}
+
 func (m *Module) SetQSize(size int) {
 	m.qsize = size
 }
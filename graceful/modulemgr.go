@@ -2,8 +2,11 @@ package graceful
 
 import (
 	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/metrics"
+	"github.com/nothollyhigh/kiss/net"
 	"github.com/nothollyhigh/kiss/util"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -39,6 +42,12 @@ type ModuleMgr struct {
 	sync.Mutex
 	sync.WaitGroup
 	modules []M
+	gauges  []*metrics.GaugeFunc
+}
+
+// modules exposing queue length, such as Module
+type queueLener interface {
+	QueueLen() int
 }
 
 // register module to module manager
@@ -54,12 +63,45 @@ func (mgr *ModuleMgr) Start() {
 	mgr.Lock()
 	defer mgr.Unlock()
 
-	for _, v := range mgr.modules {
+	for i, v := range mgr.modules {
 		t := reflect.TypeOf(v)
 		log.Debug("Module [%v] Start", t)
 		v.Init()
 		v.Start()
+		mgr.registerMetric(i, v)
+	}
+}
+
+// register queue length metric of module
+func (mgr *ModuleMgr) registerMetric(idx int, m M) {
+	q, ok := m.(queueLener)
+	if !ok {
+		return
+	}
+	labels := metrics.Labels{"module": reflect.TypeOf(m).String(), "index": strconv.Itoa(idx)}
+	g, err := metrics.NewGaugeFunc("kiss_module_queue_length", "funcs waiting in module queue", labels, func() float64 {
+		return float64(q.QueueLen())
+	})
+	if err != nil {
+		log.Debug("Module [%v] metric disabled: %v", labels["module"], err)
+		return
+	}
+	mgr.gauges = append(mgr.gauges, g)
+}
+
+// modules and their queue lengths shown by net.Admin, -1 if a module has no queue
+func (mgr *ModuleMgr) AdminModules() []net.AdminModule {
+	mgr.Lock()
+	defer mgr.Unlock()
+
+	modules := make([]net.AdminModule, len(mgr.modules))
+	for i, v := range mgr.modules {
+		modules[i] = net.AdminModule{Name: reflect.TypeOf(v).String(), QueueLen: -1}
+		if q, ok := v.(queueLener); ok {
+			modules[i].QueueLen = q.QueueLen()
+		}
 	}
+	return modules
 }
 
 func (mgr *ModuleMgr) Stop() {
@@ -78,6 +120,10 @@ func (mgr *ModuleMgr) Stop() {
 		})
 	}
 	mgr.Wait()
+	for _, g := range mgr.gauges {
+		metrics.Unregister(g)
+	}
+	mgr.gauges = nil
 	log.Debug("ModuleMgr Stop Done.")
 }
 
@@ -89,6 +135,11 @@ func Start() {
 	defaultModuleMgr.Start()
 }
 
+// modules of default module manager, such as net.Admin.HandleModules(graceful.AdminModules)
+func AdminModules() []net.AdminModule {
+	return defaultModuleMgr.AdminModules()
+}
+
 func Stop() {
 	defaultModuleMgr.Stop()
 }
@@ -0,0 +1,146 @@
+package loadtest
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sub buckets of each power of 2, values are kept with 1/32 relative precision
+	histSubBuckets = 32
+	histSubBits    = 5
+	histBuckets    = 64 * histSubBuckets
+)
+
+// latency histogram in microseconds, safe for concurrent use without locks
+type Histogram struct {
+	counts [histBuckets]int64
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+// bucket of value, values less than 32 have their own buckets
+func histBucket(v uint64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - histSubBits - 1
+	return (exp+1)*histSubBuckets + int(v>>uint(exp)) - histSubBuckets
+}
+
+// middle value of bucket
+func histValue(b int) int64 {
+	if b < histSubBuckets {
+		return int64(b)
+	}
+	exp := uint(b/histSubBuckets - 1)
+	lower := int64(b%histSubBuckets+histSubBuckets) << exp
+	return lower + (int64(1)<<exp)/2
+}
+
+// record latency
+func (h *Histogram) Record(d time.Duration) {
+	us := int64(d / time.Microsecond)
+	if us < 0 {
+		us = 0
+	}
+	atomic.AddInt64(&h.counts[histBucket(uint64(us))], 1)
+	atomic.AddInt64(&h.sum, us)
+	// min is stored plus 1, so that zero means no value
+	for {
+		min := atomic.LoadInt64(&h.min)
+		if (min != 0 && min <= us+1) || atomic.CompareAndSwapInt64(&h.min, min, us+1) {
+			break
+		}
+	}
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if max >= us || atomic.CompareAndSwapInt64(&h.max, max, us) {
+			break
+		}
+	}
+	atomic.AddInt64(&h.count, 1)
+}
+
+// num of recorded values
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// min value
+func (h *Histogram) Min() time.Duration {
+	if min := atomic.LoadInt64(&h.min); min > 0 {
+		return time.Duration(min-1) * time.Microsecond
+	}
+	return 0
+}
+
+// max value
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.max)) * time.Microsecond
+}
+
+// mean value
+func (h *Histogram) Mean() time.Duration {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&h.sum)/count) * time.Microsecond
+}
+
+// value at percentile p (0-100), limited in [Min, Max]
+func (h *Histogram) Percentile(p float64) time.Duration {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(count)))
+	if rank < 1 {
+		rank = 1
+	}
+	n := int64(0)
+	for b := range h.counts {
+		if n += atomic.LoadInt64(&h.counts[b]); n >= rank {
+			d := time.Duration(histValue(b)) * time.Microsecond
+			if min := h.Min(); d < min {
+				return min
+			}
+			if max := h.Max(); d > max {
+				return max
+			}
+			return d
+		}
+	}
+	return h.Max()
+}
+
+// merge values of other histogram
+func (h *Histogram) Merge(other *Histogram) {
+	for b := range other.counts {
+		if n := atomic.LoadInt64(&other.counts[b]); n > 0 {
+			atomic.AddInt64(&h.counts[b], n)
+		}
+	}
+	atomic.AddInt64(&h.sum, atomic.LoadInt64(&other.sum))
+	if min := atomic.LoadInt64(&other.min); min > 0 {
+		for {
+			cur := atomic.LoadInt64(&h.min)
+			if (cur != 0 && cur <= min) || atomic.CompareAndSwapInt64(&h.min, cur, min) {
+				break
+			}
+		}
+	}
+	max := atomic.LoadInt64(&other.max)
+	for {
+		cur := atomic.LoadInt64(&h.max)
+		if cur >= max || atomic.CompareAndSwapInt64(&h.max, cur, max) {
+			break
+		}
+	}
+	atomic.AddInt64(&h.count, atomic.LoadInt64(&other.count))
+}
@@ -0,0 +1,356 @@
+package loadtest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nothollyhigh/kiss/net"
+	"github.com/nothollyhigh/kiss/util"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// default timeout of rpc calls and replies
+	DefaultTimeout = time.Second * 5
+
+	ErrInvalidAddr    = errors.New("loadtest: empty addr")
+	ErrInvalidClients = errors.New("loadtest: clients should be positive")
+	ErrInvalidAction  = errors.New("loadtest: action should not have both cmd and method")
+	ErrReplyTimeout   = errors.New("loadtest: reply timeout")
+	ErrClientClosed   = errors.New("loadtest: client closed")
+)
+
+// action of simulated client, a message sent or a rpc call
+type Action struct {
+	// name in report, cmd or method by default
+	Name string `json:"name"`
+
+	// weight in mix of actions, 1 if not positive
+	Weight int `json:"weight"`
+
+	// send message of cmd if method is empty, 0 is a valid cmd
+	Cmd uint32 `json:"cmd"`
+
+	// rpc call of method
+	Method string `json:"method"`
+
+	// cmd of reply to wait for after message sent, latency is recorded until the reply received.
+	// messages are sent without waiting if 0
+	Reply uint32 `json:"reply"`
+
+	// payload, random bytes of size in [MinSize, MaxSize] are sent if empty
+	Body    string `json:"body"`
+	MinSize int    `json:"min_size"`
+	MaxSize int    `json:"max_size"`
+}
+
+// report name
+func (action *Action) name() string {
+	if action.Name != "" {
+		return action.Name
+	}
+	if action.Method != "" {
+		return action.Method
+	}
+	return fmt.Sprintf("cmd-%d", action.Cmd)
+}
+
+// payload of action
+func (action *Action) body(rnd *rand.Rand) []byte {
+	if action.Body != "" {
+		return []byte(action.Body)
+	}
+	size := action.MinSize
+	if action.MaxSize > size {
+		size += rnd.Intn(action.MaxSize - size + 1)
+	}
+	data := make([]byte, size)
+	rnd.Read(data)
+	return data
+}
+
+// load test config
+type Config struct {
+	// tcp server address host:port, or websocket url starting with ws:// or wss://
+	Addr string
+
+	// num of simulated clients
+	Clients int
+
+	// connect ramp, clients are connected evenly in ramp
+	Ramp time.Duration
+
+	// duration after all clients connected, clients perform actions in it, or are just kept connected if no actions
+	Duration time.Duration
+
+	// interval between actions of a client, actions are performed one by one without waiting if 0
+	Interval time.Duration
+
+	// timeout of rpc calls and replies, DefaultTimeout if 0
+	Timeout time.Duration
+
+	// mix of actions, an action is chosen by weight each time
+	Actions []*Action
+
+	// cipher of tcp clients, gzip cipher is used if nil
+	NewCipher func() net.ICipher
+
+	// handler of messages which are not replies waited for
+	OnMessage func(client int, msg net.IMessage)
+}
+
+// simulated client
+type client struct {
+	rpc     net.IRpcClient
+	stop    func()
+	replies chan uint32
+	closed  chan struct{}
+	rnd     *rand.Rand
+}
+
+// load test
+type loadTest struct {
+	cfg     *Config
+	weights int
+	stats   []*Stats
+	connect *Stats
+
+	online       int64
+	maxOnline    int64
+	disconnected int64
+	sentBytes    int64
+}
+
+// connect a client
+func (lt *loadTest) dial(idx int) (*client, error) {
+	c := &client{
+		replies: make(chan uint32, 64),
+		closed:  make(chan struct{}),
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano() + int64(idx))),
+	}
+	once := sync.Once{}
+	onClose := func() {
+		once.Do(func() {
+			close(c.closed)
+		})
+	}
+	if strings.HasPrefix(lt.cfg.Addr, "ws://") || strings.HasPrefix(lt.cfg.Addr, "wss://") {
+		rpc, err := net.NewWebsocketRpcClient(lt.cfg.Addr, nil, &net.CodecBytes{})
+		if err != nil {
+			return nil, err
+		}
+		rpc.OnClose(c, func(*net.WSClient) {
+			onClose()
+		})
+		c.rpc, c.stop = rpc, rpc.Shutdown
+	} else {
+		engine := net.NewTcpEngine()
+		if lt.cfg.NewCipher != nil {
+			engine.HandleNewCipher(lt.cfg.NewCipher)
+		}
+		rpc, err := net.NewRpcClient(lt.cfg.Addr, engine, &net.CodecBytes{}, nil)
+		if err != nil {
+			return nil, err
+		}
+		// disconnected clients are not reconnected, so that server limits are not hidden
+		rpc.OnClose(c, func(*net.TcpClient) {
+			onClose()
+			util.Go(func() {
+				rpc.Shutdown()
+			})
+		})
+		c.rpc, c.stop = rpc, func() { rpc.Shutdown() }
+	}
+	c.rpc.HandleNotify(func(msg net.IMessage) {
+		select {
+		case c.replies <- msg.Cmd():
+		default:
+		}
+		if lt.cfg.OnMessage != nil {
+			lt.cfg.OnMessage(idx, msg)
+		}
+	})
+	return c, nil
+}
+
+// choose action by weight
+func (lt *loadTest) choose(rnd *rand.Rand) int {
+	n := rnd.Intn(lt.weights)
+	for i, action := range lt.cfg.Actions {
+		if n -= weightOf(action); n < 0 {
+			return i
+		}
+	}
+	return len(lt.cfg.Actions) - 1
+}
+
+// perform action, returns false if the client is closed
+func (lt *loadTest) perform(c *client, i int) bool {
+	action := lt.cfg.Actions[i]
+	stats := lt.stats[i]
+	data := action.body(c.rnd)
+	atomic.AddInt64(&lt.sentBytes, int64(len(data)))
+
+	begin := time.Now()
+	if action.Method != "" {
+		err := c.rpc.Call(action.Method, data, nil, lt.timeout())
+		stats.done(begin, err)
+		return err == nil || !lt.isClosed(c)
+	}
+
+	// drop replies of previous actions
+	for len(c.replies) > 0 {
+		<-c.replies
+	}
+	if err := c.rpc.SendMsg(net.NewMessage(action.Cmd, data)); err != nil {
+		stats.done(begin, err)
+		return !lt.isClosed(c)
+	}
+	if action.Reply == 0 {
+		stats.done(begin, nil)
+		return true
+	}
+	after := time.NewTimer(lt.timeout())
+	defer after.Stop()
+	for {
+		select {
+		case cmd := <-c.replies:
+			if cmd == action.Reply {
+				stats.done(begin, nil)
+				return true
+			}
+		case <-after.C:
+			stats.done(begin, ErrReplyTimeout)
+			return true
+		case <-c.closed:
+			stats.done(begin, ErrClientClosed)
+			return false
+		}
+	}
+}
+
+// is client closed
+func (lt *loadTest) isClosed(c *client) bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// timeout of calls and replies
+func (lt *loadTest) timeout() time.Duration {
+	if lt.cfg.Timeout > 0 {
+		return lt.cfg.Timeout
+	}
+	return DefaultTimeout
+}
+
+// run a simulated client: connect at its time of ramp, perform actions until finished, then disconnect
+func (lt *loadTest) runClient(idx int, begin time.Time, done chan struct{}) {
+	if lt.cfg.Ramp > 0 {
+		time.Sleep(time.Until(begin.Add(lt.cfg.Ramp * time.Duration(idx) / time.Duration(lt.cfg.Clients))))
+	}
+	connBegin := time.Now()
+	c, err := lt.dial(idx)
+	lt.connect.done(connBegin, err)
+	if err != nil {
+		return
+	}
+	online := atomic.AddInt64(&lt.online, 1)
+	for {
+		max := atomic.LoadInt64(&lt.maxOnline)
+		if online <= max || atomic.CompareAndSwapInt64(&lt.maxOnline, max, online) {
+			break
+		}
+	}
+	defer func() {
+		atomic.AddInt64(&lt.online, -1)
+		c.stop()
+	}()
+
+	if len(lt.cfg.Actions) > 0 {
+		// actions start after all clients connected
+		select {
+		case <-time.After(time.Until(begin.Add(lt.cfg.Ramp))):
+		case <-done:
+			return
+		case <-c.closed:
+			atomic.AddInt64(&lt.disconnected, 1)
+			return
+		}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if !lt.perform(c, lt.choose(c.rnd)) {
+				atomic.AddInt64(&lt.disconnected, 1)
+				return
+			}
+			if lt.cfg.Interval > 0 {
+				select {
+				case <-time.After(lt.cfg.Interval):
+				case <-done:
+					return
+				}
+			}
+		}
+	}
+
+	select {
+	case <-done:
+	case <-c.closed:
+		atomic.AddInt64(&lt.disconnected, 1)
+	}
+}
+
+// weight of action
+func weightOf(action *Action) int {
+	if action.Weight > 0 {
+		return action.Weight
+	}
+	return 1
+}
+
+// run load test: cfg.Clients clients are connected evenly in cfg.Ramp, then perform actions of cfg.Actions
+// for cfg.Duration, and disconnect. it returns after all clients disconnected
+func Run(cfg *Config) (*Report, error) {
+	if cfg.Addr == "" {
+		return nil, ErrInvalidAddr
+	}
+	if cfg.Clients <= 0 {
+		return nil, ErrInvalidClients
+	}
+	lt := &loadTest{cfg: cfg, connect: &Stats{Name: "connect"}}
+	for _, action := range cfg.Actions {
+		if action.Method != "" && action.Cmd != 0 {
+			return nil, ErrInvalidAction
+		}
+		lt.weights += weightOf(action)
+		lt.stats = append(lt.stats, &Stats{Name: action.name()})
+	}
+
+	begin := time.Now()
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < cfg.Clients; i++ {
+		wg.Add(1)
+		idx := i
+		util.Go(func() {
+			defer wg.Done()
+			lt.runClient(idx, begin, done)
+		})
+	}
+	time.Sleep(time.Until(begin.Add(cfg.Ramp + cfg.Duration)))
+	close(done)
+	elapsed := time.Since(begin)
+	wg.Wait()
+
+	return lt.report(elapsed), nil
+}
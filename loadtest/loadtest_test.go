@@ -0,0 +1,168 @@
+package loadtest
+
+import (
+	"github.com/nothollyhigh/kiss/net"
+	stdnet "net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const cmdEcho = uint32(4326)
+
+func freeTcpAddr(t *testing.T) string {
+	l, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func checkReport(t *testing.T, r *Report, clients int) {
+	if r.MaxOnline != int64(clients) || r.ConnectErrors != 0 || r.Disconnected != 0 || r.Connect.Count != int64(clients) {
+		t.Fatalf("all clients should be online: %+v", r)
+	}
+	if len(r.Actions) != 2 {
+		t.Fatalf("actions should be 2, got %d", len(r.Actions))
+	}
+	for _, s := range append(r.Actions, r.Total) {
+		if s.Count == 0 || s.Errors != 0 || s.Qps <= 0 {
+			t.Fatalf("invalid stats of %v: %+v", s.Name, s)
+		}
+		if s.Min > s.P50 || s.P50 > s.P90 || s.P90 > s.P99 || s.P99 > s.P999 || s.P999 > s.Max {
+			t.Fatalf("percentiles of %v should be ordered: %+v", s.Name, s)
+		}
+	}
+	if r.Total.Count != r.Actions[0].Count+r.Actions[1].Count {
+		t.Fatalf("total count should be sum of actions: %+v", r.Total)
+	}
+}
+
+func TestLoadTcp(t *testing.T) {
+	addr := freeTcpAddr(t)
+	server := net.NewTcpServer("loadtest")
+	server.Handle(cmdEcho, func(client *net.TcpClient, msg net.IMessage) {
+		client.SendMsg(net.NewMessage(cmdEcho, msg.Body()))
+	})
+	server.HandleRpcMethod("Hello", func(ctx *net.RpcContext) {
+		ctx.WriteData(ctx.Body())
+	})
+	go server.Start(addr)
+	defer server.Stop()
+	time.Sleep(time.Millisecond * 50)
+
+	r, err := Run(&Config{
+		Addr:     addr,
+		Clients:  20,
+		Ramp:     time.Millisecond * 100,
+		Duration: time.Millisecond * 300,
+		Actions: []*Action{
+			{Cmd: cmdEcho, Reply: cmdEcho, Weight: 3, MinSize: 16, MaxSize: 256},
+			{Method: "Hello", Body: `"kiss"`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	checkReport(t, r, 20)
+	if !strings.Contains(r.String(), "cmd-4326") {
+		t.Fatalf("report should contain action name:\n%v", r)
+	}
+
+	// clients over max concurrent are disconnected by server
+	for i := 0; i < 100 && server.CurrLoad() != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	server.SetMaxConcurrent(5)
+	r, err = Run(&Config{Addr: addr, Clients: 10, Duration: time.Millisecond * 300})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if r.Disconnected != 5 {
+		t.Fatalf("disconnected should be 5, got %d", r.Disconnected)
+	}
+}
+
+func TestLoadCmdZero(t *testing.T) {
+	if _, err := Run(&Config{Addr: "127.0.0.1:1", Clients: 1, Actions: []*Action{{Cmd: cmdEcho, Method: "Hello"}}}); err != ErrInvalidAction {
+		t.Fatalf("action with both cmd and method should fail, got %v", err)
+	}
+
+	// cmd 0 is sent when method is empty
+	addr := freeTcpAddr(t)
+	server := net.NewTcpServer("loadtest")
+	server.Handle(0, func(client *net.TcpClient, msg net.IMessage) {
+		client.SendMsg(net.NewMessage(cmdEcho, msg.Body()))
+	})
+	go server.Start(addr)
+	defer server.Stop()
+	time.Sleep(time.Millisecond * 50)
+
+	r, err := Run(&Config{
+		Addr:     addr,
+		Clients:  2,
+		Duration: time.Millisecond * 200,
+		Actions:  []*Action{{Reply: cmdEcho, MinSize: 8}},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if s := r.Actions[0]; s.Name != "cmd-0" || s.Count == 0 || s.Errors != 0 {
+		t.Fatalf("invalid stats of cmd 0: %+v", s)
+	}
+}
+
+func TestLoadWebsocket(t *testing.T) {
+	server, err := net.NewWebsocketServer("loadtest", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.HandleWs("/ws")
+	server.Handle(cmdEcho, func(cli *net.WSClient, msg net.IMessage) {
+		cli.SendMsg(net.NewMessage(cmdEcho, msg.Body()))
+	})
+	server.HandleRpcMethod("Hello", func(ctx *net.RpcContext) {
+		ctx.WriteData(ctx.Body())
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	r, err := Run(&Config{
+		Addr:     "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws",
+		Clients:  10,
+		Duration: time.Millisecond * 300,
+		Actions: []*Action{
+			{Cmd: cmdEcho, Reply: cmdEcho, MinSize: 8, MaxSize: 64},
+			{Method: "Hello", MinSize: 8},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	checkReport(t, r, 10)
+}
+
+func TestHistogram(t *testing.T) {
+	h := &Histogram{}
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	if h.Count() != 10000 || h.Min() != time.Microsecond || h.Max() != 10000*time.Microsecond {
+		t.Fatalf("invalid count, min or max: %v, %v, %v", h.Count(), h.Min(), h.Max())
+	}
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		want := time.Duration(p*100) * time.Microsecond
+		if got := h.Percentile(p); got < want*96/100 || got > want*104/100 {
+			t.Fatalf("p%v should be about %v, got %v", p, want, got)
+		}
+	}
+
+	other := &Histogram{}
+	other.Record(time.Millisecond * 20)
+	h.Merge(other)
+	if h.Count() != 10001 || h.Max() != time.Millisecond*20 || h.Percentile(100) != time.Millisecond*20 {
+		t.Fatalf("invalid merged histogram: %v, %v, %v", h.Count(), h.Max(), h.Percentile(100))
+	}
+}
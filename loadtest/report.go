@@ -0,0 +1,128 @@
+package loadtest
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// latency and error stats of an action
+type Stats struct {
+	Name   string
+	hist   Histogram
+	errors int64
+}
+
+// record result of action began at begin
+func (stats *Stats) done(begin time.Time, err error) {
+	if err != nil {
+		atomic.AddInt64(&stats.errors, 1)
+		return
+	}
+	stats.hist.Record(time.Since(begin))
+}
+
+// summary in period
+func (stats *Stats) summary(period time.Duration) Summary {
+	s := Summary{
+		Name:   stats.Name,
+		Count:  stats.hist.Count(),
+		Errors: atomic.LoadInt64(&stats.errors),
+		Min:    stats.hist.Min(),
+		Mean:   stats.hist.Mean(),
+		P50:    stats.hist.Percentile(50),
+		P90:    stats.hist.Percentile(90),
+		P99:    stats.hist.Percentile(99),
+		P999:   stats.hist.Percentile(99.9),
+		Max:    stats.hist.Max(),
+	}
+	if period > 0 {
+		s.Qps = float64(s.Count) / period.Seconds()
+	}
+	return s
+}
+
+// summary of stats
+type Summary struct {
+	Name   string        `json:"name"`
+	Count  int64         `json:"count"`
+	Errors int64         `json:"errors"`
+	Qps    float64       `json:"qps"`
+	Min    time.Duration `json:"min"`
+	Mean   time.Duration `json:"mean"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P99    time.Duration `json:"p99"`
+	P999   time.Duration `json:"p999"`
+	Max    time.Duration `json:"max"`
+}
+
+// load test report
+type Report struct {
+	// num of clients
+	Clients int `json:"clients"`
+	// max num of clients online at the same time
+	MaxOnline int64 `json:"max_online"`
+	// num of failed connects
+	ConnectErrors int64 `json:"connect_errors"`
+	// num of clients disconnected by server
+	Disconnected int64 `json:"disconnected"`
+	// total time, including ramp
+	Elapsed time.Duration `json:"elapsed"`
+	// period of actions after ramp, qps of actions is counted in it
+	Period time.Duration `json:"period"`
+	// payload bytes sent
+	SentBytes int64 `json:"sent_bytes"`
+	// connect latency, qps is counted in ramp
+	Connect Summary `json:"connect"`
+	// stats of each action
+	Actions []Summary `json:"actions"`
+	// stats of all actions
+	Total Summary `json:"total"`
+}
+
+// report of load test
+func (lt *loadTest) report(elapsed time.Duration) *Report {
+	period := elapsed - lt.cfg.Ramp
+	r := &Report{
+		Clients:       lt.cfg.Clients,
+		MaxOnline:     atomic.LoadInt64(&lt.maxOnline),
+		ConnectErrors: atomic.LoadInt64(&lt.connect.errors),
+		Disconnected:  atomic.LoadInt64(&lt.disconnected),
+		Elapsed:       elapsed,
+		Period:        period,
+		SentBytes:     atomic.LoadInt64(&lt.sentBytes),
+		Connect:       lt.connect.summary(lt.cfg.Ramp),
+	}
+	total := &Stats{Name: "total"}
+	for _, stats := range lt.stats {
+		r.Actions = append(r.Actions, stats.summary(period))
+		total.hist.Merge(&stats.hist)
+		total.errors += atomic.LoadInt64(&stats.errors)
+	}
+	r.Total = total.summary(period)
+	return r
+}
+
+// text report
+func (r *Report) String() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "clients: %d, max online: %d, connect errors: %d, disconnected: %d\n", r.Clients, r.MaxOnline, r.ConnectErrors, r.Disconnected)
+	fmt.Fprintf(buf, "elapsed: %v, period: %v, sent: %d bytes\n", r.Elapsed.Round(time.Millisecond), r.Period.Round(time.Millisecond), r.SentBytes)
+	fmt.Fprintf(buf, "%-16s %10s %8s %10s %10s %10s %10s %10s %10s %10s %10s\n", "name", "count", "errors", "qps", "min", "mean", "p50", "p90", "p99", "p99.9", "max")
+	line := func(s Summary) {
+		d := func(v time.Duration) string {
+			return v.Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(buf, "%-16s %10d %8d %10.1f %10s %10s %10s %10s %10s %10s %10s\n", s.Name, s.Count, s.Errors, s.Qps, d(s.Min), d(s.Mean), d(s.P50), d(s.P90), d(s.P99), d(s.P999), d(s.Max))
+	}
+	line(r.Connect)
+	for _, s := range r.Actions {
+		line(s)
+	}
+	if len(r.Actions) > 1 {
+		line(r.Total)
+	}
+	return buf.String()
+}
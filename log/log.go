@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -37,6 +38,8 @@ var (
 	filepaths = []string{}
 
 	DefaultLogger = NewLogger()
+
+	traceIdFunc atomic.Value
 )
 
 // init
@@ -126,20 +129,32 @@ func LevelText(lvl int) string {
 	case LEVEL_FATAL:
 		return "Fatal"
 	case LEVEL_NONE:
+		return "None"
 	default:
 	}
 	return "Unknown LVL"
 }
 
+// parse level text, case insensitive, such as "debug" or "Warn"
+func ParseLevel(text string) (int, error) {
+	for lvl := LEVEL_PRINT; lvl <= LEVEL_NONE; lvl++ {
+		if strings.EqualFold(text, LevelText(lvl)) {
+			return lvl, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q", text)
+}
+
 // log item
 type Log struct {
-	Time   time.Time `json:"Time"`
-	Depth  int       `json:"Depth"`
-	Level  int       `json:"Level"`
-	Line   int       `json:"Line"`
-	File   string    `json:"File"`
-	Value  string    `json:"Value"`
-	Logger *Logger   `json:"-"`
+	Time    time.Time `json:"Time"`
+	Depth   int       `json:"Depth"`
+	Level   int       `json:"Level"`
+	Line    int       `json:"Line"`
+	File    string    `json:"File"`
+	Value   string    `json:"Value"`
+	TraceId string    `json:"TraceId,omitempty"`
+	Logger  *Logger   `json:"-"`
 }
 
 // log writer interface
@@ -175,11 +190,14 @@ type Logger struct {
 	Writer    io.Writer
 	LogWriter ILogWriter
 	depth     int
-	Level     int
+	Level     int // Deprecated: not used by logger, use SetLevel and GetLevel
 	Layout    string
 	Formater  func(log *Log) string
 	FullPath  bool
 	// filepaths []string
+
+	// level is accessed atomically, for it is read by all logging goroutines
+	level int32
 }
 
 // func (logger *Logger) AddFileIgnorePath(path string) {
@@ -236,15 +254,16 @@ func (logger *Logger) Println(v ...interface{}) {
 
 // debug
 func (logger *Logger) Debug(format string, v ...interface{}) {
-	if LEVEL_DEBUG >= logger.Level {
+	if LEVEL_DEBUG >= logger.GetLevel() {
 		logger.Lock()
 		now := time.Now()
 		log := &Log{
-			Time:   now,
-			Depth:  logger.depth,
-			Level:  LEVEL_DEBUG,
-			Value:  fmt.Sprintf(format, v...),
-			Logger: logger,
+			Time:    now,
+			Depth:   logger.depth,
+			Level:   LEVEL_DEBUG,
+			Value:   fmt.Sprintf(format, v...),
+			TraceId: traceId(),
+			Logger:  logger,
 		}
 		if logger.Writer != nil {
 			fmt.Fprintln(logger.Writer, logger.Formater(log))
@@ -258,15 +277,16 @@ func (logger *Logger) Debug(format string, v ...interface{}) {
 
 // info
 func (logger *Logger) Info(format string, v ...interface{}) {
-	if LEVEL_INFO >= logger.Level {
+	if LEVEL_INFO >= logger.GetLevel() {
 		logger.Lock()
 		now := time.Now()
 		log := &Log{
-			Time:   now,
-			Depth:  logger.depth,
-			Level:  LEVEL_INFO,
-			Value:  fmt.Sprintf(format, v...),
-			Logger: logger,
+			Time:    now,
+			Depth:   logger.depth,
+			Level:   LEVEL_INFO,
+			Value:   fmt.Sprintf(format, v...),
+			TraceId: traceId(),
+			Logger:  logger,
 		}
 		if logger.Writer != nil {
 			fmt.Fprintln(logger.Writer, logger.Formater(log))
@@ -280,15 +300,16 @@ func (logger *Logger) Info(format string, v ...interface{}) {
 
 // warn
 func (logger *Logger) Warn(format string, v ...interface{}) {
-	if LEVEL_WARN >= logger.Level {
+	if LEVEL_WARN >= logger.GetLevel() {
 		logger.Lock()
 		now := time.Now()
 		log := &Log{
-			Time:   now,
-			Depth:  logger.depth,
-			Level:  LEVEL_WARN,
-			Value:  fmt.Sprintf(format, v...),
-			Logger: logger,
+			Time:    now,
+			Depth:   logger.depth,
+			Level:   LEVEL_WARN,
+			Value:   fmt.Sprintf(format, v...),
+			TraceId: traceId(),
+			Logger:  logger,
 		}
 		if logger.Writer != nil {
 			fmt.Fprintln(logger.Writer, logger.Formater(log))
@@ -302,15 +323,16 @@ func (logger *Logger) Warn(format string, v ...interface{}) {
 
 // error
 func (logger *Logger) Error(format string, v ...interface{}) {
-	if LEVEL_ERROR >= logger.Level {
+	if LEVEL_ERROR >= logger.GetLevel() {
 		logger.Lock()
 		now := time.Now()
 		log := &Log{
-			Time:   now,
-			Depth:  logger.depth,
-			Level:  LEVEL_ERROR,
-			Value:  fmt.Sprintf(format, v...),
-			Logger: logger,
+			Time:    now,
+			Depth:   logger.depth,
+			Level:   LEVEL_ERROR,
+			Value:   fmt.Sprintf(format, v...),
+			TraceId: traceId(),
+			Logger:  logger,
 		}
 		if logger.Writer != nil {
 			fmt.Fprintln(logger.Writer, logger.Formater(log))
@@ -324,15 +346,16 @@ func (logger *Logger) Error(format string, v ...interface{}) {
 
 // panic
 func (logger *Logger) Panic(format string, v ...interface{}) {
-	if LEVEL_PANIC >= logger.Level {
+	if LEVEL_PANIC >= logger.GetLevel() {
 		logger.Lock()
 		now := time.Now()
 		log := &Log{
-			Time:   now,
-			Depth:  logger.depth,
-			Level:  LEVEL_PANIC,
-			Value:  fmt.Sprintf(format, v...),
-			Logger: logger,
+			Time:    now,
+			Depth:   logger.depth,
+			Level:   LEVEL_PANIC,
+			Value:   fmt.Sprintf(format, v...),
+			TraceId: traceId(),
+			Logger:  logger,
 		}
 		s := logger.Formater(log)
 		if logger.Writer != nil {
@@ -348,15 +371,16 @@ func (logger *Logger) Panic(format string, v ...interface{}) {
 
 // fatal
 func (logger *Logger) Fatal(format string, v ...interface{}) {
-	if LEVEL_FATAL >= logger.Level {
+	if LEVEL_FATAL >= logger.GetLevel() {
 		logger.Lock()
 		now := time.Now()
 		log := &Log{
-			Time:   now,
-			Depth:  logger.depth,
-			Level:  LEVEL_FATAL,
-			Value:  fmt.Sprintf(format, v...),
-			Logger: logger,
+			Time:    now,
+			Depth:   logger.depth,
+			Level:   LEVEL_FATAL,
+			Value:   fmt.Sprintf(format, v...),
+			TraceId: traceId(),
+			Logger:  logger,
 		}
 		if logger.Writer != nil {
 			fmt.Fprintln(logger.Writer, logger.Formater(log))
@@ -372,12 +396,17 @@ func (logger *Logger) Fatal(format string, v ...interface{}) {
 // set level
 func (logger *Logger) SetLevel(level int) {
 	if level >= 0 && level <= LEVEL_NONE {
-		logger.Level = level
+		atomic.StoreInt32(&logger.level, int32(level))
 	} else {
 		log.Fatal(fmt.Errorf("log SetLogLevel Error: Invalid Level - %d\n", level))
 	}
 }
 
+// get level
+func (logger *Logger) GetLevel() int {
+	return int(atomic.LoadInt32(&logger.level))
+}
+
 // set output
 func (logger *Logger) SetOutput(out io.Writer) {
 	logger.Writer = out
@@ -422,19 +451,23 @@ func (logger *Logger) defaultLogFormater(log *Log) string {
 
 	log.File = file
 	log.Line = line
+	value := log.Value
+	if log.TraceId != "" {
+		value = "[trace:" + log.TraceId + "] " + value
+	}
 	switch log.Level {
 	case LEVEL_DEBUG:
-		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [Debug] [%s:%d] ", file, line), log.Value}, "")
+		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [Debug] [%s:%d] ", file, line), value}, "")
 	case LEVEL_INFO:
-		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [ Info] [%s:%d] ", file, line), log.Value}, "")
+		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [ Info] [%s:%d] ", file, line), value}, "")
 	case LEVEL_WARN:
-		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [ Warn] [%s:%d] ", file, line), log.Value}, "")
+		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [ Warn] [%s:%d] ", file, line), value}, "")
 	case LEVEL_ERROR:
-		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [Error] [%s:%d] ", file, line), log.Value}, "")
+		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [Error] [%s:%d] ", file, line), value}, "")
 	case LEVEL_PANIC:
-		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [Panic] [%s:%d] ", file, line), log.Value}, "")
+		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [Panic] [%s:%d] ", file, line), value}, "")
 	case LEVEL_FATAL:
-		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [Fatal] [%s:%d] ", file, line), log.Value}, "")
+		return strings.Join([]string{log.Time.Format(logger.Layout), fmt.Sprintf(" [Fatal] [%s:%d] ", file, line), value}, "")
 	default:
 	}
 	return ""
@@ -490,6 +523,11 @@ func SetLevel(level int) {
 	DefaultLogger.SetLevel(level)
 }
 
+// default get level
+func GetLevel() int {
+	return DefaultLogger.GetLevel()
+}
+
 // default set output
 func SetOutput(out io.Writer) {
 	DefaultLogger.SetOutput(out)
@@ -557,10 +595,25 @@ func LogWithFormater(lvl int, depth int, layout string, format string, v ...inte
 	return ""
 }
 
+// set func which returns trace id of current goroutine, such as trace.ActiveTraceId.
+// the trace id is added to log lines if not empty
+func SetTraceIdFunc(f func() string) {
+	traceIdFunc.Store(f)
+}
+
+// trace id of current goroutine
+func traceId() string {
+	if f, ok := traceIdFunc.Load().(func() string); ok && f != nil {
+		return f()
+	}
+	return ""
+}
+
 // logger factory
 func NewLogger() *Logger {
 	logger := &Logger{
 		Level:    DefaultLogLevel,
+		level:    int32(DefaultLogLevel),
 		depth:    DefaultLogDepth,
 		Writer:   DefaultLogWriter,
 		Layout:   DefaultLogTimeLayout,
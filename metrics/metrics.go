@@ -0,0 +1,453 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// default histogram buckets in seconds
+	DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// metric types
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// const labels of a series
+type Labels map[string]string
+
+// metric exposed by registry
+type Metric interface {
+	// metric name
+	Name() string
+
+	// help text
+	Help() string
+
+	// counter, gauge or histogram
+	Type() string
+
+	// append samples of all series
+	collect(samples []sample) []sample
+}
+
+// one line of exposition
+type sample struct {
+	suffix string
+	labels []labelPair
+	value  float64
+}
+
+type labelPair struct {
+	name  string
+	value string
+}
+
+// name, help and label names shared by all series of a metric
+type desc struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+}
+
+func (d *desc) Name() string {
+	return d.name
+}
+
+func (d *desc) Help() string {
+	return d.help
+}
+
+func (d *desc) Type() string {
+	return d.typ
+}
+
+// pair label names with values
+func (d *desc) pairs(values []string) []labelPair {
+	pairs := make([]labelPair, len(d.labelNames))
+	for i, name := range d.labelNames {
+		pairs[i] = labelPair{name, values[i]}
+	}
+	return pairs
+}
+
+// float64 updated atomically
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		if atomic.CompareAndSwapUint64(&f.bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// monotonically increasing counter
+type Counter struct {
+	value atomicFloat
+}
+
+// add v, v should not be negative
+func (c *Counter) Add(v float64) {
+	c.value.add(v)
+}
+
+// add 1
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// current value
+func (c *Counter) Value() float64 {
+	return c.value.load()
+}
+
+// gauge
+type Gauge struct {
+	value atomicFloat
+}
+
+// set value
+func (g *Gauge) Set(v float64) {
+	g.value.set(v)
+}
+
+// add v
+func (g *Gauge) Add(v float64) {
+	g.value.add(v)
+}
+
+// add 1
+func (g *Gauge) Inc() {
+	g.value.add(1)
+}
+
+// sub 1
+func (g *Gauge) Dec() {
+	g.value.add(-1)
+}
+
+// current value
+func (g *Gauge) Value() float64 {
+	return g.value.load()
+}
+
+// histogram with cumulative buckets
+type Histogram struct {
+	upper  []float64
+	counts []uint64
+	count  uint64
+	sum    atomicFloat
+}
+
+// observe v
+func (h *Histogram) Observe(v float64) {
+	idx := sort.SearchFloat64s(h.upper, v)
+	if idx < len(h.upper) {
+		atomic.AddUint64(&h.counts[idx], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.add(v)
+}
+
+// observe seconds since begin
+func (h *Histogram) Since(begin time.Time) {
+	h.Observe(time.Since(begin).Seconds())
+}
+
+// num of observations
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// sum of observations
+func (h *Histogram) Sum() float64 {
+	return h.sum.load()
+}
+
+func (h *Histogram) collect(samples []sample, labels []labelPair) []sample {
+	cumulative := uint64(0)
+	for i, upper := range h.upper {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		samples = append(samples, sample{"_bucket", append(labels[:len(labels):len(labels)], labelPair{"le", formatFloat(upper)}), float64(cumulative)})
+	}
+	count := atomic.LoadUint64(&h.count)
+	samples = append(samples,
+		sample{"_bucket", append(labels[:len(labels):len(labels)], labelPair{"le", "+Inf"}), float64(count)},
+		sample{"_sum", labels, h.sum.load()},
+		sample{"_count", labels, float64(count)},
+	)
+	return samples
+}
+
+// histogram factory, buckets are sorted and DefaultBuckets is used if empty
+func newHistogram(buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	upper := append([]float64{}, buckets...)
+	sort.Float64s(upper)
+	return &Histogram{
+		upper:  upper,
+		counts: make([]uint64, len(upper)),
+	}
+}
+
+// series of a vec
+type vecSeries struct {
+	labels []labelPair
+	metric interface{}
+}
+
+// series by label values
+type vec struct {
+	desc
+	sync.RWMutex
+	series map[string]*vecSeries
+	create func() interface{}
+}
+
+// get or create series by label values, missing values are empty
+func (v *vec) with(values []string) interface{} {
+	if len(values) != len(v.labelNames) {
+		values = append(values[:len(values):len(values)], make([]string, len(v.labelNames))...)[:len(v.labelNames)]
+	}
+	key := strings.Join(values, "\xff")
+
+	v.RLock()
+	s, ok := v.series[key]
+	v.RUnlock()
+	if ok {
+		return s.metric
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = &vecSeries{labels: v.pairs(values), metric: v.create()}
+		v.series[key] = s
+	}
+	return s.metric
+}
+
+// delete series by label values
+func (v *vec) Delete(values ...string) {
+	v.Lock()
+	delete(v.series, strings.Join(values, "\xff"))
+	v.Unlock()
+}
+
+// series sorted by label values
+func (v *vec) sorted() []*vecSeries {
+	v.RLock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := make([]*vecSeries, len(keys))
+	for i, k := range keys {
+		series[i] = v.series[k]
+	}
+	v.RUnlock()
+	return series
+}
+
+func newVec(name, help, typ string, labelNames []string, create func() interface{}) vec {
+	return vec{
+		desc:   desc{name: name, help: help, typ: typ, labelNames: labelNames},
+		series: map[string]*vecSeries{},
+		create: create,
+	}
+}
+
+// counters partitioned by labels
+type CounterVec struct {
+	vec
+}
+
+// counter of label values
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.with(values).(*Counter)
+}
+
+func (v *CounterVec) collect(samples []sample) []sample {
+	for _, s := range v.sorted() {
+		samples = append(samples, sample{"", s.labels, s.metric.(*Counter).Value()})
+	}
+	return samples
+}
+
+// gauges partitioned by labels
+type GaugeVec struct {
+	vec
+}
+
+// gauge of label values
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.with(values).(*Gauge)
+}
+
+func (v *GaugeVec) collect(samples []sample) []sample {
+	for _, s := range v.sorted() {
+		samples = append(samples, sample{"", s.labels, s.metric.(*Gauge).Value()})
+	}
+	return samples
+}
+
+// histograms partitioned by labels
+type HistogramVec struct {
+	vec
+}
+
+// histogram of label values
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.with(values).(*Histogram)
+}
+
+func (v *HistogramVec) collect(samples []sample) []sample {
+	for _, s := range v.sorted() {
+		samples = s.metric.(*Histogram).collect(samples, s.labels)
+	}
+	return samples
+}
+
+// gauge evaluated when collected
+type GaugeFunc struct {
+	desc
+	labels []labelPair
+	fn     func() float64
+}
+
+func (g *GaugeFunc) collect(samples []sample) []sample {
+	return append(samples, sample{"", g.labels, g.fn()})
+}
+
+// single series metrics
+type counterMetric struct {
+	desc
+	*Counter
+}
+
+func (c *counterMetric) collect(samples []sample) []sample {
+	return append(samples, sample{"", nil, c.Value()})
+}
+
+type gaugeMetric struct {
+	desc
+	*Gauge
+}
+
+func (g *gaugeMetric) collect(samples []sample) []sample {
+	return append(samples, sample{"", nil, g.Value()})
+}
+
+type histogramMetric struct {
+	desc
+	*Histogram
+}
+
+func (h *histogramMetric) collect(samples []sample) []sample {
+	return h.Histogram.collect(samples, nil)
+}
+
+// counter vec factory
+func newCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, TypeCounter, labelNames, func() interface{} { return &Counter{} })}
+}
+
+// gauge vec factory
+func newGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, TypeGauge, labelNames, func() interface{} { return &Gauge{} })}
+}
+
+// histogram vec factory
+func newHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{newVec(name, help, TypeHistogram, labelNames, func() interface{} { return newHistogram(buckets) })}
+}
+
+// gauge func factory
+func newGaugeFunc(name, help string, labels Labels, fn func() float64) *GaugeFunc {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	pairs := make([]labelPair, len(names))
+	for i, k := range names {
+		pairs[i] = labelPair{k, labels[k]}
+	}
+	return &GaugeFunc{desc: desc{name: name, help: help, typ: TypeGauge, labelNames: names}, labels: pairs, fn: fn}
+}
+
+// counter registered to default registry
+func NewCounter(name, help string) *Counter {
+	m := &counterMetric{desc{name: name, help: help, typ: TypeCounter}, &Counter{}}
+	DefaultRegistry.MustRegister(m)
+	return m.Counter
+}
+
+// gauge registered to default registry
+func NewGauge(name, help string) *Gauge {
+	m := &gaugeMetric{desc{name: name, help: help, typ: TypeGauge}, &Gauge{}}
+	DefaultRegistry.MustRegister(m)
+	return m.Gauge
+}
+
+// histogram registered to default registry, DefaultBuckets is used if buckets is empty
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	m := &histogramMetric{desc{name: name, help: help, typ: TypeHistogram}, newHistogram(buckets)}
+	DefaultRegistry.MustRegister(m)
+	return m.Histogram
+}
+
+// counter vec registered to default registry
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	v := newCounterVec(name, help, labelNames...)
+	DefaultRegistry.MustRegister(v)
+	return v
+}
+
+// gauge vec registered to default registry
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	v := newGaugeVec(name, help, labelNames...)
+	DefaultRegistry.MustRegister(v)
+	return v
+}
+
+// histogram vec registered to default registry, DefaultBuckets is used if buckets is empty
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	v := newHistogramVec(name, help, buckets, labelNames...)
+	DefaultRegistry.MustRegister(v)
+	return v
+}
+
+// gauge func registered to default registry, unregister it when the source is released.
+// gauge funcs with the same name must have different labels
+func NewGaugeFunc(name, help string, labels Labels, fn func() float64) (*GaugeFunc, error) {
+	g := newGaugeFunc(name, help, labels, fn)
+	if err := DefaultRegistry.Register(g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+
+	c := newCounterVec("test_requests_total", "requests", "path")
+	r.MustRegister(c)
+	c.With("/a").Inc()
+	c.With("/a").Add(2)
+	c.With("/b\"").Inc()
+
+	h := newHistogramVec("test_duration_seconds", "latency", []float64{1, 0.1}, "path")
+	r.MustRegister(h)
+	h.With("/a").Observe(0.05)
+	h.With("/a").Observe(0.5)
+	h.With("/a").Observe(5)
+
+	g1 := newGaugeFunc("test_size", "size\nof queue", Labels{"q": "1"}, func() float64 { return 3 })
+	g2 := newGaugeFunc("test_size", "size\nof queue", Labels{"q": "2"}, func() float64 { return 4 })
+	r.MustRegister(g1)
+	r.MustRegister(g2)
+	if err := r.Register(newGaugeFunc("test_size", "", Labels{"q": "1"}, nil)); err != ErrMetricExists {
+		t.Fatalf("duplicated gauge func should fail: %v", err)
+	}
+	if err := r.Register(newCounterVec("test_size", "")); err != ErrMetricTypeMismatch {
+		t.Fatalf("type mismatch should fail: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	r.WritePrometheus(buf)
+	expected := `# HELP test_duration_seconds latency
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{path="/a",le="0.1"} 1
+test_duration_seconds_bucket{path="/a",le="1"} 2
+test_duration_seconds_bucket{path="/a",le="+Inf"} 3
+test_duration_seconds_sum{path="/a"} 5.55
+test_duration_seconds_count{path="/a"} 3
+# HELP test_requests_total requests
+# TYPE test_requests_total counter
+test_requests_total{path="/a"} 3
+test_requests_total{path="/b\""} 1
+# HELP test_size size\nof queue
+# TYPE test_size gauge
+test_size{q="1"} 3
+test_size{q="2"} 4
+`
+	if buf.String() != expected {
+		t.Fatalf("invalid output:\n%v", buf.String())
+	}
+
+	r.Unregister(g1)
+	r.Unregister(g2)
+	buf.Reset()
+	r.WritePrometheus(buf)
+	if strings.Contains(buf.String(), "test_size") {
+		t.Fatalf("unregistered metric should not be written:\n%v", buf.String())
+	}
+}
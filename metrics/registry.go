@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// default registry used by NewXXX factories
+	DefaultRegistry = NewRegistry()
+
+	ErrMetricExists       = errors.New("metric exists")
+	ErrMetricTypeMismatch = errors.New("metric type mismatch")
+)
+
+// content type of prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metrics with the same name
+type family struct {
+	typ     string
+	help    string
+	metrics []Metric
+}
+
+// metric registry
+type Registry struct {
+	sync.Mutex
+	families map[string]*family
+}
+
+// register metric, only gauge funcs with different labels can share a name
+func (r *Registry) Register(m Metric) error {
+	r.Lock()
+	defer r.Unlock()
+
+	f, ok := r.families[m.Name()]
+	if !ok {
+		r.families[m.Name()] = &family{typ: m.Type(), help: m.Help(), metrics: []Metric{m}}
+		return nil
+	}
+	if f.typ != m.Type() {
+		return ErrMetricTypeMismatch
+	}
+	g, ok := m.(*GaugeFunc)
+	if !ok {
+		return ErrMetricExists
+	}
+	for _, v := range f.metrics {
+		other, ok := v.(*GaugeFunc)
+		if !ok || labelsEqual(other.labels, g.labels) {
+			return ErrMetricExists
+		}
+	}
+	f.metrics = append(f.metrics, m)
+	return nil
+}
+
+// register metric, panic if failed
+func (r *Registry) MustRegister(m Metric) {
+	if err := r.Register(m); err != nil {
+		panic(fmt.Errorf("register metric %v failed: %v", m.Name(), err))
+	}
+}
+
+// unregister metric
+func (r *Registry) Unregister(m Metric) {
+	r.Lock()
+	defer r.Unlock()
+
+	f, ok := r.families[m.Name()]
+	if !ok {
+		return
+	}
+	for i, v := range f.metrics {
+		if v == m {
+			f.metrics = append(f.metrics[:i], f.metrics[i+1:]...)
+			break
+		}
+	}
+	if len(f.metrics) == 0 {
+		delete(r.families, m.Name())
+	}
+}
+
+// write all metrics in prometheus text format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.Lock()
+	names := make([]string, 0, len(r.families))
+	families := make(map[string]family, len(r.families))
+	for name, f := range r.families {
+		names = append(names, name)
+		families[name] = family{typ: f.typ, help: f.help, metrics: append([]Metric{}, f.metrics...)}
+	}
+	r.Unlock()
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	samples := []sample{}
+	for _, name := range names {
+		f := families[name]
+		samples = samples[:0]
+		for _, m := range f.metrics {
+			samples = m.collect(samples)
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		if f.help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeHelp(f.help))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.typ)
+		for _, s := range samples {
+			bw.WriteString(name)
+			bw.WriteString(s.suffix)
+			if len(s.labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range s.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(l.name)
+					bw.WriteString(`="`)
+					bw.WriteString(escapeLabel(l.value))
+					bw.WriteByte('"')
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatFloat(s.value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// serve metrics in prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WritePrometheus(w)
+}
+
+// registry factory
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// register metric to default registry
+func Register(m Metric) error {
+	return DefaultRegistry.Register(m)
+}
+
+// unregister metric from default registry
+func Unregister(m Metric) {
+	DefaultRegistry.Unregister(m)
+}
+
+// write default registry in prometheus text format
+func WritePrometheus(w io.Writer) error {
+	return DefaultRegistry.WritePrometheus(w)
+}
+
+// http handler of default registry
+func Handler() http.Handler {
+	return DefaultRegistry
+}
+
+func labelsEqual(a, b []labelPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/metrics"
 	"github.com/nothollyhigh/kiss/util"
 	"gopkg.in/mgo.v2"
 	"sort"
@@ -79,6 +80,7 @@ type Mongo struct {
 	chSession chan *MongoSessionWrap
 	sessions  []*MongoSessionWrap
 	chStop    chan util.Empty
+	gauge     *metrics.GaugeFunc
 }
 
 func (m *Mongo) Session() *MongoSessionWrap {
@@ -134,6 +136,9 @@ func (m *Mongo) Keepalive() {
 
 func (m *Mongo) Stop() {
 	m.ticker.Stop()
+	if m.gauge != nil {
+		metrics.Unregister(m.gauge)
+	}
 }
 
 func New(conf Config) *Mongo {
@@ -195,6 +200,10 @@ func New(conf Config) *Mongo {
 
 	util.Go(mongo.Keepalive)
 
+	mongo.gauge, _ = metrics.NewGaugeFunc("kiss_mongo_sessions_available", "mongo sessions available in pool", metrics.Labels{"mongo": conf.ID}, func() float64 {
+		return float64(len(mongo.chSession))
+	})
+
 	log.Info("mongo.New(pool size: %d) Connect To Mongo Success", len(mongo.sessions))
 
 	return mongo
@@ -6,6 +6,7 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
 	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/metrics"
 	"github.com/nothollyhigh/kiss/util"
 	"sort"
 	"strings"
@@ -25,6 +26,7 @@ type Config struct {
 type Mysql struct {
 	db     *gorm.DB
 	ticker *time.Ticker
+	gauges []*metrics.GaugeFunc
 	Conf   Config
 }
 
@@ -37,9 +39,29 @@ func (msql *Mysql) DB() *sql.DB {
 }
 
 func (msql *Mysql) Close() error {
+	for _, g := range msql.gauges {
+		metrics.Unregister(g)
+	}
 	return msql.db.Close()
 }
 
+// register connection pool gauges
+func (msql *Mysql) instrument() {
+	for state, fn := range map[string]func(sql.DBStats) int{
+		"open":  func(s sql.DBStats) int { return s.OpenConnections },
+		"inuse": func(s sql.DBStats) int { return s.InUse },
+		"idle":  func(s sql.DBStats) int { return s.Idle },
+	} {
+		fn := fn
+		g, err := metrics.NewGaugeFunc("kiss_mysql_pool_connections", "mysql pool connections", metrics.Labels{"mysql": msql.Conf.ID, "state": state}, func() float64 {
+			return float64(fn(msql.DB().Stats()))
+		})
+		if err == nil {
+			msql.gauges = append(msql.gauges, g)
+		}
+	}
+}
+
 func New(conf Config) *Mysql {
 	if conf.ConnString == "" {
 		log.Fatal("msyql.New Failed: invalid ConnString")
@@ -72,6 +94,7 @@ func New(conf Config) *Mysql {
 	}
 
 	msql := &Mysql{db: db, ticker: time.NewTicker(conf.keepaliveInterval), Conf: conf}
+	msql.instrument()
 
 	util.Go(func() {
 		for {
@@ -0,0 +1,364 @@
+package net
+
+import (
+	"fmt"
+	"github.com/nothollyhigh/kiss/log"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+)
+
+const (
+	// max length of user data summary
+	adminUserDataMaxLen = 128
+)
+
+// session shown by admin
+type AdminSession struct {
+	Id        string `json:"id"`
+	Proto     string `json:"proto"`
+	Server    string `json:"server"`
+	Ip        string `json:"ip"`
+	RealIp    string `json:"real_ip,omitempty"`
+	RecvSeq   int64  `json:"recv_seq"`
+	SendSeq   int64  `json:"send_seq"`
+	SendQueue int    `json:"send_queue"`
+	UserData  string `json:"user_data,omitempty"`
+}
+
+// cmds and rpc methods registered on an engine
+type AdminHandlers struct {
+	Name    string   `json:"name"`
+	Proto   string   `json:"proto"`
+	Cmds    []uint32 `json:"cmds"`
+	Methods []string `json:"methods"`
+}
+
+// module shown by admin, such as graceful module
+type AdminModule struct {
+	Name     string `json:"name"`
+	QueueLen int    `json:"queue_len"`
+}
+
+// timer shown by admin
+type AdminTimer struct {
+	Tag  string `json:"tag"`
+	Size int    `json:"size"`
+}
+
+// timer with size, such as *timer.Timer
+type AdminSizer interface {
+	Size() int
+}
+
+// admin endpoints of kiss runtime: sessions, handlers, modules, timers, kick and log level
+type Admin struct {
+	sync.Mutex
+	tcpServers []*TcpServer
+	wsServers  []*WSServer
+	engines    map[string]*Engine
+	timers     map[string]AdminSizer
+
+	modulesHandler  func() []AdminModule
+	userDataHandler func(data interface{}) string
+}
+
+// add tcp server whose sessions and handlers are shown
+func (admin *Admin) AddTcpServer(server *TcpServer) {
+	admin.Lock()
+	admin.tcpServers = append(admin.tcpServers, server)
+	admin.Unlock()
+}
+
+// add websocket server whose sessions and handlers are shown
+func (admin *Admin) AddWSServer(server *WSServer) {
+	admin.Lock()
+	admin.wsServers = append(admin.wsServers, server)
+	admin.Unlock()
+}
+
+// add transport agnostic engine whose handlers are shown
+func (admin *Admin) AddEngine(name string, engine *Engine) {
+	admin.Lock()
+	admin.engines[name] = engine
+	admin.Unlock()
+}
+
+// add timer whose size is shown
+func (admin *Admin) AddTimer(tag string, timer AdminSizer) {
+	admin.Lock()
+	admin.timers[tag] = timer
+	admin.Unlock()
+}
+
+// setting modules handler, such as graceful.AdminModules
+func (admin *Admin) HandleModules(h func() []AdminModule) {
+	admin.modulesHandler = h
+}
+
+// setting user data summary handler, fmt "%v" of user data is used by default
+func (admin *Admin) HandleUserData(h func(data interface{}) string) {
+	admin.userDataHandler = h
+}
+
+// user data summary
+func (admin *Admin) userData(data interface{}) string {
+	if data == nil {
+		return ""
+	}
+	s := ""
+	if admin.userDataHandler != nil {
+		s = admin.userDataHandler(data)
+	} else {
+		s = fmt.Sprintf("%v", data)
+	}
+	if len(s) > adminUserDataMaxLen {
+		s = s[:adminUserDataMaxLen] + "..."
+	}
+	return s
+}
+
+// session id, unique while the session is alive
+func adminSessionId(sess interface{}) string {
+	return fmt.Sprintf("%p", sess)
+}
+
+func (admin *Admin) servers() ([]*TcpServer, []*WSServer) {
+	admin.Lock()
+	defer admin.Unlock()
+	return append([]*TcpServer{}, admin.tcpServers...), append([]*WSServer{}, admin.wsServers...)
+}
+
+// all sessions of added servers
+func (admin *Admin) Sessions() []AdminSession {
+	sessions := []AdminSession{}
+	tcpServers, wsServers := admin.servers()
+	for _, server := range tcpServers {
+		server.Lock()
+		for c := range server.clients {
+			sessions = append(sessions, AdminSession{
+				Id:        adminSessionId(c),
+				Proto:     metricProtoTcp,
+				Server:    server.tag,
+				Ip:        addrIp(c.conn.RemoteAddr()),
+				RealIp:    c.realIp,
+				RecvSeq:   c.RecvSeq(),
+				SendSeq:   c.SendSeq(),
+				SendQueue: len(c.chSend),
+				UserData:  admin.userData(c.UserData()),
+			})
+		}
+		server.Unlock()
+	}
+	for _, server := range wsServers {
+		server.Lock()
+		for c := range server.clients {
+			sessions = append(sessions, AdminSession{
+				Id:        adminSessionId(c),
+				Proto:     metricProtoWS,
+				Server:    server.tag,
+				Ip:        c.remoteIp(),
+				RealIp:    c.realIp,
+				RecvSeq:   c.RecvSeq(),
+				SendSeq:   c.SendSeq(),
+				SendQueue: len(c.chSend),
+				UserData:  admin.userData(c.UserData()),
+			})
+		}
+		server.Unlock()
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Server != sessions[j].Server {
+			return sessions[i].Server < sessions[j].Server
+		}
+		return sessions[i].Id < sessions[j].Id
+	})
+	return sessions
+}
+
+// stop session by id, returns false if not found
+func (admin *Admin) Kick(id string) bool {
+	tcpServers, wsServers := admin.servers()
+	for _, server := range tcpServers {
+		var found *TcpClient
+		server.Lock()
+		for c := range server.clients {
+			if adminSessionId(c) == id {
+				found = c
+				break
+			}
+		}
+		server.Unlock()
+		if found != nil {
+			log.Info("[Admin] kick tcp session %v, ip: %v", id, found.Ip())
+			found.Stop()
+			return true
+		}
+	}
+	for _, server := range wsServers {
+		var found *WSClient
+		server.Lock()
+		for c := range server.clients {
+			if adminSessionId(c) == id {
+				found = c
+				break
+			}
+		}
+		server.Unlock()
+		if found != nil {
+			log.Info("[Admin] kick websocket session %v, ip: %v", id, found.Ip())
+			found.Stop()
+			return true
+		}
+	}
+	return false
+}
+
+// user space cmds and rpc methods
+func adminHandlers(name, proto string, cmds []uint32, methods []string) AdminHandlers {
+	h := AdminHandlers{Name: name, Proto: proto, Cmds: []uint32{}, Methods: methods}
+	for _, cmd := range cmds {
+		if cmd <= CmdUserMax {
+			h.Cmds = append(h.Cmds, cmd)
+		}
+	}
+	sort.Slice(h.Cmds, func(i, j int) bool { return h.Cmds[i] < h.Cmds[j] })
+	sort.Strings(h.Methods)
+	return h
+}
+
+// registered cmds and rpc methods of added servers and engines
+func (admin *Admin) Handlers() []AdminHandlers {
+	handlers := []AdminHandlers{}
+	tcpServers, wsServers := admin.servers()
+	for _, server := range tcpServers {
+		cmds, methods := []uint32{}, []string{}
+		for cmd := range server.handlers {
+			cmds = append(cmds, cmd)
+		}
+		for method := range server.rpcMethodHandlers {
+			methods = append(methods, method)
+		}
+		handlers = append(handlers, adminHandlers(server.tag, metricProtoTcp, cmds, methods))
+	}
+	for _, server := range wsServers {
+		cmds, methods := []uint32{}, []string{}
+		for cmd := range server.handlers {
+			cmds = append(cmds, cmd)
+		}
+		for method := range server.rpcMethodHandlers {
+			methods = append(methods, method)
+		}
+		handlers = append(handlers, adminHandlers(server.tag, metricProtoWS, cmds, methods))
+	}
+
+	admin.Lock()
+	names := make([]string, 0, len(admin.engines))
+	for name := range admin.engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e := admin.engines[name]
+		cmds, methods := []uint32{}, []string{}
+		for cmd := range e.handlers {
+			cmds = append(cmds, cmd)
+		}
+		for method := range e.rpcMethodHandlers {
+			methods = append(methods, method)
+		}
+		handlers = append(handlers, adminHandlers(name, "engine", cmds, methods))
+	}
+	admin.Unlock()
+	return handlers
+}
+
+// modules returned by modules handler
+func (admin *Admin) Modules() []AdminModule {
+	if admin.modulesHandler == nil {
+		return []AdminModule{}
+	}
+	return admin.modulesHandler()
+}
+
+// sizes of added timers
+func (admin *Admin) Timers() []AdminTimer {
+	admin.Lock()
+	defer admin.Unlock()
+	timers := make([]AdminTimer, 0, len(admin.timers))
+	for tag, t := range admin.timers {
+		timers = append(timers, AdminTimer{Tag: tag, Size: t.Size()})
+	}
+	sort.Slice(timers, func(i, j int) bool { return timers[i].Tag < timers[j].Tag })
+	return timers
+}
+
+// write json response
+func adminWriteJson(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// serve admin endpoints by the last path element, so it can be mounted on any root.
+// kick and setting log level require POST
+func (admin *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path.Base(r.URL.Path) {
+	case "sessions":
+		sessions := admin.Sessions()
+		if server := r.FormValue("server"); server != "" {
+			filtered := []AdminSession{}
+			for _, s := range sessions {
+				if s.Server == server {
+					filtered = append(filtered, s)
+				}
+			}
+			sessions = filtered
+		}
+		adminWriteJson(w, http.StatusOK, sessions)
+	case "handlers":
+		adminWriteJson(w, http.StatusOK, admin.Handlers())
+	case "modules":
+		adminWriteJson(w, http.StatusOK, admin.Modules())
+	case "timers":
+		adminWriteJson(w, http.StatusOK, admin.Timers())
+	case "kick":
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.FormValue("id")
+		if !admin.Kick(id) {
+			adminWriteJson(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("session %v not found", id)})
+			return
+		}
+		adminWriteJson(w, http.StatusOK, map[string]string{"kicked": id})
+	case "loglevel":
+		if r.Method == http.MethodPost {
+			level, err := log.ParseLevel(r.FormValue("level"))
+			if err != nil {
+				adminWriteJson(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			log.Info("[Admin] set log level: %v", log.LevelText(level))
+			log.SetLevel(level)
+		}
+		adminWriteJson(w, http.StatusOK, map[string]string{"level": log.LevelText(log.GetLevel())})
+	default:
+		adminWriteJson(w, http.StatusOK, []string{"sessions", "handlers", "modules", "timers", "kick", "loglevel"})
+	}
+}
+
+// admin factory
+func NewAdmin() *Admin {
+	return &Admin{
+		engines: map[string]*Engine{},
+		timers:  map[string]AdminSizer{},
+	}
+}
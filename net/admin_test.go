@@ -0,0 +1,148 @@
+package net
+
+import (
+	"github.com/nothollyhigh/kiss/log"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type adminTestTimer int
+
+func (t adminTestTimer) Size() int {
+	return int(t)
+}
+
+func TestAdmin(t *testing.T) {
+	const cmdEcho = uint32(4323)
+
+	addr := freeTcpAddr(t)
+	server := NewTcpServer("admin")
+	server.Handle(cmdEcho, func(client *TcpClient, msg IMessage) {
+		client.SetUserData("player-1")
+		client.SendMsg(NewMessage(cmdEcho, msg.Body()))
+	})
+	server.HandleRpcMethod("Hello", func(ctx *RpcContext) {})
+	go server.Start(addr)
+	defer server.Stop()
+
+	chEcho := make(chan struct{}, 1)
+	engine := NewTcpEngine()
+	engine.Handle(cmdEcho, func(client *TcpClient, msg IMessage) {
+		chEcho <- struct{}{}
+	})
+	var client *TcpClient
+	var err error
+	for i := 0; i < 50; i++ {
+		if client, err = NewTcpClient(addr, engine, nil, false, nil); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatalf("NewTcpClient failed: %v", err)
+	}
+	defer client.Stop()
+	client.SendMsg(NewMessage(cmdEcho, []byte("hi")))
+	select {
+	case <-chEcho:
+	case <-time.After(time.Second * 3):
+		t.Fatalf("echo timeout")
+	}
+
+	admin := NewAdmin()
+	admin.AddTcpServer(server)
+	admin.AddTimer("logic", adminTestTimer(3))
+	admin.HandleModules(func() []AdminModule {
+		return []AdminModule{{Name: "game", QueueLen: 2}}
+	})
+
+	svr, err := NewHttpServer("admin", "127.0.0.1:0", nil, time.Second, nil, nil)
+	if err != nil {
+		t.Fatalf("NewHttpServer failed: %v", err)
+	}
+	svr.EnableAdmin("/admin/", admin)
+	go svr.Serve()
+	defer svr.Shutdown()
+	base := "http://" + svr.listener.Addr().String() + "/admin/"
+
+	get := func(path string, v interface{}) {
+		rsp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("get %v failed: %v", path, err)
+		}
+		defer rsp.Body.Close()
+		data, _ := ioutil.ReadAll(rsp.Body)
+		if err = json.Unmarshal(data, v); err != nil {
+			t.Fatalf("unmarshal %v failed: %v, %s", path, err, data)
+		}
+	}
+
+	sessions := []AdminSession{}
+	get("sessions", &sessions)
+	if len(sessions) != 1 || sessions[0].Server != "admin" || sessions[0].UserData != "player-1" || sessions[0].RecvSeq != 1 || sessions[0].Ip != "127.0.0.1" {
+		t.Fatalf("invalid sessions: %+v", sessions)
+	}
+
+	handlers := []AdminHandlers{}
+	get("handlers", &handlers)
+	if len(handlers) != 1 || len(handlers[0].Cmds) != 1 || handlers[0].Cmds[0] != cmdEcho || len(handlers[0].Methods) != 1 || handlers[0].Methods[0] != "Hello" {
+		t.Fatalf("invalid handlers: %+v", handlers)
+	}
+
+	modules := []AdminModule{}
+	get("modules", &modules)
+	if len(modules) != 1 || modules[0].QueueLen != 2 {
+		t.Fatalf("invalid modules: %+v", modules)
+	}
+
+	timers := []AdminTimer{}
+	get("timers", &timers)
+	if len(timers) != 1 || timers[0].Tag != "logic" || timers[0].Size != 3 {
+		t.Fatalf("invalid timers: %+v", timers)
+	}
+
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+	rsp, err := http.PostForm(base+"loglevel", url.Values{"level": {"warn"}})
+	if err != nil || rsp.StatusCode != http.StatusOK {
+		t.Fatalf("set log level failed: %v, %v", err, rsp)
+	}
+	rsp.Body.Close()
+	if log.GetLevel() != log.LEVEL_WARN {
+		t.Fatalf("log level should be warn, got %v", log.LevelText(log.GetLevel()))
+	}
+	if rsp, err = http.PostForm(base+"loglevel", url.Values{"level": {"none"}}); err != nil || rsp.StatusCode != http.StatusOK {
+		t.Fatalf("set log level failed: %v, %v", err, rsp)
+	}
+	data, _ := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	result := map[string]string{}
+	if err = json.Unmarshal(data, &result); err != nil || result["level"] != "None" {
+		t.Fatalf("log level should be None, got %v, %s", err, data)
+	}
+	if rsp, err = http.PostForm(base+"loglevel", url.Values{"level": {"verbose"}}); err != nil || rsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid log level should be rejected: %v, %v", err, rsp)
+	}
+	rsp.Body.Close()
+
+	if rsp, err = http.Get(base + "kick?id=" + sessions[0].Id); err != nil || rsp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("kick by get should be rejected: %v, %v", err, rsp)
+	}
+	rsp.Body.Close()
+	if rsp, err = http.PostForm(base+"kick", url.Values{"id": {sessions[0].Id}}); err != nil || rsp.StatusCode != http.StatusOK {
+		t.Fatalf("kick failed: %v, %v", err, rsp)
+	}
+	rsp.Body.Close()
+	for i := 0; i < 100 && server.CurrLoad() != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if server.CurrLoad() != 0 {
+		t.Fatalf("session should be kicked")
+	}
+	if admin.Kick(sessions[0].Id) {
+		t.Fatalf("kick of stopped session should fail")
+	}
+}
@@ -0,0 +1,343 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// capture file format, all integers are little endian:
+//
+//	file header: 8 bytes magic "KISSCAP\x01"
+//	record:      8 bytes timestamp, unix nano
+//	             8 bytes connection id, unique in the capture
+//	             1 byte  direction, 0: in, 1: out, 2: open, 3: close
+//	             1 byte  proto, 0: tcp, 1: websocket
+//	             4 bytes data length
+//	             data:   decrypted message (16 bytes header + body) for in and out, remote ip for open, empty for close
+//
+// raw data sent by SendData is encrypted already and not captured
+const (
+	// capture file magic
+	CaptureMagic = "KISSCAP\x01"
+
+	// record header length
+	captureRecordHeadLen = 8 + 8 + 1 + 1 + 4
+)
+
+// direction of capture record
+type CaptureDirection byte
+
+const (
+	CaptureIn CaptureDirection = iota
+	CaptureOut
+	CaptureOpen
+	CaptureClose
+)
+
+// direction name
+func (d CaptureDirection) String() string {
+	switch d {
+	case CaptureIn:
+		return "in"
+	case CaptureOut:
+		return "out"
+	case CaptureOpen:
+		return "open"
+	case CaptureClose:
+		return "close"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(d))
+}
+
+var (
+	// default max size of capture file before rotated
+	DefaultCaptureMaxSize int64 = 64 * 1024 * 1024
+	// default num of rotated capture files kept
+	DefaultCaptureMaxFiles = 5
+)
+
+// close handler tag of capture
+type captureCloseTag struct {
+	c *Capture
+}
+
+// capture of decrypted messages, written to a rotating file: path, path.1, ..., path.N
+type Capture struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	connId   uint64
+	conns    map[ISession]uint64
+	filter   func(sess ISession, msg IMessage) bool
+	closed   bool
+}
+
+// setting filter, only messages it returns true for are captured
+func (c *Capture) HandleFilter(filter func(sess ISession, msg IMessage) bool) {
+	c.Lock()
+	c.filter = filter
+	c.Unlock()
+}
+
+// open new capture file
+func (c *Capture) open() error {
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	c.file = file
+	c.writer = bufio.NewWriter(file)
+	c.size = int64(len(CaptureMagic))
+	_, err = c.writer.WriteString(CaptureMagic)
+	return err
+}
+
+// rotate capture files: path.N-1 -> path.N, ..., path -> path.1
+func (c *Capture) rotate() error {
+	c.writer.Flush()
+	c.file.Close()
+	for i := c.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", c.path, i), fmt.Sprintf("%s.%d", c.path, i+1))
+	}
+	if c.maxFiles > 0 {
+		os.Rename(c.path, c.path+".1")
+	}
+	return c.open()
+}
+
+// write record
+func (c *Capture) write(connId uint64, dir CaptureDirection, proto string, data []byte) {
+	if c.closed {
+		return
+	}
+	n := int64(captureRecordHeadLen + len(data))
+	if c.size > int64(len(CaptureMagic)) && c.size+n > c.maxSize {
+		if err := c.rotate(); err != nil {
+			c.closed = true
+			return
+		}
+	}
+	var head [captureRecordHeadLen]byte
+	binary.LittleEndian.PutUint64(head[0:8], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint64(head[8:16], connId)
+	head[16] = byte(dir)
+	if proto == metricProtoWS {
+		head[17] = 1
+	}
+	binary.LittleEndian.PutUint32(head[18:22], uint32(len(data)))
+	c.writer.Write(head[:])
+	c.writer.Write(data)
+	c.size += n
+}
+
+// record message of session, open record is written when a session is seen first
+func (c *Capture) record(sess ISession, proto string, dir CaptureDirection, msg IMessage) {
+	if c == nil || msg == nil {
+		return
+	}
+	c.Lock()
+	if c.closed || (c.filter != nil && !c.filter(sess, msg)) {
+		c.Unlock()
+		return
+	}
+	id, ok := c.conns[sess]
+	if !ok {
+		c.connId++
+		id = c.connId
+		c.conns[sess] = id
+		c.write(id, CaptureOpen, proto, []byte(sess.Ip()))
+	}
+	c.write(id, dir, proto, msg.Data())
+	c.Unlock()
+
+	// close handlers may be called with session locked, so register it without capture locked
+	if !ok {
+		sess.OnSessionClose(captureCloseTag{c}, func(sess ISession) {
+			c.Lock()
+			if id, ok := c.conns[sess]; ok {
+				delete(c.conns, sess)
+				c.write(id, CaptureClose, proto, nil)
+			}
+			c.Unlock()
+		})
+	}
+}
+
+// flush buffered records to file
+func (c *Capture) Flush() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil
+	}
+	return c.writer.Flush()
+}
+
+// flush and close capture file, records after closed are dropped
+func (c *Capture) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	err := c.writer.Flush()
+	if cerr := c.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// capture factory, file is rotated when exceeds maxSize and at most maxFiles rotated files are kept,
+// defaults are used if maxSize or maxFiles is not positive
+func NewCapture(path string, maxSize int64, maxFiles int) (*Capture, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultCaptureMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultCaptureMaxFiles
+	}
+	c := &Capture{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		conns:    map[ISession]uint64{},
+	}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// copy of message to be captured, nil if not capturing. outbound messages are copied before Encrypt,
+// which may compress the body and flag the cmd in place
+func (c *Capture) copy(msg IMessage) IMessage {
+	if c == nil {
+		return nil
+	}
+	return &Message{data: append([]byte(nil), msg.Data()...)}
+}
+
+// capture and protocol of session
+func sessionCapture(sess ISession) (*Capture, string) {
+	switch s := sess.(type) {
+	case *TcpClient:
+		if s.parent != nil {
+			return s.parent.capture, metricProtoTcp
+		}
+	case *WSClient:
+		if s.WSEngine != nil {
+			return s.capture, metricProtoWS
+		}
+	}
+	return nil, ""
+}
+
+// copy of message to be sent by session, see Capture.copy
+func captureCopy(sess ISession, msg IMessage) IMessage {
+	c, _ := sessionCapture(sess)
+	return c.copy(msg)
+}
+
+// capture sent message of session, msg should be copied by captureCopy before encrypted
+func captureSent(sess ISession, msg IMessage) {
+	c, proto := sessionCapture(sess)
+	c.record(sess, proto, CaptureOut, msg)
+}
+
+// capture record
+type CaptureRecord struct {
+	Time      time.Time
+	ConnId    uint64
+	Direction CaptureDirection
+	// "tcp" or "ws"
+	Proto string
+	// remote ip of open record
+	Ip string
+	// message of in and out record
+	Msg *Message
+}
+
+// capture file reader
+type CaptureReader struct {
+	reader *bufio.Reader
+}
+
+// next record, io.EOF if no more
+func (r *CaptureReader) Next() (*CaptureRecord, error) {
+	var head [captureRecordHeadLen]byte
+	if _, err := io.ReadFull(r.reader, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrCaptureTruncated
+		}
+		return nil, err
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(head[18:22]))
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, ErrCaptureTruncated
+	}
+	rec := &CaptureRecord{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(head[0:8]))),
+		ConnId:    binary.LittleEndian.Uint64(head[8:16]),
+		Direction: CaptureDirection(head[16]),
+		Proto:     metricProtoTcp,
+	}
+	if head[17] == 1 {
+		rec.Proto = metricProtoWS
+	}
+	switch rec.Direction {
+	case CaptureIn, CaptureOut:
+		if len(data) < DEFAULT_MESSAGE_HEAD_LEN {
+			return nil, ErrCaptureInvalidRecord
+		}
+		rec.Msg = &Message{data: data}
+	case CaptureOpen:
+		rec.Ip = string(data)
+	}
+	return rec, nil
+}
+
+// capture reader factory, the file header is checked
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(CaptureMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || !bytes.Equal(magic, []byte(CaptureMagic)) {
+		return nil, ErrCaptureInvalidFile
+	}
+	return &CaptureReader{reader: reader}, nil
+}
+
+// read all records of capture file
+func ReadCaptureFile(path string) ([]*CaptureRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := NewCaptureReader(file)
+	if err != nil {
+		return nil, err
+	}
+	records := []*CaptureRecord{}
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
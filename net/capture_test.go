@@ -0,0 +1,214 @@
+package net
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	const cmdEcho = uint32(4324)
+
+	path := filepath.Join(t.TempDir(), "capture.kcap")
+	capture, err := NewCapture(path, 0, 0)
+	if err != nil {
+		t.Fatalf("NewCapture failed: %v", err)
+	}
+
+	addr := freeTcpAddr(t)
+	server := NewTcpServer("capture")
+	server.SetCapture(capture)
+	server.Handle(cmdEcho, func(client *TcpClient, msg IMessage) {
+		client.SendMsg(NewMessage(cmdEcho, msg.Body()))
+	})
+	go server.Start(addr)
+	defer server.Stop()
+
+	chEcho := make(chan string, 2)
+	engine := NewTcpEngine()
+	engine.Handle(cmdEcho, func(client *TcpClient, msg IMessage) {
+		chEcho <- string(msg.Body())
+	})
+	var client *TcpClient
+	for i := 0; i < 50; i++ {
+		if client, err = NewTcpClient(addr, engine, NewCipherGzip(DefaultThreshold), false, nil); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatalf("NewTcpClient failed: %v", err)
+	}
+	for _, body := range []string{"a", "b"} {
+		msg := NewMessage(cmdEcho, []byte(body))
+		msg.SetExt(7)
+		client.SendMsg(msg)
+		select {
+		case <-chEcho:
+		case <-time.After(time.Second * 3):
+			t.Fatalf("echo timeout")
+		}
+	}
+	client.Stop()
+	for i := 0; i < 100 && server.CurrLoad() != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err = capture.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	records, err := ReadCaptureFile(path)
+	if err != nil {
+		t.Fatalf("ReadCaptureFile failed: %v", err)
+	}
+	want := []CaptureDirection{CaptureOpen, CaptureIn, CaptureOut, CaptureIn, CaptureOut, CaptureClose}
+	if len(records) != len(want) {
+		t.Fatalf("records should be %d, got %d", len(want), len(records))
+	}
+	for i, rec := range records {
+		if rec.Direction != want[i] || rec.ConnId != 1 || rec.Proto != "tcp" {
+			t.Fatalf("record %d should be %v of conn 1, got %v of conn %v", i, want[i], rec.Direction, rec.ConnId)
+		}
+	}
+	if records[0].Ip != "127.0.0.1" {
+		t.Fatalf("open record ip should be 127.0.0.1, got %v", records[0].Ip)
+	}
+	if in := records[3].Msg; in.Cmd() != cmdEcho || in.Ext() != 7 || string(in.Body()) != "b" {
+		t.Fatalf("invalid in record: cmd %v, ext %v, body %s", in.Cmd(), in.Ext(), in.Body())
+	}
+
+	replayAddr := freeTcpAddr(t)
+	received := int64(0)
+	replayServer := NewTcpServer("replay")
+	replayServer.Handle(cmdEcho, func(client *TcpClient, msg IMessage) {
+		atomic.AddInt64(&received, 1)
+		client.SendMsg(NewMessage(cmdEcho, msg.Body()))
+	})
+	go replayServer.Start(replayAddr)
+	defer replayServer.Stop()
+	time.Sleep(time.Millisecond * 50)
+
+	// without close record, the replay connection is kept until responses received
+	echoed := int64(0)
+	err = Replay(replayAddr, records[:len(records)-1], &ReplayOpt{
+		Wait: time.Millisecond * 200,
+		OnMessage: func(connId uint64, msg IMessage) {
+			if connId == 1 && msg.Cmd() == cmdEcho {
+				atomic.AddInt64(&echoed, 1)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	for i := 0; i < 100 && atomic.LoadInt64(&received) != 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := atomic.LoadInt64(&received); n != 2 {
+		t.Fatalf("replayed messages should be 2, got %d", n)
+	}
+	if n := atomic.LoadInt64(&echoed); n != 2 {
+		t.Fatalf("responses of replay should be 2, got %d", n)
+	}
+}
+
+func TestCaptureRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.kcap")
+	capture, err := NewCapture(path, 100, 2)
+	if err != nil {
+		t.Fatalf("NewCapture failed: %v", err)
+	}
+	msg := NewMessage(1, make([]byte, 40))
+	for i := 0; i < 10; i++ {
+		capture.Lock()
+		capture.write(1, CaptureIn, metricProtoTcp, msg.Data())
+		capture.Unlock()
+	}
+	if err = capture.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		records, err := ReadCaptureFile(p)
+		if err != nil || len(records) != 1 {
+			t.Fatalf("%v should have 1 record, got %v, %v", p, len(records), err)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("at most 2 rotated files should be kept")
+	}
+}
+
+func TestCaptureOutGzip(t *testing.T) {
+	const cmdGzip = uint32(4325)
+
+	path := filepath.Join(t.TempDir(), "capture.kcap")
+	capture, err := NewCapture(path, 0, 0)
+	if err != nil {
+		t.Fatalf("NewCapture failed: %v", err)
+	}
+	engine := NewTcpEngine()
+	engine.SetCapture(capture)
+	h, err := NewPipeHarness(engine)
+	if err != nil {
+		t.Fatalf("NewPipeHarness failed: %v", err)
+	}
+	defer h.Close()
+	session := h.Session()
+	session.SetCipher(NewCipherGzip(0))
+	body := make([]byte, 1000)
+	if err = session.SendMsg(NewMessage(cmdGzip, body)); err != nil {
+		t.Fatalf("SendMsg failed: %v", err)
+	}
+	if err = capture.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	records, err := ReadCaptureFile(path)
+	if err != nil {
+		t.Fatalf("ReadCaptureFile failed: %v", err)
+	}
+	if len(records) != 2 || records[1].Direction != CaptureOut {
+		t.Fatalf("records should be open and out, got %d", len(records))
+	}
+	// recorded as sent by handler, not compressed by cipher
+	if out := records[1].Msg; out.Cmd() != cmdGzip || len(out.Body()) != len(body) {
+		t.Fatalf("invalid out record: cmd %v, body length %v", out.Cmd(), len(out.Body()))
+	}
+}
+
+func TestReplayPushOnConnect(t *testing.T) {
+	const cmdWelcome = uint32(4326)
+
+	addr := freeTcpAddr(t)
+	server := NewTcpServer("replay")
+	server.HandleNewClient(func(client *TcpClient) {
+		client.SendMsg(NewMessage(cmdWelcome, nil))
+	})
+	go server.Start(addr)
+	defer server.Stop()
+	time.Sleep(time.Millisecond * 50)
+
+	// messages pushed before the first replayed message are delivered with the captured conn id
+	welcomed := make(chan uint64, 1)
+	err := Replay(addr, []*CaptureRecord{{ConnId: 3, Direction: CaptureOpen, Proto: metricProtoTcp}}, &ReplayOpt{
+		Wait: time.Millisecond * 200,
+		OnMessage: func(connId uint64, msg IMessage) {
+			if msg.Cmd() == cmdWelcome {
+				welcomed <- connId
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	select {
+	case connId := <-welcomed:
+		if connId != 3 {
+			t.Fatalf("conn id should be 3, got %v", connId)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("welcome message not received")
+	}
+}
@@ -22,6 +22,30 @@ type ICodec interface {
 	// Type() string
 }
 
+// bytes codec, payloads are passed through, such as bodies encoded by callers
+type CodecBytes struct{}
+
+// marshal, v should be []byte
+func (c *CodecBytes) Marshal(v interface{}) ([]byte, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, ErrCodecBytesInvalidType
+	}
+	return data, nil
+}
+
+// unmarshal, v should be *[]byte, or nil to drop data
+func (c *CodecBytes) Unmarshal(data []byte, v interface{}) error {
+	switch p := v.(type) {
+	case *[]byte:
+		*p = data
+	case nil:
+	default:
+		return ErrCodecBytesInvalidType
+	}
+	return nil
+}
+
 // gob codec
 type CodecGob struct{}
 
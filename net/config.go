@@ -45,9 +45,40 @@ var (
 	// default shutdown timeout
 	DefaultShutdownTimeout = time.Second * 5
 
+	// default websocket rpc client keepalive interval
+	DefaultWSKeepaliveTime = time.Second * 15
+
+	// default websocket client reconnect min interval
+	DefaultWSReconnectMinInterval = time.Second / 10
+	// default websocket client reconnect max interval
+	DefaultWSReconnectMaxInterval = time.Second * 5
+
+	// default websocket permessage-deflate threshold
+	DefaultWSCompressionThreshold = 512
+	// default websocket permessage-deflate level, same as compress/flate.BestSpeed
+	DefaultWSCompressionLevel = 1
+
+	// default wait time of websocket http fallback long-poll
+	DefaultWSPollTimeout = time.Second * 25
+	// default idle time of websocket http fallback session without poll or sse attached
+	DefaultWSPollSessionTimeout = time.Second * 60
+
+	// default time waiting for hot restart child ready
+	DefaultHotRestartTimeout = time.Second * 30
+
 	// default max websocket read length
 	DefaultReadLimit int64 = 1024 * 1024
 
 	// default enable set real ip multi times
 	DefaultEnableMultiSetRealIp = false
+
+	// default proxy protocol header read timeout
+	DefaultProxyHeaderTimeout = time.Second * 5
+
+	// default dispatcher worker pool size
+	DefaultDispatchPoolSize = 64
+	// default dispatcher worker pool queue size
+	DefaultDispatchPoolQSize = 1024 * 8
+	// default dispatcher max pending handlers per connection or key
+	DefaultDispatchQueueSize = 1024
 )
@@ -0,0 +1,204 @@
+package net
+
+import (
+	"github.com/nothollyhigh/kiss/util"
+	"sync"
+	"time"
+)
+
+// handler dispatch mode
+type DispatchMode int
+
+const (
+	// run handler on the read loop goroutine
+	DispatchInline DispatchMode = iota
+	// run handlers of the same connection one by one, out of the read loop
+	DispatchSerial
+	// run handler in the shared bounded worker pool
+	DispatchPool
+	// run handlers of the same key one by one, different keys run in parallel
+	DispatchKeyed
+)
+
+// dispatch rule for a cmd or rpc method
+type dispatchRule struct {
+	mode DispatchMode
+	key  func(sess interface{}, msg IMessage) interface{}
+}
+
+// handler dispatcher
+type Dispatcher struct {
+	sync.RWMutex
+
+	// rules by cmd(uint32) or rpc method(string)
+	rules map[interface{}]*dispatchRule
+
+	// worker pool for DispatchPool
+	pool *util.WorkerPool
+
+	// worker pool push timeout, 0 means block until pushed
+	poolTimeout time.Duration
+
+	// worker pool created by dispatcher
+	ownPool bool
+
+	// per connection queue for DispatchSerial
+	serialQ *util.KeyedQueue
+
+	// user key queue for DispatchKeyed
+	keyedQ *util.KeyedQueue
+
+	// shared by engines served by Engine, stopped by Engine.Stop instead of servers
+	shared bool
+}
+
+// setting dispatch mode by cmd or rpc method, DispatchKeyed needs a key func and should be set by SetKey
+func (d *Dispatcher) SetMode(tag interface{}, mode DispatchMode) error {
+	switch mode {
+	case DispatchInline, DispatchSerial, DispatchPool:
+	case DispatchKeyed:
+		return ErrDispatchKeyedWithoutKey
+	default:
+		return ErrDispatchInvalidMode
+	}
+	d.Lock()
+	defer d.Unlock()
+	if mode == DispatchInline {
+		delete(d.rules, tag)
+		return nil
+	}
+	d.rules[tag] = &dispatchRule{mode: mode}
+	return nil
+}
+
+// setting keyed dispatch by cmd or rpc method
+func (d *Dispatcher) SetKey(tag interface{}, key func(sess interface{}, msg IMessage) interface{}) {
+	d.Lock()
+	defer d.Unlock()
+	d.rules[tag] = &dispatchRule{mode: DispatchKeyed, key: key}
+}
+
+// dispatch mode by cmd or rpc method
+func (d *Dispatcher) Mode(tag interface{}) DispatchMode {
+	d.RLock()
+	defer d.RUnlock()
+	if rule, ok := d.rules[tag]; ok {
+		return rule.mode
+	}
+	return DispatchInline
+}
+
+// setting worker pool for DispatchPool
+func (d *Dispatcher) SetWorkerPool(pool *util.WorkerPool, timeout time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+	d.pool = pool
+	d.poolTimeout = timeout
+	d.ownPool = false
+}
+
+// worker pool
+func (d *Dispatcher) WorkerPool() *util.WorkerPool {
+	d.Lock()
+	defer d.Unlock()
+	if d.pool == nil {
+		d.pool = util.NewWorkerPool("net-dispatcher", DefaultDispatchPoolQSize, DefaultDispatchPoolSize)
+		d.ownPool = true
+	}
+	return d.pool
+}
+
+// serial queue
+func (d *Dispatcher) serialQueue() *util.KeyedQueue {
+	d.Lock()
+	defer d.Unlock()
+	if d.serialQ == nil {
+		d.serialQ = util.NewKeyedQueue("net-dispatcher-serial", DefaultDispatchQueueSize)
+	}
+	return d.serialQ
+}
+
+// keyed queue
+func (d *Dispatcher) keyedQueue() *util.KeyedQueue {
+	d.Lock()
+	defer d.Unlock()
+	if d.keyedQ == nil {
+		d.keyedQ = util.NewKeyedQueue("net-dispatcher-keyed", DefaultDispatchQueueSize)
+	}
+	return d.keyedQ
+}
+
+// dispatch handler, h is not called if err returned
+func (d *Dispatcher) Dispatch(tag interface{}, sess interface{}, msg IMessage, h func()) error {
+	d.RLock()
+	rule, ok := d.rules[tag]
+	d.RUnlock()
+
+	if !ok {
+		h()
+		return nil
+	}
+
+	switch rule.mode {
+	case DispatchSerial:
+		return d.serialQueue().Go(sess, h)
+	case DispatchPool:
+		pool := d.WorkerPool()
+		d.RLock()
+		timeout := d.poolTimeout
+		d.RUnlock()
+		return pool.Go(h, timeout)
+	case DispatchKeyed:
+		return d.keyedQueue().Go(rule.key(sess, msg), h)
+	default:
+		h()
+	}
+
+	return nil
+}
+
+// stop queues and the worker pool created by dispatcher after pending handlers done, they are created again if
+// dispatched after stopped
+func (d *Dispatcher) Stop() {
+	d.Lock()
+	pool, serialQ, keyedQ := d.pool, d.serialQ, d.keyedQ
+	if d.ownPool {
+		d.pool = nil
+		d.ownPool = false
+	} else {
+		pool = nil
+	}
+	d.serialQ = nil
+	d.keyedQ = nil
+	d.Unlock()
+	if serialQ != nil {
+		serialQ.Stop()
+	}
+	if keyedQ != nil {
+		keyedQ.Stop()
+	}
+	if pool != nil {
+		pool.Stop()
+	}
+}
+
+// has dispatch rules or worker pool set
+func (d *Dispatcher) configured() bool {
+	d.RLock()
+	defer d.RUnlock()
+	return len(d.rules) > 0 || (d.pool != nil && !d.ownPool)
+}
+
+// stop by server shutdown, unless shared by Engine
+func (d *Dispatcher) stopOwned() {
+	if d != nil && !d.shared {
+		d.Stop()
+	}
+}
+
+// dispatcher factory
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		rules: map[interface{}]*dispatchRule{},
+	}
+}
@@ -0,0 +1,203 @@
+package net
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// handlers of each dispatch mode, records order and max concurrency by cmd
+type dispatchRecorder struct {
+	sync.Mutex
+	running int64
+	maxRun  map[uint32]int64
+	bodies  map[uint32][]string
+	done    chan struct{}
+}
+
+func (r *dispatchRecorder) handle(cmd uint32, body string) {
+	running := atomic.AddInt64(&r.running, 1)
+	r.Lock()
+	if running > r.maxRun[cmd] {
+		r.maxRun[cmd] = running
+	}
+	r.Unlock()
+	time.Sleep(time.Millisecond * 30)
+	atomic.AddInt64(&r.running, -1)
+	r.Lock()
+	r.bodies[cmd] = append(r.bodies[cmd], body)
+	r.Unlock()
+	r.done <- struct{}{}
+}
+
+const (
+	cmdDispatchInline = uint32(1) + iota
+	cmdDispatchSerial
+	cmdDispatchPool
+	cmdDispatchKeyed
+)
+
+// send 4 messages of each mode one by one, keyed messages are of key "a" and "b", then check order and concurrency
+func testDispatchModes(t *testing.T, r *dispatchRecorder, send func(cmd uint32, body string)) {
+	for _, cmd := range []uint32{cmdDispatchInline, cmdDispatchSerial, cmdDispatchPool, cmdDispatchKeyed} {
+		atomic.StoreInt64(&r.running, 0)
+		for _, body := range []string{"a1", "b1", "a2", "b2"} {
+			send(cmd, body)
+		}
+		for i := 0; i < 4; i++ {
+			select {
+			case <-r.done:
+			case <-time.After(time.Second * 3):
+				t.Fatalf("cmd %d: wait handler timeout", cmd)
+			}
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	for _, cmd := range []uint32{cmdDispatchInline, cmdDispatchSerial} {
+		if got := strings.Join(r.bodies[cmd], ","); got != "a1,b1,a2,b2" {
+			t.Fatalf("cmd %d: handlers should run in order, got %v", cmd, got)
+		}
+		if r.maxRun[cmd] != 1 {
+			t.Fatalf("cmd %d: handlers should run one by one, got %d", cmd, r.maxRun[cmd])
+		}
+	}
+	if r.maxRun[cmdDispatchPool] < 2 {
+		t.Fatalf("pool handlers should run in parallel, got %d", r.maxRun[cmdDispatchPool])
+	}
+	if r.maxRun[cmdDispatchKeyed] != 2 {
+		t.Fatalf("keyed handlers should run in parallel by key, got %d", r.maxRun[cmdDispatchKeyed])
+	}
+	keyed := strings.Join(r.bodies[cmdDispatchKeyed], ",")
+	if strings.Index(keyed, "a1") > strings.Index(keyed, "a2") || strings.Index(keyed, "b1") > strings.Index(keyed, "b2") {
+		t.Fatalf("keyed handlers should run in order by key, got %v", keyed)
+	}
+}
+
+func newDispatchRecorder() *dispatchRecorder {
+	return &dispatchRecorder{
+		maxRun: map[uint32]int64{},
+		bodies: map[uint32][]string{},
+		done:   make(chan struct{}, 16),
+	}
+}
+
+// setting dispatch modes of test cmds
+func setDispatchModes(t *testing.T, setMode func(cmd uint32, mode DispatchMode) error) {
+	if err := setMode(cmdDispatchKeyed, DispatchKeyed); err != ErrDispatchKeyedWithoutKey {
+		t.Fatalf("DispatchKeyed without key should fail, got %v", err)
+	}
+	if err := setMode(cmdDispatchKeyed, DispatchMode(100)); err != ErrDispatchInvalidMode {
+		t.Fatalf("invalid mode should fail, got %v", err)
+	}
+	for cmd, mode := range map[uint32]DispatchMode{
+		cmdDispatchInline: DispatchInline,
+		cmdDispatchSerial: DispatchSerial,
+		cmdDispatchPool:   DispatchPool,
+	} {
+		if err := setMode(cmd, mode); err != nil {
+			t.Fatalf("setting dispatch mode failed: %v", err)
+		}
+	}
+}
+
+// queues and the pool created by dispatcher are released after stopped
+func checkDispatcherStopped(t *testing.T, d *Dispatcher) {
+	d.RLock()
+	defer d.RUnlock()
+	if d.pool != nil || d.serialQ != nil || d.keyedQ != nil {
+		t.Fatalf("dispatcher should be stopped")
+	}
+}
+
+func TestTcpEngineDispatch(t *testing.T) {
+	r := newDispatchRecorder()
+	server := NewTcpServer("dispatch")
+	for _, cmd := range []uint32{cmdDispatchInline, cmdDispatchSerial, cmdDispatchPool, cmdDispatchKeyed} {
+		cmd := cmd
+		server.Handle(cmd, func(client *TcpClient, msg IMessage) {
+			r.handle(cmd, string(msg.Body()))
+		})
+	}
+	setDispatchModes(t, server.SetDispatchMode)
+	server.SetDispatchKey(cmdDispatchKeyed, func(client *TcpClient, msg IMessage) interface{} {
+		return msg.Body()[0]
+	})
+
+	addr := freeTcpAddr(t)
+	go server.Start(addr)
+
+	var client *TcpClient
+	var err error
+	for i := 0; i < 50; i++ {
+		if client, err = NewTcpClient(addr, nil, NewCipherGzip(DefaultThreshold), false, nil); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatalf("NewTcpClient failed: %v", err)
+	}
+	defer client.Stop()
+
+	testDispatchModes(t, r, func(cmd uint32, body string) {
+		client.SendMsg(NewMessage(cmd, []byte(body)))
+	})
+
+	server.Stop()
+	checkDispatcherStopped(t, server.Dispatcher())
+}
+
+func TestWSEngineDispatch(t *testing.T) {
+	r := newDispatchRecorder()
+	server, err := NewWebsocketServer("dispatch", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.HandleWs("/ws")
+	for _, cmd := range []uint32{cmdDispatchInline, cmdDispatchSerial, cmdDispatchPool, cmdDispatchKeyed} {
+		cmd := cmd
+		server.Handle(cmd, func(cli *WSClient, msg IMessage) {
+			r.handle(cmd, string(msg.Body()))
+		})
+	}
+	setDispatchModes(t, server.SetDispatchMode)
+	server.SetDispatchKey(cmdDispatchKeyed, func(cli *WSClient, msg IMessage) interface{} {
+		return msg.Body()[0]
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client, err := NewWebsocketClient("ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws")
+	if err != nil {
+		t.Fatalf("NewWebsocketClient failed: %v", err)
+	}
+	defer client.Stop()
+
+	testDispatchModes(t, r, func(cmd uint32, body string) {
+		client.SendMsg(NewMessage(cmd, []byte(body)))
+	})
+
+	server.Shutdown(time.Second, nil)
+	checkDispatcherStopped(t, server.Dispatcher())
+}
+
+func TestEngineDispatcherShared(t *testing.T) {
+	engine := NewEngine()
+	engine.SetDispatchMode(cmdDispatchSerial, DispatchSerial)
+	server := NewTcpServer("shared")
+	engine.ServeTcp(server.TcpEngin)
+
+	// stopping a served server doesn't stop the dispatcher shared with others
+	serialQ := server.Dispatcher().serialQueue()
+	server.dispatcher.stopOwned()
+	if server.Dispatcher().serialQueue() != serialQ {
+		t.Fatalf("shared dispatcher should not be stopped by server")
+	}
+	engine.Stop()
+	checkDispatcherStopped(t, engine.Dispatcher())
+}
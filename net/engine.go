@@ -0,0 +1,254 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/util"
+	"time"
+)
+
+// rpc method of CmdRpcMethod message, the body is: payload|method|len(method)
+func rpcMethodOf(msg *Message) (string, error) {
+	data := msg.Body()
+	if len(data) < 2 {
+		return "", errors.New("invalid rpc payload")
+	}
+	methodLen := int(data[len(data)-1])
+	if methodLen <= 0 || methodLen >= 128 || len(data)-1 < methodLen {
+		return "", fmt.Errorf("invalid rpc method length %d, should be (1-127)", methodLen)
+	}
+	return string(data[(len(data) - 1 - methodLen):(len(data) - 1)]), nil
+}
+
+// append method to CmdRpcMethod message body
+func appendRpcMethod(data []byte, method string) []byte {
+	data = append(data, method...)
+	return append(data, byte(len(method)))
+}
+
+// trim method from CmdRpcMethod message body
+func trimRpcMethod(msg *Message, method string) {
+	msg.data = msg.data[:(len(msg.data) - 1 - len(method))]
+}
+
+// panic if cmd is reserved
+func checkUserCmd(cmd uint32) {
+	if cmd == CmdPing {
+		panic(ErrorReservedCmdPing)
+	}
+	if cmd == CmdSetReaIp {
+		panic(ErrorReservedCmdSetRealip)
+	}
+	if cmd == CmdRpcMethod {
+		panic(ErrorReservedCmdRpcMethod)
+	}
+	if cmd == CmdRpcError {
+		panic(ErrorReservedCmdRpcError)
+	}
+	if cmd > CmdUserMax {
+		panic(ErrorReservedCmdInternal)
+	}
+}
+
+// transport agnostic engine, serves the same handlers over tcp and websocket
+type Engine struct {
+	// session handlers
+	handlers map[uint32]func(sess ISession, msg IMessage)
+
+	// rpc method handlers
+	rpcMethodHandlers map[string]func(*RpcContext)
+
+	// handler dispatcher shared by served engines
+	dispatcher *Dispatcher
+}
+
+// handle message by cmd
+func (e *Engine) Handle(cmd uint32, h func(sess ISession, msg IMessage)) {
+	checkUserCmd(cmd)
+	if _, ok := e.handlers[cmd]; ok {
+		panic(fmt.Errorf("Engine Handle failed: handler for cmd %v exists", cmd))
+	}
+	e.handlers[cmd] = h
+}
+
+// handle rpc cmd
+func (e *Engine) HandleRpcCmd(cmd uint32, h func(ctx *RpcContext), async bool) {
+	if async {
+		e.Handle(cmd, func(sess ISession, msg IMessage) {
+			util.Go(func() {
+				newRpcContext("", sess, msg).call(h)
+			})
+		})
+	} else {
+		e.Handle(cmd, func(sess ISession, msg IMessage) {
+			newRpcContext("", sess, msg).call(h)
+		})
+	}
+}
+
+// handle rpc method
+func (e *Engine) HandleRpcMethod(method string, h func(ctx *RpcContext), args ...interface{}) {
+	if _, ok := e.rpcMethodHandlers[method]; ok {
+		panic(fmt.Errorf("Engine HandleRpcMethod failed: handler for method %v exists", method))
+	}
+
+	async := false
+	if len(args) > 0 {
+		if a, ok := args[0].(bool); ok {
+			async = a
+		}
+	}
+
+	if async {
+		e.rpcMethodHandlers[method] = func(ctx *RpcContext) {
+			util.Go(func() {
+				ctx.call(h)
+			})
+		}
+	} else {
+		e.rpcMethodHandlers[method] = func(ctx *RpcContext) {
+			ctx.call(h)
+		}
+	}
+}
+
+// on message, fallback handles messages without engine handlers, such as ping and set real ip
+func (e *Engine) onMessage(sess ISession, msg IMessage, dispatch func(tag interface{}, h func()), fallback func()) {
+	cmd := msg.Cmd()
+	if h, ok := e.handlers[cmd]; ok {
+		dispatch(cmd, func() {
+			h(sess, msg)
+		})
+		return
+	}
+
+	if cmd == CmdRpcMethod && len(e.rpcMethodHandlers) > 0 {
+		e.onRpcMethod(sess, msg, dispatch, fallback)
+		return
+	}
+
+	fallback()
+}
+
+// on rpc method call
+func (e *Engine) onRpcMethod(sess ISession, imsg IMessage, dispatch func(tag interface{}, h func()), fallback func()) {
+	msg, ok := imsg.(*Message)
+	if !ok {
+		fallback()
+		return
+	}
+	method, err := rpcMethodOf(msg)
+	if err != nil {
+		sess.SendMsg(NewRpcMessage(CmdRpcError, msg.Ext(), []byte(err.Error())))
+		return
+	}
+	h, ok := e.rpcMethodHandlers[method]
+	if !ok {
+		// methods handled by the served engine itself
+		fallback()
+		return
+	}
+	trimRpcMethod(msg, method)
+	ctx := newRpcContext(method, sess, msg)
+	dispatch(method, func() {
+		h(ctx)
+	})
+}
+
+// check served engine's message handler and dispatcher, which are replaced by Engine
+func (e *Engine) checkServed(hasHandler bool, dispatcher *Dispatcher) error {
+	if hasHandler {
+		return ErrEngineServeHandlerExists
+	}
+	if dispatcher != nil && dispatcher != e.dispatcher && dispatcher.configured() {
+		return ErrEngineServeDispatcherSet
+	}
+	return nil
+}
+
+// serve handlers on tcp engine, such as TcpServer.TcpEngin. refused if the engine has a message handler, or dispatch
+// modes set on itself instead of Engine
+func (e *Engine) ServeTcp(engine *TcpEngin) error {
+	if err := e.checkServed(engine.OnMsgHandler != nil, engine.dispatcher); err != nil {
+		log.Error("Engine ServeTcp failed: %v", err)
+		return err
+	}
+	engine.dispatcher = e.dispatcher
+	engine.HandleMessage(func(client *TcpClient, msg IMessage) {
+		e.onMessage(client, msg, func(tag interface{}, h func()) {
+			engine.dispatch(tag, client, msg, h)
+		}, func() {
+			engine.DefaultOnMessage(client, msg)
+		})
+	})
+	return nil
+}
+
+// serve handlers on websocket engine, such as WSServer.WSEngine. refused if the engine has a message handler, or
+// dispatch modes set on itself instead of Engine
+func (e *Engine) ServeWs(engine *WSEngine) error {
+	if err := e.checkServed(engine.messageHandler != nil, engine.dispatcher); err != nil {
+		log.Error("Engine ServeWs failed: %v", err)
+		return err
+	}
+	engine.dispatcher = e.dispatcher
+	engine.HandleMessage(func(cli *WSClient, msg IMessage) {
+		e.onMessage(cli, msg, func(tag interface{}, h func()) {
+			engine.dispatch(tag, cli, msg, h)
+		}, func() {
+			engine.defaultOnMessage(cli, msg)
+		})
+	})
+	return nil
+}
+
+// handler dispatcher
+func (e *Engine) Dispatcher() *Dispatcher {
+	return e.dispatcher
+}
+
+// setting handler dispatch mode by cmd, DispatchKeyed should be set by SetDispatchKey
+func (e *Engine) SetDispatchMode(cmd uint32, mode DispatchMode) error {
+	return e.dispatcher.SetMode(cmd, mode)
+}
+
+// setting keyed handler dispatch by cmd, handlers with the same key run in order
+func (e *Engine) SetDispatchKey(cmd uint32, key func(sess ISession, msg IMessage) interface{}) {
+	e.dispatcher.SetKey(cmd, func(sess interface{}, msg IMessage) interface{} {
+		return key(sess.(ISession), msg)
+	})
+}
+
+// setting handler dispatch mode by rpc method, DispatchKeyed should be set by SetRpcMethodDispatchKey
+func (e *Engine) SetRpcMethodDispatchMode(method string, mode DispatchMode) error {
+	return e.dispatcher.SetMode(method, mode)
+}
+
+// setting keyed handler dispatch by rpc method, handlers with the same key run in order
+func (e *Engine) SetRpcMethodDispatchKey(method string, key func(ctx *RpcContext) interface{}) {
+	e.dispatcher.SetKey(method, func(sess interface{}, msg IMessage) interface{} {
+		return key(newRpcContext(method, sess.(ISession), msg))
+	})
+}
+
+// setting shared worker pool for DispatchPool, timeout 0 means block until pushed
+func (e *Engine) SetWorkerPool(pool *util.WorkerPool, timeout time.Duration) {
+	e.dispatcher.SetWorkerPool(pool, timeout)
+}
+
+// stop dispatcher shared by served engines, should be called after served servers stopped
+func (e *Engine) Stop() {
+	e.dispatcher.Stop()
+}
+
+// engine factory
+func NewEngine() *Engine {
+	dispatcher := NewDispatcher()
+	dispatcher.shared = true
+	return &Engine{
+		handlers:          map[uint32]func(ISession, IMessage){},
+		rpcMethodHandlers: map[string]func(*RpcContext){},
+		dispatcher:        dispatcher,
+	}
+}
@@ -0,0 +1,128 @@
+package net
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEngineServeTcpAndWs(t *testing.T) {
+	const cmdEcho = uint32(1)
+
+	engine := NewEngine()
+	engine.Handle(cmdEcho, func(sess ISession, msg IMessage) {
+		sess.SendMsg(NewMessage(cmdEcho, msg.Body()))
+	})
+	engine.HandleRpcMethod("Hello", func(ctx *RpcContext) {
+		req := ""
+		if err := ctx.Bind(&req); err != nil {
+			ctx.Error(err.Error())
+			return
+		}
+		ctx.Write("hello " + req)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	tcpAddr := ln.Addr().String()
+	ln.Close()
+
+	tcpServer := NewTcpServer("engine")
+	engine.ServeTcp(tcpServer.TcpEngin)
+	go tcpServer.Start(tcpAddr)
+	defer tcpServer.Stop()
+
+	wsServer, err := NewWebsocketServer("engine", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	wsServer.HandleWs("/ws")
+	engine.ServeWs(wsServer.WSEngine)
+	httpServer := httptest.NewServer(wsServer)
+	defer httpServer.Close()
+
+	chTcp := make(chan string, 1)
+	tcpEngine := NewTcpEngine()
+	tcpEngine.Handle(cmdEcho, func(client *TcpClient, msg IMessage) {
+		chTcp <- string(msg.Body())
+	})
+	var tcpClient *TcpClient
+	for i := 0; i < 50; i++ {
+		if tcpClient, err = NewTcpClient(tcpAddr, tcpEngine, NewCipherGzip(DefaultThreshold), false, nil); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatalf("NewTcpClient failed: %v", err)
+	}
+	defer tcpClient.Stop()
+
+	wsClient, err := NewWebsocketClient("ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws")
+	if err != nil {
+		t.Fatalf("NewWebsocketClient failed: %v", err)
+	}
+	defer wsClient.Stop()
+	chWs := make(chan string, 1)
+	wsClient.HandleMessage(func(cli *WSClient, msg IMessage) {
+		if msg.Cmd() == cmdEcho {
+			chWs <- string(msg.Body())
+		}
+	})
+
+	tcpClient.SendMsg(NewMessage(cmdEcho, []byte("tcp")))
+	wsClient.SendMsg(NewMessage(cmdEcho, []byte("ws")))
+	for _, c := range []struct {
+		ch   chan string
+		want string
+	}{{chTcp, "tcp"}, {chWs, "ws"}} {
+		select {
+		case body := <-c.ch:
+			if body != c.want {
+				t.Fatalf("echo should be %v, got %v", c.want, body)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("echo %v timeout", c.want)
+		}
+	}
+
+	rpcClient, err := NewRpcClient(tcpAddr, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewRpcClient failed: %v", err)
+	}
+	defer rpcClient.Shutdown()
+	rsp := ""
+	if err = rpcClient.Call("Hello", "kiss", &rsp, time.Second*3); err != nil || rsp != "hello kiss" {
+		t.Fatalf("rpc Call failed: %v, %v", err, rsp)
+	}
+}
+
+func TestEngineServeRefused(t *testing.T) {
+	engine := NewEngine()
+
+	tcpEngine := NewTcpEngine()
+	tcpEngine.HandleMessage(func(client *TcpClient, msg IMessage) {})
+	if err := engine.ServeTcp(tcpEngine); err != ErrEngineServeHandlerExists {
+		t.Fatalf("ServeTcp with message handler should fail, got %v", err)
+	}
+
+	tcpEngine = NewTcpEngine()
+	tcpEngine.SetDispatchMode(1, DispatchSerial)
+	if err := engine.ServeTcp(tcpEngine); err != ErrEngineServeDispatcherSet {
+		t.Fatalf("ServeTcp with dispatch modes should fail, got %v", err)
+	}
+
+	wsEngine := NewWebsocketEngine()
+	wsEngine.HandleMessage(func(cli *WSClient, msg IMessage) {})
+	if err := engine.ServeWs(wsEngine); err != ErrEngineServeHandlerExists {
+		t.Fatalf("ServeWs with message handler should fail, got %v", err)
+	}
+
+	if err := engine.ServeWs(NewWebsocketEngine()); err != nil {
+		t.Fatalf("ServeWs failed: %v", err)
+	}
+}
@@ -18,9 +18,23 @@ var (
 
 	ErrorRpcInvalidMessageHeadLen = errors.New("invalid Message Head Len")
 	ErrorRpcInvalidPbMessage      = errors.New("invalid pb Message")
+	ErrCodecBytesInvalidType      = errors.New("bytes codec supports []byte and *[]byte only")
 
 	ErrorBroadcastNotEnabled = errors.New("broadcast not enabled")
 
+	ErrIpDenied        = errors.New("ip denied")
+	ErrIpConnLimit     = errors.New("too many connections of ip")
+	ErrIpConnRateLimit = errors.New("ip connects too frequently")
+
+	ErrProxyHeaderInvalid       = errors.New("invalid proxy protocol header")
+	ErrProxyProtocolUnsupported = errors.New("proxy protocol unsupported by listener")
+
+	ErrDispatchKeyedWithoutKey = errors.New("keyed dispatch mode needs a key func, plz use SetDispatchKey")
+	ErrDispatchInvalidMode     = errors.New("invalid dispatch mode")
+
+	ErrEngineServeHandlerExists = errors.New("served engine already has a message handler")
+	ErrEngineServeDispatcherSet = errors.New("served engine already has dispatch modes or worker pool set, plz set them on Engine")
+
 	ErrorReservedCmdInternal  = fmt.Errorf("cmd > %d/0x%X is reserved for internal, plz use other number", CmdUserMax, CmdUserMax)
 	ErrorReservedCmdPing      = fmt.Errorf("cmd %d/0x%X is reserved for ping, plz use other number", CmdPing, CmdPing)
 	ErrorReservedCmdSetRealip = fmt.Errorf("cmd %d/0x%X is reserved for set client's real ip, plz use other number", CmdSetReaIp, CmdSetReaIp)
@@ -31,4 +45,28 @@ var (
 	ErrWSClientSendQueueIsFull = errors.New("websocket client's send queue is full")
 	ErrClientWithoutCodec      = errors.New("websocket client has no codec")
 	ErrWSEngineShutdownTimeout = errors.New("shutdown timeout")
+
+	ErrHttpServerShutdownTimeout = errors.New("http server shutdown timeout")
+
+	ErrHotRestartUnsupported = errors.New("hot restart unsupported")
+	ErrHotRestartTimeout     = errors.New("hot restart child not ready in timeout")
+	ErrHotRestartChildExited = errors.New("hot restart child exited before ready")
+
+	ErrMigrationUnsupported = errors.New("tcp session migration unsupported")
+	ErrMigrationTimeout     = errors.New("tcp session migration timeout")
+	ErrMigrationRefused     = errors.New("tcp session migration refused by successor")
+	ErrMigrationNoFd        = errors.New("tcp session migration without fd")
+	ErrMigrationCustomRecv  = errors.New("tcp session migration unsupported with custom recv handler")
+
+	ErrWSPollQueueIsFull = errors.New("websocket poll session's queue is full")
+
+	ErrCaptureInvalidFile   = errors.New("invalid capture file")
+	ErrCaptureTruncated     = errors.New("capture file truncated")
+	ErrCaptureInvalidRecord = errors.New("invalid capture record")
+
+	ErrPipeServerRefused = errors.New("pipe server refused")
+	ErrPipeRecvTimeout   = errors.New("pipe harness receive timeout")
+
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
 )
@@ -0,0 +1,229 @@
+//go:build !windows
+// +build !windows
+
+package net
+
+import (
+	"fmt"
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/util"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// env of inherited listeners: addr1=fd1;addr2=fd2
+	EnvHotRestartListenFds = "KISS_LISTEN_FDS"
+	// env of fd notifying parent that child is ready
+	EnvHotRestartReadyFd = "KISS_READY_FD"
+)
+
+var hotRestart = &hotRestartState{
+	listeners: map[string]*net.TCPListener{},
+}
+
+// listeners of current process for handoff, and listeners inherited from parent
+type hotRestartState struct {
+	sync.Mutex
+
+	once sync.Once
+
+	// listeners by addr
+	listeners map[string]*net.TCPListener
+
+	// inherited listeners not taken yet
+	inherited map[string]*net.TCPListener
+
+	// notify parent when all inherited listeners taken
+	ready *os.File
+}
+
+// parse inherited listeners from env
+func (hr *hotRestartState) init() {
+	hr.once.Do(func() {
+		fds := os.Getenv(EnvHotRestartListenFds)
+		if fds == "" {
+			return
+		}
+		hr.inherited = map[string]*net.TCPListener{}
+		for _, item := range strings.Split(fds, ";") {
+			pos := strings.LastIndex(item, "=")
+			if pos <= 0 {
+				continue
+			}
+			addr := item[:pos]
+			fd, err := strconv.Atoi(item[pos+1:])
+			if err != nil {
+				log.Error("hot restart invalid fd %v: %v", item, err)
+				continue
+			}
+			f := os.NewFile(uintptr(fd), addr)
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				log.Error("hot restart inherit %v failed: %v", item, err)
+				continue
+			}
+			if tl, ok := l.(*net.TCPListener); ok {
+				hr.inherited[addr] = tl
+				log.Debug("hot restart inherit listener %v, fd %v", addr, fd)
+			} else {
+				l.Close()
+			}
+		}
+		if fd, err := strconv.Atoi(os.Getenv(EnvHotRestartReadyFd)); err == nil {
+			hr.ready = os.NewFile(uintptr(fd), "ready")
+		}
+		os.Unsetenv(EnvHotRestartListenFds)
+		os.Unsetenv(EnvHotRestartReadyFd)
+	})
+}
+
+// listen tcp, or take listener inherited from parent
+func (hr *hotRestartState) listen(addr string) (*net.TCPListener, error) {
+	hr.init()
+
+	hr.Lock()
+	defer hr.Unlock()
+
+	l, ok := hr.inherited[addr]
+	if ok {
+		delete(hr.inherited, addr)
+		if len(hr.inherited) == 0 {
+			hr.notifyReady()
+		}
+	} else {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if l, err = net.ListenTCP("tcp", tcpAddr); err != nil {
+			if l, err = net.ListenTCP("tcp6", tcpAddr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	hr.listeners[addr] = l
+	return l, nil
+}
+
+// notify parent, must be called with lock held
+func (hr *hotRestartState) notifyReady() {
+	if hr.ready != nil {
+		hr.ready.Write([]byte{1})
+		hr.ready.Close()
+		hr.ready = nil
+	}
+}
+
+// start child process inheriting listeners, returns after child is ready
+func (hr *hotRestartState) startChild(argv []string, env []string, timeout time.Duration) (*os.Process, error) {
+	hr.Lock()
+	files := []*os.File{}
+	fds := []string{}
+	for addr, l := range hr.listeners {
+		f, err := l.File()
+		if err != nil {
+			// closed listeners are not inherited
+			log.Debug("hot restart skip listener %v: %v", addr, err)
+			continue
+		}
+		defer f.Close()
+		fds = append(fds, fmt.Sprintf("%v=%d", addr, 4+len(files)))
+		files = append(files, f)
+	}
+	hr.Unlock()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, EnvHotRestartListenFds+"=") && !strings.HasPrefix(kv, EnvHotRestartReadyFd+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, EnvHotRestartListenFds+"="+strings.Join(fds, ";"), EnvHotRestartReadyFd+"=3")
+
+	wd, _ := os.Getwd()
+	process, err := os.StartProcess(argv[0], argv, &os.ProcAttr{
+		Dir:   wd,
+		Env:   env,
+		Files: append([]*os.File{os.Stdin, os.Stdout, os.Stderr, w}, files...),
+		Sys:   &syscall.SysProcAttr{},
+	})
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	util.Go(func() {
+		buf := make([]byte, 1)
+		if _, err := r.Read(buf); err != nil {
+			done <- ErrHotRestartChildExited
+			return
+		}
+		done <- nil
+	})
+
+	after := time.NewTimer(timeout)
+	defer after.Stop()
+	select {
+	case err = <-done:
+	case <-after.C:
+		err = ErrHotRestartTimeout
+	}
+	if err != nil {
+		process.Kill()
+		process.Release()
+		return nil, err
+	}
+	return process, nil
+}
+
+// listen tcp, the listener is inherited from parent if hot restarted and recorded for next hot restart
+func ListenTcp(addr string) (*net.TCPListener, error) {
+	return hotRestart.listen(addr)
+}
+
+// notify parent that child is ready if it's hot restarted, called automatically when all inherited listeners
+// are taken by ListenTcp/NewListener/TcpServer, call it if some listeners are not used by new version
+func HotRestartReady() {
+	hotRestart.init()
+	hotRestart.Lock()
+	hotRestart.notifyReady()
+	hotRestart.Unlock()
+}
+
+// hot restart: start a new process with the same args inheriting all listeners, returns after the new process is
+// ready, then current process should stop accepting and drain. the new process is killed if not ready in timeout
+func HotRestart(timeout time.Duration) (int, error) {
+	if timeout <= 0 {
+		timeout = DefaultHotRestartTimeout
+	}
+	argv0, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	process, err := hotRestart.startChild(append([]string{argv0}, os.Args[1:]...), nil, timeout)
+	if err != nil {
+		log.Error("hot restart failed: %v", err)
+		return 0, err
+	}
+	log.Info("hot restart child %v ready", process.Pid)
+	process.Release()
+	return process.Pid, nil
+}
+
+// whether sig is the hot restart signal SIGUSR2
+func isHotRestartSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+// +build !windows
+
+package net
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+const envHotRestartTestChild = "KISS_HOT_RESTART_TEST_CHILD"
+
+// serve "tag" on listener of addr until /exit requested
+func serveHotRestartTest(t *testing.T, addr string, tag string) (*HttpServer, chan struct{}) {
+	exit := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(tag))
+		if r.URL.Path == "/exit" {
+			close(exit)
+		}
+	})
+	svr, err := NewHttpServer(tag, addr, handler, time.Second*3, nil, nil)
+	if err != nil {
+		t.Fatalf("%v NewHttpServer failed: %v", tag, err)
+	}
+	go svr.Serve()
+	return svr, exit
+}
+
+func TestHotRestart(t *testing.T) {
+	// the key of listener, the child takes listener by the same addr
+	const addr = "127.0.0.1:0"
+
+	if os.Getenv(envHotRestartTestChild) != "" {
+		svr, exit := serveHotRestartTest(t, addr, "child")
+		<-exit
+		svr.Shutdown()
+		return
+	}
+
+	svr, _ := serveHotRestartTest(t, addr, "parent")
+	url := "http://" + svr.listener.Addr().String()
+	get := func(path string) string {
+		rsp, err := http.Get(url + path)
+		if err != nil {
+			t.Fatalf("get %v failed: %v", path, err)
+		}
+		defer rsp.Body.Close()
+		body, _ := ioutil.ReadAll(rsp.Body)
+		return string(body)
+	}
+
+	if tag := get("/"); tag != "parent" {
+		t.Fatalf("should be served by parent, got %v", tag)
+	}
+
+	process, err := hotRestart.startChild([]string{os.Args[0], "-test.run=^TestHotRestart$"}, []string{envHotRestartTestChild + "=1"}, time.Second*10)
+	if err != nil {
+		t.Fatalf("start child failed: %v", err)
+	}
+
+	// both processes accept before parent drains
+	http.DefaultClient.CloseIdleConnections()
+	if err = svr.Shutdown(); err != nil {
+		t.Fatalf("parent shutdown failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if tag := get("/"); tag != "child" {
+			t.Fatalf("should be served by child after parent drained, got %v", tag)
+		}
+	}
+	get("/exit")
+
+	state, err := process.Wait()
+	if err != nil || !state.Success() {
+		t.Fatalf("child exit failed: %v, %v", err, state)
+	}
+}
@@ -0,0 +1,29 @@
+package net
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// listen tcp, hot restart is unsupported on windows
+func ListenTcp(addr string) (*net.TCPListener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenTCP("tcp", tcpAddr)
+}
+
+// hot restart is unsupported on windows
+func HotRestartReady() {}
+
+// hot restart is unsupported on windows
+func HotRestart(timeout time.Duration) (int, error) {
+	return 0, ErrHotRestartUnsupported
+}
+
+// no hot restart signal on windows
+func isHotRestartSignal(sig os.Signal) bool {
+	return false
+}
@@ -0,0 +1,494 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nothollyhigh/kiss/log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// http middleware
+type HttpMiddleware func(next http.HandlerFunc) http.HandlerFunc
+
+// wrap handler with middlewares, the first middleware is the outermost
+func httpChain(h http.HandlerFunc, middlewares []HttpMiddleware) http.HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// context key of path params
+type httpParamsKey struct{}
+
+// path params of request
+func HttpParams(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(httpParamsKey{}).(map[string]string)
+	return params
+}
+
+// path param of request
+func HttpParam(r *http.Request, name string) string {
+	return HttpParams(r)[name]
+}
+
+// segment kinds, static segments match before params and params before wildcards
+const (
+	httpSegStatic = iota
+	httpSegParam
+	httpSegWildcard
+)
+
+// path segment
+type httpSegment struct {
+	kind int
+	name string
+}
+
+// route of router
+type httpRoute struct {
+	method   string
+	pattern  string
+	segments []httpSegment
+	handler  http.HandlerFunc
+}
+
+// split path to segments
+func splitHttpPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// parse pattern such as "/users/:id/*path"
+func parseHttpPattern(pattern string) []httpSegment {
+	parts := splitHttpPath(pattern)
+	segments := make([]httpSegment, len(parts))
+	for i, part := range parts {
+		switch {
+		case strings.HasPrefix(part, ":"):
+			segments[i] = httpSegment{httpSegParam, part[1:]}
+		case strings.HasPrefix(part, "*"):
+			if i != len(parts)-1 {
+				panic(fmt.Errorf("HttpRouter invalid pattern %v: wildcard must be the last segment", pattern))
+			}
+			segments[i] = httpSegment{httpSegWildcard, part[1:]}
+		default:
+			segments[i] = httpSegment{httpSegStatic, part}
+		}
+	}
+	return segments
+}
+
+// match path segments, returns params
+func (route *httpRoute) match(parts []string) (map[string]string, bool) {
+	var params map[string]string
+	for i, seg := range route.segments {
+		if seg.kind == httpSegWildcard {
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[seg.name] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case httpSegStatic:
+			if parts[i] != seg.name {
+				return nil, false
+			}
+		case httpSegParam:
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[seg.name] = parts[i]
+		}
+	}
+	if len(parts) != len(route.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// whether route a is more specific than b
+func (a *httpRoute) before(b *httpRoute) bool {
+	for i := 0; i < len(a.segments) && i < len(b.segments); i++ {
+		if a.segments[i].kind != b.segments[i].kind {
+			return a.segments[i].kind < b.segments[i].kind
+		}
+	}
+	return len(a.segments) > len(b.segments)
+}
+
+// lightweight http router, method and path patterns with params: "/users/:id", "/static/*path"
+type HttpRouter struct {
+	// routes by method
+	routes map[string][]*httpRoute
+
+	// middlewares wrapping all requests, including not found
+	middlewares []HttpMiddleware
+
+	// not found handler
+	notFound http.HandlerFunc
+
+	// root group
+	root *HttpRouterGroup
+}
+
+// serve http
+func (router *HttpRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	httpChain(router.dispatch, router.middlewares)(w, r)
+}
+
+// find route and call handler
+func (router *HttpRouter) dispatch(w http.ResponseWriter, r *http.Request) {
+	parts := splitHttpPath(r.URL.Path)
+
+	method := r.Method
+	for {
+		for _, route := range router.routes[method] {
+			if params, ok := route.match(parts); ok {
+				if params != nil {
+					r = r.WithContext(context.WithValue(r.Context(), httpParamsKey{}, params))
+				}
+				route.handler(w, r)
+				return
+			}
+		}
+		if method != http.MethodHead {
+			break
+		}
+		method = http.MethodGet
+	}
+
+	allowed := []string{}
+	for m, routes := range router.routes {
+		for _, route := range routes {
+			if _, ok := route.match(parts); ok {
+				allowed = append(allowed, m)
+				break
+			}
+		}
+	}
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if router.notFound != nil {
+		router.notFound(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// add route
+func (router *HttpRouter) addRoute(method string, pattern string, h http.HandlerFunc) {
+	route := &httpRoute{
+		method:   method,
+		pattern:  pattern,
+		segments: parseHttpPattern(pattern),
+		handler:  h,
+	}
+	routes := router.routes[method]
+	for _, r := range routes {
+		if r.pattern == pattern {
+			panic(fmt.Errorf("HttpRouter Handle failed: route %v %v exists", method, pattern))
+		}
+	}
+	routes = append(routes, route)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].before(routes[j])
+	})
+	router.routes[method] = routes
+}
+
+// add middlewares wrapping all requests
+func (router *HttpRouter) Use(middlewares ...HttpMiddleware) {
+	router.middlewares = append(router.middlewares, middlewares...)
+}
+
+// setting not found handler
+func (router *HttpRouter) HandleNotFound(h http.HandlerFunc) {
+	router.notFound = h
+}
+
+// handle method and pattern
+func (router *HttpRouter) Handle(method string, pattern string, h http.HandlerFunc) {
+	router.root.Handle(method, pattern, h)
+}
+
+// handle GET
+func (router *HttpRouter) GET(pattern string, h http.HandlerFunc) {
+	router.root.GET(pattern, h)
+}
+
+// handle POST
+func (router *HttpRouter) POST(pattern string, h http.HandlerFunc) {
+	router.root.POST(pattern, h)
+}
+
+// handle PUT
+func (router *HttpRouter) PUT(pattern string, h http.HandlerFunc) {
+	router.root.PUT(pattern, h)
+}
+
+// handle DELETE
+func (router *HttpRouter) DELETE(pattern string, h http.HandlerFunc) {
+	router.root.DELETE(pattern, h)
+}
+
+// handle PATCH
+func (router *HttpRouter) PATCH(pattern string, h http.HandlerFunc) {
+	router.root.PATCH(pattern, h)
+}
+
+// handle all methods
+func (router *HttpRouter) Any(pattern string, h http.HandlerFunc) {
+	router.root.Any(pattern, h)
+}
+
+// route group with prefix and middlewares
+func (router *HttpRouter) Group(prefix string, middlewares ...HttpMiddleware) *HttpRouterGroup {
+	return router.root.Group(prefix, middlewares...)
+}
+
+// http router factory, can be used as handler of NewHttpServer or WSServer.HandleHttp
+func NewHttpRouter() *HttpRouter {
+	router := &HttpRouter{
+		routes: map[string][]*httpRoute{},
+	}
+	router.root = &HttpRouterGroup{router: router}
+	return router
+}
+
+// route group
+type HttpRouterGroup struct {
+	router      *HttpRouter
+	prefix      string
+	middlewares []HttpMiddleware
+}
+
+// add middlewares for routes added after
+func (group *HttpRouterGroup) Use(middlewares ...HttpMiddleware) {
+	group.middlewares = append(group.middlewares, middlewares...)
+}
+
+// handle method and pattern
+func (group *HttpRouterGroup) Handle(method string, pattern string, h http.HandlerFunc) {
+	middlewares := append([]HttpMiddleware{}, group.middlewares...)
+	group.router.addRoute(method, group.prefix+"/"+strings.TrimPrefix(pattern, "/"), httpChain(h, middlewares))
+}
+
+// handle GET
+func (group *HttpRouterGroup) GET(pattern string, h http.HandlerFunc) {
+	group.Handle(http.MethodGet, pattern, h)
+}
+
+// handle POST
+func (group *HttpRouterGroup) POST(pattern string, h http.HandlerFunc) {
+	group.Handle(http.MethodPost, pattern, h)
+}
+
+// handle PUT
+func (group *HttpRouterGroup) PUT(pattern string, h http.HandlerFunc) {
+	group.Handle(http.MethodPut, pattern, h)
+}
+
+// handle DELETE
+func (group *HttpRouterGroup) DELETE(pattern string, h http.HandlerFunc) {
+	group.Handle(http.MethodDelete, pattern, h)
+}
+
+// handle PATCH
+func (group *HttpRouterGroup) PATCH(pattern string, h http.HandlerFunc) {
+	group.Handle(http.MethodPatch, pattern, h)
+}
+
+// handle all methods
+func (group *HttpRouterGroup) Any(pattern string, h http.HandlerFunc) {
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch, http.MethodOptions} {
+		group.Handle(method, pattern, h)
+	}
+}
+
+// sub group, inherits prefix and middlewares
+func (group *HttpRouterGroup) Group(prefix string, middlewares ...HttpMiddleware) *HttpRouterGroup {
+	return &HttpRouterGroup{
+		router:      group.router,
+		prefix:      group.prefix + "/" + strings.Trim(prefix, "/"),
+		middlewares: append(append([]HttpMiddleware{}, group.middlewares...), middlewares...),
+	}
+}
+
+// response writer recording status and size, keeps Flusher and Hijacker for sse and websocket
+type httpStatusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+// write header
+func (w *httpStatusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// write
+func (w *httpStatusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// flush
+func (w *httpStatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// hijack
+func (w *httpStatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker unsupported")
+}
+
+// recovery middleware, responses 500 on panic
+func HttpRecovery() HttpMiddleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Error("[HttpRouter] %v %v panic: %v\n%v", r.Method, r.URL.Path, err, string(debug.Stack()))
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next(w, r)
+		}
+	}
+}
+
+// logging middleware: method, path, status, size, cost and ip
+func HttpLogger() HttpMiddleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			t0 := time.Now()
+			sw := &httpStatusWriter{ResponseWriter: w}
+			defer func() {
+				log.Info("[HttpRouter] %v %v %v %v %v %v", r.Method, r.URL.RequestURI(), sw.status, sw.size, time.Since(t0), hostIp(r.RemoteAddr))
+			}()
+			next(sw, r)
+		}
+	}
+}
+
+// cors options
+type HttpCorsOpt struct {
+	// allowed origins, same patterns as WSServer.SetAllowedOrigins, empty means all
+	AllowOrigins []string
+	// allowed methods, empty means GET, POST, PUT, DELETE, PATCH
+	AllowMethods []string
+	// allowed headers, empty means headers of preflight request
+	AllowHeaders []string
+	// allow credentials
+	AllowCredentials bool
+	// preflight cache time
+	MaxAge time.Duration
+}
+
+// cors middleware, preflight requests are answered without calling next, use it on HttpRouter
+func HttpCors(opt *HttpCorsOpt) HttpMiddleware {
+	if opt == nil {
+		opt = &HttpCorsOpt{}
+	}
+	methods := opt.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch}
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(opt.AllowHeaders, ", ")
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next(w, r)
+				return
+			}
+			if !originAllowed(opt.AllowOrigins, r) {
+				if r.Method == http.MethodOptions {
+					http.Error(w, "origin not allowed", http.StatusForbidden)
+					return
+				}
+				next(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if len(opt.AllowOrigins) == 0 && !opt.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if opt.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", allowMethods)
+				if allowHeaders != "" {
+					h.Set("Access-Control-Allow-Headers", allowHeaders)
+				} else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+					h.Set("Access-Control-Allow-Headers", reqHeaders)
+				}
+				if opt.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(opt.MaxAge/time.Second)))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// auth middleware, check is called with the token of WSTokenFromRequest. returning
+// a *WSAuthError rejects the request with its status and reason, other errors with 401
+func HttpAuth(check func(r *http.Request, token string) error) HttpMiddleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if err := check(r, WSTokenFromRequest(r)); err != nil {
+				status, reason := wsAuthStatus(err, http.StatusUnauthorized)
+				http.Error(w, reason, status)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package net
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHttpRouter(t *testing.T) {
+	router := NewHttpRouter()
+	router.Use(HttpRecovery(), HttpCors(&HttpCorsOpt{AllowOrigins: []string{"https://*.kiss.com"}}))
+
+	write := func(s string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(s))
+		}
+	}
+	tag := func(s string) HttpMiddleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Tag", s)
+				next(w, r)
+			}
+		}
+	}
+
+	router.GET("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + HttpParam(r, "id")))
+	})
+	router.GET("/users/me", write("me"))
+	router.GET("/static/*path", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("static " + HttpParam(r, "path")))
+	})
+	router.GET("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("test")
+	})
+
+	api := router.Group("/api", tag("a"))
+	api.Use(tag("b"))
+	v1 := api.Group("v1", HttpAuth(func(r *http.Request, token string) error {
+		if token == "" {
+			return errors.New("token required")
+		}
+		if token != "kiss" {
+			return NewWSAuthError(http.StatusForbidden, "forbidden")
+		}
+		return nil
+	}))
+	v1.POST("/items/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("item " + HttpParam(r, "id")))
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for _, c := range []struct {
+		method string
+		path   string
+		header map[string]string
+		status int
+		body   string
+	}{
+		{"GET", "/users/1", nil, 200, "user 1"},
+		{"GET", "/users/me", nil, 200, "me"},
+		{"HEAD", "/users/me", nil, 200, ""},
+		{"GET", "/users/1/x", nil, 404, "404 page not found"},
+		{"GET", "/static/js/app.js", nil, 200, "static js/app.js"},
+		{"POST", "/users/1", nil, 405, "Method Not Allowed"},
+		{"GET", "/panic", nil, 500, "Internal Server Error"},
+		{"POST", "/api/v1/items/7", nil, 401, "token required"},
+		{"POST", "/api/v1/items/7", map[string]string{"Authorization": "Bearer x"}, 403, "forbidden"},
+		{"POST", "/api/v1/items/7?token=kiss", nil, 200, "item 7"},
+		{"OPTIONS", "/api/v1/items/7", map[string]string{"Origin": "https://www.kiss.com", "Access-Control-Request-Method": "POST"}, 204, ""},
+		{"OPTIONS", "/api/v1/items/7", map[string]string{"Origin": "https://evil.com", "Access-Control-Request-Method": "POST"}, 403, "origin not allowed"},
+	} {
+		req, _ := http.NewRequest(c.method, server.URL+c.path, nil)
+		for k, v := range c.header {
+			req.Header.Set(k, v)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v failed: %v", c.method, c.path, err)
+		}
+		buf := make([]byte, 128)
+		n, _ := rsp.Body.Read(buf)
+		rsp.Body.Close()
+		body := strings.TrimSpace(string(buf[:n]))
+		if rsp.StatusCode != c.status || body != c.body {
+			t.Fatalf("%v %v should be %v %q, got %v %q", c.method, c.path, c.status, c.body, rsp.StatusCode, body)
+		}
+		if tags := strings.Join(rsp.Header["X-Tag"], ""); strings.HasPrefix(c.path, "/api") && c.method != "OPTIONS" && tags != "ab" {
+			t.Fatalf("group middlewares should run in order, got %v", tags)
+		}
+		if c.status == 405 && rsp.Header.Get("Allow") != "GET" {
+			t.Fatalf("Allow header should be GET, got %v", rsp.Header.Get("Allow"))
+		}
+		if c.status == 204 && rsp.Header.Get("Access-Control-Allow-Origin") != "https://www.kiss.com" {
+			t.Fatalf("cors preflight header mismatch: %v", rsp.Header)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package net
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/metrics"
 	"github.com/nothollyhigh/kiss/util"
 	"net"
 	"net/http"
@@ -10,6 +14,7 @@ import (
 	rpprof "runtime/pprof"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -21,6 +26,54 @@ type HttpHandlerWrapper struct {
 	over         bool
 	pprofEnabled bool
 	pprofRoutes  map[string]func(w http.ResponseWriter, r *http.Request)
+
+	// path of prometheus metrics
+	metricsPath string
+
+	// root path of admin endpoints
+	adminRoot string
+	admin     *Admin
+
+	// handlers of hijacked connections, such as websocket, not tracked by http.Server.Shutdown
+	hijacked    sync.WaitGroup
+	hijackedNum int64
+}
+
+// response writer tracking hijacked connection
+type httpHijackWriter struct {
+	http.ResponseWriter
+	wrapper  *HttpHandlerWrapper
+	hijacked bool
+}
+
+// flush
+func (w *httpHijackWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// hijack
+func (w *httpHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker unsupported")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && !w.hijacked {
+		w.hijacked = true
+		w.wrapper.hijacked.Add(1)
+		atomic.AddInt64(&w.wrapper.hijackedNum, 1)
+	}
+	return conn, rw, err
+}
+
+// handler of hijacked connection returned
+func (w *httpHijackWriter) done() {
+	if w.hijacked {
+		atomic.AddInt64(&w.wrapper.hijackedNum, -1)
+		w.wrapper.hijacked.Done()
+	}
 }
 
 // enable pprof
@@ -48,13 +101,38 @@ func (wrapper *HttpHandlerWrapper) EnablePProf(root string) {
 	}
 }
 
+// enable metrics in prometheus text format on path
+func (wrapper *HttpHandlerWrapper) EnableMetrics(path string) {
+	wrapper.metricsPath = path
+	log.Debug("http server init metrics path: %v", path)
+}
+
+// enable admin endpoints under root
+func (wrapper *HttpHandlerWrapper) EnableAdmin(root string, admin *Admin) {
+	wrapper.adminRoot = strings.TrimSuffix(root, "/")
+	wrapper.admin = admin
+	log.Debug("http server init admin path: %v", root)
+}
+
 // serve http
 func (wrapper *HttpHandlerWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	wrapper.Add(1)
 	defer wrapper.Done()
 	defer util.HandlePanic()
 
+	hw := &httpHijackWriter{ResponseWriter: w, wrapper: wrapper}
+	defer hw.done()
+	w = hw
+
 	if !wrapper.over {
+		if wrapper.metricsPath != "" && r.URL.Path == wrapper.metricsPath {
+			metrics.Handler().ServeHTTP(w, r)
+			return
+		}
+		if wrapper.admin != nil && (r.URL.Path == wrapper.adminRoot || strings.HasPrefix(r.URL.Path, wrapper.adminRoot+"/")) {
+			wrapper.admin.ServeHTTP(w, r)
+			return
+		}
 		if wrapper.pprofEnabled {
 			if h, ok := wrapper.pprofRoutes[r.URL.Path]; ok {
 				h(w, r)
@@ -92,6 +170,18 @@ func (svr *HttpServer) EnablePProf(root string) {
 	wraper.EnablePProf(root)
 }
 
+// enable metrics in prometheus text format on path
+func (svr *HttpServer) EnableMetrics(path string) {
+	wraper, _ := svr.server.Handler.(*HttpHandlerWrapper)
+	wraper.EnableMetrics(path)
+}
+
+// enable admin endpoints under root, which should only be reachable by operators
+func (svr *HttpServer) EnableAdmin(root string, admin *Admin) {
+	wraper, _ := svr.server.Handler.(*HttpHandlerWrapper)
+	wraper.EnableAdmin(root, admin)
+}
+
 // serve http
 func (svr *HttpServer) Serve() {
 	log.Debug("[HttpServer %v] Serve On: %v", svr.tag, svr.addr)
@@ -106,25 +196,64 @@ func (svr *HttpServer) ServeTLS(certFile, keyFile string) {
 	log.Debug("[HttpServer %v] Exit: %v", svr.tag, err)
 }
 
-// graceful shutdown
+// graceful shutdown with timeout of NewHttpServer, onTimeout is called if timeout
 func (svr *HttpServer) Shutdown() error {
-	err := svr.listener.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), svr.timeout)
+	defer cancel()
+	err := svr.ShutdownWithContext(ctx)
+	if err == ErrHttpServerShutdownTimeout && svr.onTimeout != nil {
+		svr.onTimeout()
+	}
+	return err
+}
+
+// graceful shutdown: close listener and idle connections, wait for active requests and hijacked
+// connections until ctx done. hijacked connections such as websocket should be closed by their owner,
+// returns ErrHttpServerShutdownTimeout if ctx done before that
+func (svr *HttpServer) ShutdownWithContext(ctx context.Context) error {
 	log.Debug("[HttpServer %v] shutdown waitting...", svr.tag)
 	wrapper := svr.server.Handler.(*HttpHandlerWrapper)
 	wrapper.over = true
-	wrapper.Done()
-	timer := time.AfterFunc(svr.timeout, func() {
-		log.Error("[HttpServer %v] shutdown timeout(%v)", svr.tag, svr.timeout)
-		if svr.onTimeout != nil {
-			svr.onTimeout()
+
+	err := svr.server.Shutdown(ctx)
+	// not tracked by http.Server if not serving yet
+	svr.listener.Close()
+	if err == nil {
+		done := make(chan struct{})
+		util.Go(func() {
+			wrapper.hijacked.Wait()
+			close(done)
+		})
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
 		}
-	})
-	defer timer.Stop()
-	wrapper.Wait()
+	}
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		log.Error("[HttpServer %v] shutdown timeout, hijacked connections: %v", svr.tag, svr.HijackedNum())
+		return ErrHttpServerShutdownTimeout
+	}
 	log.Debug("[HttpServer %v] shutdown done.", svr.tag)
 	return err
 }
 
+// num of hijacked connections whose handlers are running, such as websocket
+func (svr *HttpServer) HijackedNum() int64 {
+	wrapper := svr.server.Handler.(*HttpHandlerWrapper)
+	return atomic.LoadInt64(&wrapper.hijackedNum)
+}
+
+// enable proxy protocol v1/v2 for connections from trusted proxies,
+// then http.Request.RemoteAddr is the client address in the header
+func (svr *HttpServer) EnableProxyProtocol(trustedProxies []string) error {
+	l, ok := svr.listener.(*Listener)
+	if !ok {
+		return ErrProxyProtocolUnsupported
+	}
+	return l.EnableProxyProtocol(trustedProxies)
+}
+
 // setting tcp socket option
 func (svr *HttpServer) SetSocketOpt(opt *SocketOpt) {
 	if opt != nil {
@@ -167,7 +296,6 @@ func NewHttpServer(tag string, addr string, handler http.Handler, to time.Durati
 	wrapper := &HttpHandlerWrapper{
 		handler: handler,
 	}
-	wrapper.Add(1)
 
 	readTimeout := time.Second * 120
 	readHeaderTimeout := time.Second * 60
@@ -216,6 +344,13 @@ func ServeHttp(tag string, addr string, handler http.Handler, timeout time.Durat
 	}
 
 	util.HandleSignal(func(sig os.Signal) {
+		if isHotRestartSignal(sig) {
+			if _, err := HotRestart(0); err == nil {
+				svr.Shutdown()
+				os.Exit(0)
+			}
+			return
+		}
 		if sig == syscall.SIGTERM || sig == syscall.SIGINT {
 			svr.Shutdown()
 			os.Exit(0)
@@ -237,6 +372,13 @@ func ServeHttps(tag string, addr string, handler http.Handler, timeout time.Dura
 	}
 
 	util.HandleSignal(func(sig os.Signal) {
+		if isHotRestartSignal(sig) {
+			if _, err := HotRestart(0); err == nil {
+				svr.Shutdown()
+				os.Exit(0)
+			}
+			return
+		}
 		if sig == syscall.SIGTERM || sig == syscall.SIGINT {
 			svr.Shutdown()
 			os.Exit(0)
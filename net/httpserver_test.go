@@ -0,0 +1,104 @@
+package net
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHttpServerShutdown(t *testing.T) {
+	release := make(chan struct{})
+	upgrader := &websocket.Upgrader{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			<-release
+			conn.Close()
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	svr, err := NewHttpServer("shutdown", "127.0.0.1:0", handler, time.Second, nil, nil)
+	if err != nil {
+		t.Fatalf("NewHttpServer failed: %v", err)
+	}
+	go svr.Serve()
+	addr := svr.listener.Addr().String()
+
+	// idle keep-alive connection is closed by shutdown
+	rsp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("http get failed: %v", err)
+	}
+	rsp.Body.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatalf("websocket dial failed: %v", err)
+	}
+	defer conn.Close()
+	for i := 0; i < 100 && svr.HijackedNum() != 1; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if svr.HijackedNum() != 1 {
+		t.Fatalf("HijackedNum should be 1, got %v", svr.HijackedNum())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err = svr.ShutdownWithContext(ctx); err != ErrHttpServerShutdownTimeout {
+		t.Fatalf("shutdown with hijacked connection should timeout, got %v", err)
+	}
+
+	close(release)
+	if err = svr.ShutdownWithContext(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if svr.HijackedNum() != 0 {
+		t.Fatalf("HijackedNum should be 0, got %v", svr.HijackedNum())
+	}
+	if _, err = http.Get("http://" + addr + "/"); err == nil {
+		t.Fatalf("request after shutdown should fail")
+	}
+}
+
+func TestWebsocketServerShutdown(t *testing.T) {
+	server, err := NewWebsocketServer("shutdown", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.HandleWs("/ws")
+	go server.Serve()
+
+	client, err := NewWebsocketClient("ws://" + server.listener.Addr().String() + "/ws")
+	if err != nil {
+		t.Fatalf("NewWebsocketClient failed: %v", err)
+	}
+	defer client.Stop()
+	closed := make(chan struct{})
+	client.OnClose("test", func(*WSClient) {
+		close(closed)
+	})
+
+	done := make(chan error, 1)
+	server.Shutdown(time.Second*3, func(err error) {
+		done <- err
+	})
+	if err = <-done; err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if server.HijackedNum() != 0 {
+		t.Fatalf("HijackedNum should be 0, got %v", server.HijackedNum())
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 3):
+		t.Fatalf("client should be closed by server shutdown")
+	}
+}
@@ -0,0 +1,294 @@
+package net
+
+import (
+	"fmt"
+	"github.com/nothollyhigh/kiss/rate"
+	"github.com/nothollyhigh/kiss/util"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ip nets parsed from cidrs or ips
+type IpNets []*net.IPNet
+
+// contains ip
+func (nets IpNets) ContainsIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains ip string
+func (nets IpNets) Contains(ip string) bool {
+	if len(nets) == 0 {
+		return false
+	}
+	return nets.ContainsIP(net.ParseIP(ip))
+}
+
+// parse cidrs or ips, such as "10.0.0.0/8", "127.0.0.1", "::1"
+func ParseIpNets(cidrs []string) (IpNets, error) {
+	nets := IpNets{}
+	for _, v := range cidrs {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.Contains(v, "/") {
+			_, n, err := net.ParseCIDR(v)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, n)
+			continue
+		}
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip: %v", v)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			nets = append(nets, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+		} else {
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+		}
+	}
+	return nets, nil
+}
+
+// ip of addr
+func addrIp(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return hostIp(addr.String())
+}
+
+// ip of "host:port"
+func hostIp(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	return host
+}
+
+// close callback tag for releasing ip filter count
+type ipFilterCloseTag struct{}
+
+// ip filter config
+type IpFilterConfig struct {
+	// allowed cidrs, all ips are allowed if empty
+	Allow []string `json:"allow"`
+	// denied cidrs, checked before allow
+	Deny []string `json:"deny"`
+	// trusted proxies, their connections are limited by real ip instead of remote ip
+	TrustedProxies []string `json:"trustedProxies"`
+	// max concurrent connections per ip, 0 means no limit
+	MaxConnPerIp int64 `json:"maxConnPerIp"`
+	// new connections per second per ip, 0 means no limit
+	ConnRate float64 `json:"connRate"`
+	// new connections burst per ip
+	ConnBurst int `json:"connBurst"`
+}
+
+// ip allow/deny lists and per ip connection limits
+type IpFilter struct {
+	sync.RWMutex
+
+	allow          IpNets
+	deny           IpNets
+	trustedProxies IpNets
+	maxConnPerIp   int64
+	connRate       *rate.Limiter
+
+	// concurrent connections by ip
+	conns map[string]int64
+}
+
+// reload rules, current connections are kept
+func (f *IpFilter) Reload(conf *IpFilterConfig) error {
+	allow, err := ParseIpNets(conf.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := ParseIpNets(conf.Deny)
+	if err != nil {
+		return err
+	}
+	trusted, err := ParseIpNets(conf.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	var connRate *rate.Limiter
+	if conf.ConnRate > 0 {
+		burst := conf.ConnBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		connRate = rate.NewLimiter(conf.ConnRate, burst)
+	}
+
+	f.Lock()
+	f.allow = allow
+	f.deny = deny
+	f.trustedProxies = trusted
+	f.maxConnPerIp = conf.MaxConnPerIp
+	f.connRate = connRate
+	f.Unlock()
+
+	return nil
+}
+
+// reload rules from json file
+func (f *IpFilter) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if data, err = util.TrimJson(data); err != nil {
+		return err
+	}
+	conf := &IpFilterConfig{}
+	if err = json.Unmarshal(data, conf); err != nil {
+		return err
+	}
+	return f.Reload(conf)
+}
+
+// setting allow/deny lists
+func (f *IpFilter) SetRules(allow []string, deny []string) error {
+	allowNets, err := ParseIpNets(allow)
+	if err != nil {
+		return err
+	}
+	denyNets, err := ParseIpNets(deny)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	f.allow = allowNets
+	f.deny = denyNets
+	f.Unlock()
+	return nil
+}
+
+// setting trusted proxies
+func (f *IpFilter) SetTrustedProxies(cidrs []string) error {
+	nets, err := ParseIpNets(cidrs)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	f.trustedProxies = nets
+	f.Unlock()
+	return nil
+}
+
+// setting max concurrent connections per ip, 0 means no limit
+func (f *IpFilter) SetMaxConnPerIp(max int64) {
+	f.Lock()
+	f.maxConnPerIp = max
+	f.Unlock()
+}
+
+// setting new connections per second per ip, 0 means no limit
+func (f *IpFilter) SetConnRate(connRate float64, burst int) {
+	f.Lock()
+	if connRate > 0 {
+		if burst <= 0 {
+			burst = 1
+		}
+		f.connRate = rate.NewLimiter(connRate, burst)
+	} else {
+		f.connRate = nil
+	}
+	f.Unlock()
+}
+
+// is trusted proxy
+func (f *IpFilter) IsTrustedProxy(ip string) bool {
+	f.RLock()
+	defer f.RUnlock()
+	return f.trustedProxies.Contains(ip)
+}
+
+// check allow/deny lists
+func (f *IpFilter) Check(ip string) error {
+	f.RLock()
+	defer f.RUnlock()
+	return f.check(ip)
+}
+
+// check allow/deny lists, should be called with lock
+func (f *IpFilter) check(ip string) error {
+	addr := net.ParseIP(ip)
+	if f.deny.ContainsIP(addr) {
+		return ErrIpDenied
+	}
+	if len(f.allow) > 0 && !f.allow.ContainsIP(addr) {
+		return ErrIpDenied
+	}
+	return nil
+}
+
+// check and count a new connection of ip, should call Release when the connection closed
+func (f *IpFilter) Acquire(ip string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if err := f.check(ip); err != nil {
+		return err
+	}
+	if f.maxConnPerIp > 0 && f.conns[ip] >= f.maxConnPerIp {
+		return ErrIpConnLimit
+	}
+	if f.connRate != nil && !f.connRate.Allow(ip) {
+		return ErrIpConnRateLimit
+	}
+	f.conns[ip]++
+
+	return nil
+}
+
+// release a connection of ip
+func (f *IpFilter) Release(ip string) {
+	f.Lock()
+	defer f.Unlock()
+	if n := f.conns[ip] - 1; n > 0 {
+		f.conns[ip] = n
+	} else {
+		delete(f.conns, ip)
+	}
+}
+
+// concurrent connections of ip
+func (f *IpFilter) ConnNum(ip string) int64 {
+	f.RLock()
+	defer f.RUnlock()
+	return f.conns[ip]
+}
+
+// http status for ip filter error
+func ipFilterHttpStatus(err error) int {
+	if err == ErrIpDenied {
+		return http.StatusForbidden
+	}
+	return http.StatusTooManyRequests
+}
+
+// ip filter factory
+func NewIpFilter() *IpFilter {
+	return &IpFilter{
+		conns: map[string]int64{},
+	}
+}
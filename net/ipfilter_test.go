@@ -0,0 +1,90 @@
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIpFilter(t *testing.T) {
+	f := NewIpFilter()
+	err := f.Reload(&IpFilterConfig{
+		Allow:          []string{"10.0.0.0/8", "127.0.0.1"},
+		Deny:           []string{"10.0.0.2"},
+		TrustedProxies: []string{"10.1.0.0/16"},
+		MaxConnPerIp:   2,
+	})
+	if err != nil {
+		t.Fatalf("IpFilter Reload failed: %v", err)
+	}
+
+	if err = f.Check("127.0.0.1"); err != nil {
+		t.Fatalf("127.0.0.1 should be allowed: %v", err)
+	}
+	if err = f.Check("10.0.0.2"); err != ErrIpDenied {
+		t.Fatalf("10.0.0.2 should be denied: %v", err)
+	}
+	if err = f.Check("192.168.0.1"); err != ErrIpDenied {
+		t.Fatalf("192.168.0.1 should not be allowed: %v", err)
+	}
+	if !f.IsTrustedProxy("10.1.2.3") || f.IsTrustedProxy("10.2.0.1") {
+		t.Fatalf("IsTrustedProxy failed")
+	}
+
+	ip := "10.0.0.1"
+	for i := 0; i < 2; i++ {
+		if err = f.Acquire(ip); err != nil {
+			t.Fatalf("Acquire %v failed: %v", i, err)
+		}
+	}
+	if err = f.Acquire(ip); err != ErrIpConnLimit {
+		t.Fatalf("Acquire should be limited: %v", err)
+	}
+	f.Release(ip)
+	if f.ConnNum(ip) != 1 {
+		t.Fatalf("ConnNum should be 1, got %v", f.ConnNum(ip))
+	}
+
+	f.SetMaxConnPerIp(0)
+	f.SetConnRate(1, 1)
+	if err = f.Acquire("127.0.0.1"); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if err = f.Acquire("127.0.0.1"); err != ErrIpConnRateLimit {
+		t.Fatalf("Acquire should be rate limited: %v", err)
+	}
+
+	if _, err = ParseIpNets([]string{"bad ip"}); err == nil {
+		t.Fatalf("ParseIpNets should fail")
+	}
+}
+
+func TestSetRealIpPipe(t *testing.T) {
+	const cmdEcho = uint32(4327)
+
+	server := NewTcpServer("realip")
+	server.SetIpFilter(NewIpFilter())
+	server.Handle(cmdEcho, func(client *TcpClient, msg IMessage) {
+		client.SendMsg(NewMessage(cmdEcho, []byte(client.Ip())))
+	})
+	// engine of server handles messages after started
+	go server.Start(freeTcpAddr(t))
+	time.Sleep(time.Millisecond * 50)
+
+	h, err := NewPipeHarness(server.TcpEngin)
+	if err != nil {
+		t.Fatalf("NewPipeHarness failed: %v", err)
+	}
+	defer h.Close()
+	defer server.Stop()
+
+	// pipe sessions are not from trusted proxies, real ip is ignored
+	h.Send(NewMessage(CmdSetReaIp, []byte("10.0.0.1")))
+	h.Send(NewMessage(cmdEcho, nil))
+	msg, err := h.Expect(cmdEcho, time.Second)
+	if err != nil {
+		t.Fatalf("Expect failed: %v", err)
+	}
+	if string(msg.Body()) == "10.0.0.1" {
+		t.Fatalf("real ip from untrusted session should be ignored")
+	}
+}
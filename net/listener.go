@@ -21,11 +21,22 @@ type SocketOpt struct {
 // tcp listener
 type Listener struct {
 	*net.TCPListener
-	opt *SocketOpt
+	opt        *SocketOpt
+	proxyProto *proxyProtocol
+}
+
+// enable proxy protocol v1/v2 for connections from trusted proxies
+func (ln *Listener) EnableProxyProtocol(trustedProxies []string) error {
+	proxy, err := newProxyProtocol(trustedProxies)
+	if err != nil {
+		return err
+	}
+	ln.proxyProto = proxy
+	return nil
 }
 
 // accept
-func (ln Listener) Accept() (net.Conn, error) {
+func (ln *Listener) Accept() (net.Conn, error) {
 	tc, err := ln.AcceptTCP()
 	if err != nil {
 		return tc, err
@@ -49,6 +60,10 @@ func (ln Listener) Accept() (net.Conn, error) {
 		}
 	}
 
+	if ln.proxyProto != nil {
+		return &proxyConn{Conn: tc, proxy: ln.proxyProto}, nil
+	}
+
 	return tc, nil
 }
 
@@ -57,10 +72,7 @@ func NewListener(addr string, opt *SocketOpt) (net.Listener, error) {
 	if addr == "" {
 		addr = ":http"
 	}
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		listener, err = net.Listen("tcp6", addr)
-	}
+	listener, err := ListenTcp(addr)
 
 	if err == nil {
 		// if opt != nil {
@@ -74,7 +86,7 @@ func NewListener(addr string, opt *SocketOpt) (net.Listener, error) {
 		// 		opt.WriteBufLen = defautSendBufLen
 		// 	}
 		// }
-		return Listener{listener.(*net.TCPListener), opt}, err
+		return &Listener{TCPListener: listener, opt: opt}, err
 	}
 	return nil, err
 }
@@ -2,6 +2,7 @@ package net
 
 import (
 	"encoding/binary"
+	"github.com/nothollyhigh/kiss/trace"
 )
 
 // tcp async message for send queue
@@ -37,6 +38,8 @@ const (
 
 	// default gzip cipher flag mask
 	CmdFlagMaskGzip = uint32(1) << 31
+	// trace context flag mask, span context is appended to the body of rpc frames
+	CmdFlagMaskTrace = uint32(1) << 30
 
 	// reserved cmd: ping
 	CmdPing = uint32(0x1 << 24)
@@ -88,6 +91,9 @@ type IMessage interface {
 type Message struct {
 	data    []byte
 	rawData []byte
+
+	// span context carried by rpc frame
+	trace trace.SpanContext
 }
 
 // body length
@@ -0,0 +1,82 @@
+package net
+
+import (
+	"fmt"
+	"github.com/nothollyhigh/kiss/metrics"
+	"strconv"
+	"time"
+)
+
+const (
+	metricProtoTcp = "tcp"
+	metricProtoWS  = "ws"
+
+	// cmd label of messages without registered handlers, or sent as raw data
+	metricCmdOther = "other"
+	metricCmdRaw   = "raw"
+)
+
+var (
+	// buckets of send queue depth
+	DefaultSendQueueDepthBuckets = []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 4096}
+)
+
+var (
+	metricConnections = metrics.NewGaugeVec("kiss_connections", "current connections of server", "proto", "server")
+	metricAccepted    = metrics.NewCounterVec("kiss_accepted_total", "accepted connections of server", "proto", "server")
+
+	metricRecvMessages = metrics.NewCounterVec("kiss_received_messages_total", "received messages by cmd", "proto", "cmd")
+	metricRecvBytes    = metrics.NewCounterVec("kiss_received_bytes_total", "received message bytes by cmd", "proto", "cmd")
+	metricSentMessages = metrics.NewCounterVec("kiss_sent_messages_total", "sent messages by cmd", "proto", "cmd")
+	metricSentBytes    = metrics.NewCounterVec("kiss_sent_bytes_total", "sent message bytes by cmd", "proto", "cmd")
+
+	metricHandlerDuration = metrics.NewHistogramVec("kiss_handler_duration_seconds", "handler latency by cmd or rpc method", nil, "proto", "handler")
+
+	metricSendQueueDepth   = metrics.NewHistogramVec("kiss_send_queue_depth", "send queue depth when message is written", DefaultSendQueueDepthBuckets, "proto")
+	metricSendQueueDropped = metrics.NewCounterVec("kiss_send_queue_dropped_total", "messages dropped for full send queue", "proto")
+
+	metricRpcClientDuration = metrics.NewHistogramVec("kiss_rpc_client_duration_seconds", "rpc call latency by method", nil, "method")
+	metricRpcClientErrors   = metrics.NewCounterVec("kiss_rpc_client_errors_total", "rpc call errors by method", "method")
+	metricRpcServerErrors   = metrics.NewCounterVec("kiss_rpc_server_errors_total", "rpc errors responded by method", "method")
+)
+
+// cmd label, reserved cmds and cmds with handler are labeled by number, others, such as unknown cmds sent by
+// clients, are labeled other to bound cardinality
+func metricCmdLabel(cmd uint32, handled bool) string {
+	switch {
+	case handled, cmd == CmdPing, cmd == CmdPing2, cmd == CmdSetReaIp, cmd == CmdRpcMethod, cmd == CmdRpcError:
+		return strconv.FormatUint(uint64(cmd), 10)
+	}
+	return metricCmdOther
+}
+
+// handler label of dispatch tag
+func metricHandlerLabel(tag interface{}) string {
+	switch v := tag.(type) {
+	case string:
+		return v
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	}
+	return fmt.Sprintf("%v", tag)
+}
+
+// count received message
+func metricRecv(proto string, cmd string, msg IMessage) {
+	metricRecvMessages.With(proto, cmd).Inc()
+	metricRecvBytes.With(proto, cmd).Add(float64(DEFAULT_MESSAGE_HEAD_LEN + msg.BodyLen()))
+}
+
+// count sent message or raw data
+func metricSent(proto string, cmd string, n int) {
+	metricSentMessages.With(proto, cmd).Inc()
+	metricSentBytes.With(proto, cmd).Add(float64(n))
+}
+
+// observe rpc call
+func metricRpcCall(method string, begin time.Time, err error) {
+	metricRpcClientDuration.With(method).Since(begin)
+	if err != nil {
+		metricRpcClientErrors.With(method).Inc()
+	}
+}
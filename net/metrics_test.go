@@ -0,0 +1,114 @@
+package net
+
+import (
+	"github.com/gorilla/websocket"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	// counters are global, cmd and route are not used by other tests
+	const cmdEcho = uint32(4321)
+
+	server, err := NewWebsocketServer("metrics", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.HandleWs("/metrics_ws")
+	server.Handle(cmdEcho, func(cli *WSClient, msg IMessage) {
+		cli.SendMsg(NewMessage(cmdEcho, msg.Body()))
+	})
+	server.EnableMetrics("/metrics")
+
+	httpServer := httptest.NewServer(server.Server().Handler)
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/metrics_ws", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	conn.WriteMessage(websocket.BinaryMessage, NewMessage(999, nil).Data())
+	conn.WriteMessage(websocket.BinaryMessage, NewMessage(cmdEcho, []byte("hello")).Data())
+	if _, _, err = conn.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+
+	rsp, err := http.Get(httpServer.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("get metrics failed: %v", err)
+	}
+	defer rsp.Body.Close()
+	body, _ := ioutil.ReadAll(rsp.Body)
+	text := string(body)
+
+	for _, line := range []string{
+		`kiss_connections{proto="ws",server="/metrics_ws"} 1`,
+		`kiss_received_messages_total{proto="ws",cmd="4321"} 1`,
+		`kiss_received_bytes_total{proto="ws",cmd="4321"} 21`,
+		`# TYPE kiss_send_queue_depth histogram`,
+		`kiss_sent_messages_total{proto="ws",cmd="4321"} 1`,
+		`kiss_handler_duration_seconds_count{proto="ws",handler="4321"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Fatalf("metrics should contain %v:\n%v", line, text)
+		}
+	}
+	if !strings.Contains(text, `kiss_received_messages_total{proto="ws",cmd="other"} `) {
+		t.Fatalf("cmd without handler should be labeled other:\n%v", text)
+	}
+}
+
+func TestMetricsSentCmdGzip(t *testing.T) {
+	// counters are global, cmd is not used by other tests
+	const cmdGzip = uint32(4322)
+
+	h, err := NewPipeHarness(nil)
+	if err != nil {
+		t.Fatalf("NewPipeHarness failed: %v", err)
+	}
+	defer h.Close()
+	session := h.Session()
+	session.SetCipher(NewCipherGzip(0))
+	sent := metricSentMessages.With(metricProtoTcp, "4322").Value()
+	flagged := strconv.FormatUint(uint64(cmdGzip|CmdFlagMaskGzip), 10)
+	if err = session.SendMsg(NewMessage(cmdGzip, make([]byte, 1000))); err != nil {
+		t.Fatalf("SendMsg failed: %v", err)
+	}
+
+	if n := metricSentMessages.With(metricProtoTcp, "4322").Value() - sent; n != 1 {
+		t.Fatalf("sent messages of cmd 4322 should be 1, got %v", n)
+	}
+	if n := metricSentMessages.With(metricProtoTcp, flagged).Value(); n != 0 {
+		t.Fatalf("cmd label should not contain gzip flag, got %v of %v", n, flagged)
+	}
+}
+
+func TestMetricsUnknownCmd(t *testing.T) {
+	h, err := NewPipeHarness(nil)
+	if err != nil {
+		t.Fatalf("NewPipeHarness failed: %v", err)
+	}
+	defer h.Close()
+
+	// unknown cmds above CmdUserMax are sent by clients, they are counted as other instead of new series
+	const cmdUnknown = CmdRpcError + 100
+	other := metricRecvMessages.With(metricProtoTcp, metricCmdOther).Value()
+	h.Send(NewMessage(cmdUnknown, nil))
+	for i := 0; i < 100 && metricRecvMessages.With(metricProtoTcp, metricCmdOther).Value() == other; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := metricRecvMessages.With(metricProtoTcp, metricCmdOther).Value() - other; n != 1 {
+		t.Fatalf("unknown cmd should be counted as other, got %v", n)
+	}
+	label := strconv.FormatUint(uint64(cmdUnknown), 10)
+	if n := metricRecvMessages.With(metricProtoTcp, label).Value(); n != 0 {
+		t.Fatalf("unknown cmd should not be labeled by number, got %v", n)
+	}
+}
@@ -0,0 +1,337 @@
+//go:build !windows
+// +build !windows
+
+package net
+
+import (
+	"encoding/binary"
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/util"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// session state transferred with connection fd
+type tcpSessionState struct {
+	RecvSeq   int64  `json:"recvSeq"`
+	SendSeq   int64  `json:"sendSeq"`
+	RecvKey   uint32 `json:"recvKey"`
+	SendKey   uint32 `json:"sendKey"`
+	RealIp    string `json:"realIp,omitempty"`
+	ProxyIp   bool   `json:"proxyIp,omitempty"`
+	Pending   []byte `json:"pending,omitempty"`
+	UserState []byte `json:"userState,omitempty"`
+}
+
+// stop loops at message boundary and collect state, the connection is kept open. loops are waited until deadline
+// if it's not zero, the client is resumed later if they are stopped after deadline
+func (server *TcpServer) freezeClient(client *TcpClient, deadline time.Time) (*tcpSessionState, error) {
+	if server.RecvHandler != nil {
+		return nil, ErrMigrationCustomRecv
+	}
+
+	client.Lock()
+	running := client.running
+	if running {
+		atomic.StoreInt32(&client.migrating, 1)
+	}
+	client.Unlock()
+	if !running {
+		return nil, ErrTcpClientIsStopped
+	}
+
+	// interrupt blocking read, partial frame is saved by DefaultRecvMsg
+	client.conn.SetReadDeadline(time.Now())
+	stopped := make(chan struct{})
+	util.Go(func() {
+		client.loopWg.Wait()
+		close(stopped)
+	})
+	if !deadline.IsZero() {
+		after := time.NewTimer(time.Until(deadline))
+		defer after.Stop()
+		select {
+		case <-stopped:
+		case <-after.C:
+			// such as a long running inline handler
+			util.Go(func() {
+				<-stopped
+				client.resume(client.unhandled())
+			})
+			return nil, ErrMigrationTimeout
+		}
+	} else {
+		<-stopped
+	}
+
+	state := &tcpSessionState{
+		RecvSeq: client.RecvSeq(),
+		SendSeq: client.SendSeq(),
+		RecvKey: client.recvKey,
+		SendKey: client.sendKey,
+		RealIp:  client.realIp,
+		ProxyIp: client.proxyIp,
+		Pending: client.unhandled(),
+	}
+	if server.migrateOutHandler != nil {
+		data, err := server.migrateOutHandler(client)
+		if err != nil {
+			client.resume(state.Pending)
+			return nil, err
+		}
+		state.UserState = data
+	}
+	return state, nil
+}
+
+// restart loops stopped by freezeClient after migration failed, pending is the unhandled bytes
+func (client *TcpClient) resume(pending []byte) {
+	sendQsize := client.parent.SendQueueSize()
+	if sendQsize <= 0 {
+		sendQsize = DefaultSendQSize
+	}
+
+	client.Lock()
+	client.running = true
+	client.pending = pending
+	client.chSend = make(chan asyncMessage, sendQsize)
+	atomic.StoreInt32(&client.migrating, 0)
+	client.Unlock()
+
+	client.conn.SetReadDeadline(time.Time{})
+	client.start()
+}
+
+// close client handed off to successor, or whose hand-off is unknown
+func (client *TcpClient) closeMigrated() {
+	client.conn.Close()
+	client.onStopped()
+}
+
+// send client to successor over unix connection. the client is closed in current process after successor acked,
+// and resumed if it's known not taken by successor
+func (server *TcpServer) migrateClient(uc *net.UnixConn, client *TcpClient, deadline time.Time) error {
+	state, err := server.freezeClient(client, deadline)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		client.resume(state.Pending)
+		return err
+	}
+	if client.Conn == nil {
+		client.resume(state.Pending)
+		return ErrMigrationNoFd
+	}
+	f, err := client.Conn.File()
+	if err != nil {
+		client.resume(state.Pending)
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 4+len(data))
+	binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	if _, _, err = uc.WriteMsgUnix(buf, syscall.UnixRights(int(f.Fd())), nil); err != nil {
+		client.resume(state.Pending)
+		return err
+	}
+
+	ack := make([]byte, 1)
+	if _, err = io.ReadFull(uc, ack); err != nil {
+		// successor may have taken the connection, it can't be used by both processes
+		client.closeMigrated()
+		return err
+	}
+	if ack[0] != 0 {
+		client.resume(state.Pending)
+		return ErrMigrationRefused
+	}
+	client.closeMigrated()
+	return nil
+}
+
+// migrate all clients to successor process listening on unix socket addr by AcceptMigration, stop accepting
+// before migrating, timeout 0 means no limit. returns num of clients migrated. clients failed to migrate are kept
+// in current process, except those unknown whether taken by successor when the unix connection broken, which are
+// closed. migration stops at timeout or when the unix connection broken, clients left are kept too
+func (server *TcpServer) MigrateTo(addr string, timeout time.Duration) (int, error) {
+	server.Lock()
+	server.draining = true
+	server.Unlock()
+	if server.listener != nil {
+		server.listener.Close()
+	}
+
+	c, err := net.DialTimeout("unix", addr, timeout)
+	if err != nil {
+		return 0, err
+	}
+	uc := c.(*net.UnixConn)
+	defer uc.Close()
+
+	server.Lock()
+	clients := make([]*TcpClient, 0, len(server.clients))
+	for client := range server.clients {
+		clients = append(clients, client)
+	}
+	server.Unlock()
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		uc.SetDeadline(deadline)
+	}
+
+	migrated := 0
+	for _, client := range clients {
+		if timeout > 0 && time.Now().After(deadline) {
+			err = ErrMigrationTimeout
+			break
+		}
+		if e := server.migrateClient(uc, client, deadline); e != nil {
+			log.Debug("[TcpServer %s] migrate %v failed: %v", server.tag, client.Ip(), e)
+			if _, ok := e.(net.Error); ok || e == io.EOF || e == io.ErrUnexpectedEOF || e == ErrMigrationTimeout {
+				err = e
+				break
+			}
+			continue
+		}
+		migrated++
+	}
+	log.Info("[TcpServer %s] migrated %v/%v clients to %v", server.tag, migrated, len(clients), addr)
+	return migrated, err
+}
+
+// receive one client from predecessor
+func (server *TcpServer) recvClient(uc *net.UnixConn) error {
+	head := make([]byte, 4)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := uc.ReadMsgUnix(head, oob)
+	if err != nil {
+		return err
+	}
+	if n < len(head) {
+		if _, err = io.ReadFull(uc, head[n:]); err != nil {
+			return err
+		}
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(head))
+	if _, err = io.ReadFull(uc, data); err != nil {
+		return err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return ErrMigrationNoFd
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) == 0 {
+		return ErrMigrationNoFd
+	}
+	f := os.NewFile(uintptr(fds[0]), "migrated")
+	conn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	ack := func(err error) error {
+		if err != nil {
+			conn.Close()
+			uc.Write([]byte{1})
+			return err
+		}
+		_, err = uc.Write([]byte{0})
+		return err
+	}
+
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return ack(ErrMigrationNoFd)
+	}
+	state := &tcpSessionState{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return ack(err)
+	}
+
+	ip := state.RealIp
+	if ip == "" {
+		ip = addrIp(tc.RemoteAddr())
+	}
+	limitIp := ""
+	if server.ipFilter != nil && (state.ProxyIp || !server.ipFilter.IsTrustedProxy(ip)) {
+		if err = server.ipFilter.Acquire(ip); err != nil {
+			return ack(err)
+		}
+		limitIp = ip
+	}
+
+	client := server.CreateClient(tc, server.TcpEngin, server.NewCipher())
+	client.recvSeq = state.RecvSeq
+	client.sendSeq = state.SendSeq
+	client.recvKey = state.RecvKey
+	client.sendKey = state.SendKey
+	client.realIp = state.RealIp
+	client.proxyIp = state.ProxyIp
+	client.pending = state.Pending
+	server.setLimitIp(client, limitIp)
+
+	if server.migrateInHandler != nil {
+		if err = server.migrateInHandler(client, state.UserState); err != nil {
+			if limitIp != "" {
+				server.ipFilter.Release(limitIp)
+			}
+			return ack(err)
+		}
+	}
+
+	server.addClient(client)
+	client.start()
+	return ack(nil)
+}
+
+// accept clients migrated from predecessor process by MigrateTo, listening on unix socket addr
+// until the first predecessor connection finished
+func (server *TcpServer) AcceptMigration(addr string) error {
+	os.Remove(addr)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+	if err != nil {
+		return err
+	}
+
+	util.Go(func() {
+		defer os.Remove(addr)
+		defer l.Close()
+
+		uc, err := l.AcceptUnix()
+		if err != nil {
+			log.Debug("[TcpServer %s] accept migration failed: %v", server.tag, err)
+			return
+		}
+		defer uc.Close()
+
+		num := 0
+		for {
+			if err = server.recvClient(uc); err != nil {
+				if err != io.EOF {
+					log.Debug("[TcpServer %s] receive migrated client failed: %v", server.tag, err)
+				}
+				if _, ok := err.(net.Error); ok || err == io.EOF || err == ErrMigrationNoFd {
+					break
+				}
+				continue
+			}
+			num++
+		}
+		log.Info("[TcpServer %s] accepted %v migrated clients", server.tag, num)
+	})
+	return nil
+}
@@ -0,0 +1,210 @@
+//go:build !windows
+// +build !windows
+
+package net
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// free local addr
+func freeTcpAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestTcpSessionMigration(t *testing.T) {
+	addr := freeTcpAddr(t)
+
+	echo := func(tag string) func(client *TcpClient, msg IMessage) {
+		return func(client *TcpClient, msg IMessage) {
+			body := tag + ":" + client.UserData().(string) + ":" + string(msg.Body())
+			client.SendMsg(NewMessage(2, []byte(body)))
+		}
+	}
+
+	serverA := NewTcpServer("A")
+	serverA.Handle(1, echo("A"))
+	serverA.HandleNewClient(func(client *TcpClient) {
+		client.SetUserData("player")
+	})
+	serverA.HandleMigrate(func(client *TcpClient) ([]byte, error) {
+		return []byte(client.UserData().(string)), nil
+	}, nil)
+	go serverA.Start(addr)
+
+	serverB := NewTcpServer("B")
+	serverB.Handle(1, echo("B"))
+	serverB.HandleMigrate(nil, func(client *TcpClient, state []byte) error {
+		client.SetUserData(string(state))
+		return nil
+	})
+	go serverB.Start(freeTcpAddr(t))
+	defer serverB.Stop()
+	sock := filepath.Join(os.TempDir(), "kiss_migrate_test.sock")
+	if err := serverB.AcceptMigration(sock); err != nil {
+		t.Fatalf("AcceptMigration failed: %v", err)
+	}
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	recv := func() string {
+		conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+		head := make([]byte, DEFAULT_MESSAGE_HEAD_LEN)
+		if _, err := io.ReadFull(conn, head); err != nil {
+			t.Fatalf("read head failed: %v", err)
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(head))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			t.Fatalf("read body failed: %v", err)
+		}
+		return string(body)
+	}
+
+	conn.Write(NewMessage(1, []byte("hello")).Data())
+	if rsp := recv(); rsp != "A:player:hello" {
+		t.Fatalf("invalid response from A: %v", rsp)
+	}
+
+	// half of the frame is received by A, the rest by B
+	frame := NewMessage(1, []byte("world")).Data()
+	conn.Write(frame[:10])
+	time.Sleep(time.Millisecond * 100)
+
+	num, err := serverA.MigrateTo(sock, time.Second*3)
+	if err != nil || num != 1 {
+		t.Fatalf("MigrateTo failed: %v, %v", num, err)
+	}
+	if serverA.CurrLoad() != 0 || serverB.CurrLoad() != 1 {
+		t.Fatalf("invalid load after migration: %v, %v", serverA.CurrLoad(), serverB.CurrLoad())
+	}
+	serverA.Stop()
+
+	conn.Write(frame[10:])
+	if rsp := recv(); rsp != "B:player:world" {
+		t.Fatalf("invalid response from B: %v", rsp)
+	}
+
+	serverB.Lock()
+	for client := range serverB.clients {
+		if client.RecvSeq() != 2 || client.SendSeq() != 2 {
+			t.Fatalf("seq not continued: %v, %v", client.RecvSeq(), client.SendSeq())
+		}
+	}
+	serverB.Unlock()
+}
+
+func TestTcpSessionMigrationRefused(t *testing.T) {
+	addr := freeTcpAddr(t)
+	serverA := NewTcpServer("A")
+	serverA.Handle(1, func(client *TcpClient, msg IMessage) {
+		client.SendMsg(NewMessage(2, msg.Body()))
+	})
+	go serverA.Start(addr)
+	defer serverA.Stop()
+
+	serverB := NewTcpServer("B")
+	serverB.HandleMigrate(nil, func(client *TcpClient, state []byte) error {
+		return ErrMigrationRefused
+	})
+	sock := filepath.Join(os.TempDir(), "kiss_migrate_refused_test.sock")
+	if err := serverB.AcceptMigration(sock); err != nil {
+		t.Fatalf("AcceptMigration failed: %v", err)
+	}
+
+	var client *TcpClient
+	var err error
+	chEcho := make(chan string, 1)
+	engine := NewTcpEngine()
+	engine.Handle(2, func(client *TcpClient, msg IMessage) {
+		chEcho <- string(msg.Body())
+	})
+	for i := 0; i < 50; i++ {
+		if client, err = NewTcpClient(addr, engine, NewCipherGzip(DefaultThreshold), false, nil); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatalf("NewTcpClient failed: %v", err)
+	}
+	defer client.Stop()
+	for i := 0; i < 50 && serverA.CurrLoad() == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// no deadline with timeout 0, refused client is resumed in A
+	num, err := serverA.MigrateTo(sock, 0)
+	if err != nil || num != 0 {
+		t.Fatalf("MigrateTo should migrate no client: %v, %v", num, err)
+	}
+	if serverA.CurrLoad() != 1 {
+		t.Fatalf("refused client should be kept in A, load %v", serverA.CurrLoad())
+	}
+	client.SendMsg(NewMessage(1, []byte("still A")))
+	select {
+	case body := <-chEcho:
+		if body != "still A" {
+			t.Fatalf("invalid echo: %v", body)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("echo after refused migration timeout")
+	}
+}
+
+func TestTcpServerStopClients(t *testing.T) {
+	for _, broadcast := range []bool{false, true} {
+		addr := freeTcpAddr(t)
+		server := NewTcpServer("stop")
+		if broadcast {
+			server.EnableBroadcast()
+		}
+		go server.Start(addr)
+
+		var client *TcpClient
+		var err error
+		for i := 0; i < 50; i++ {
+			if client, err = NewTcpClient(addr, nil, nil, false, nil); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		if err != nil {
+			t.Fatalf("NewTcpClient failed: %v", err)
+		}
+		for i := 0; i < 100 && server.CurrLoad() != 1; i++ {
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		// clients are tracked by all servers for migration, but only closed by broadcast server on stop
+		server.Stop()
+		for i := 0; i < 20 && server.CurrLoad() != 0; i++ {
+			time.Sleep(time.Millisecond * 10)
+		}
+		if closed := server.CurrLoad() == 0; closed != broadcast {
+			t.Fatalf("broadcast %v: clients should be closed by stop: %v, got %v", broadcast, broadcast, closed)
+		}
+		client.Stop()
+	}
+}
@@ -0,0 +1,15 @@
+package net
+
+import (
+	"time"
+)
+
+// session migration is unsupported on windows
+func (server *TcpServer) MigrateTo(addr string, timeout time.Duration) (int, error) {
+	return 0, ErrMigrationUnsupported
+}
+
+// session migration is unsupported on windows
+func (server *TcpServer) AcceptMigration(addr string) error {
+	return ErrMigrationUnsupported
+}
@@ -0,0 +1,248 @@
+package net
+
+import (
+	"fmt"
+	"github.com/nothollyhigh/kiss/util"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// address of pipe connection
+type pipeAddr string
+
+// network
+func (addr pipeAddr) Network() string {
+	return "pipe"
+}
+
+// address
+func (addr pipeAddr) String() string {
+	return string(addr)
+}
+
+// fault injection of pipe connections, applied to messages written by both sides
+type PipeFaults struct {
+	sync.Mutex
+	latency  time.Duration
+	dropRate float64
+	dropNext int
+	rnd      *rand.Rand
+}
+
+// setting latency of each message
+func (faults *PipeFaults) SetLatency(latency time.Duration) {
+	faults.Lock()
+	faults.latency = latency
+	faults.Unlock()
+}
+
+// setting probability of each message dropped, 0-1
+func (faults *PipeFaults) SetDropRate(rate float64) {
+	faults.Lock()
+	faults.dropRate = rate
+	faults.Unlock()
+}
+
+// drop next n messages
+func (faults *PipeFaults) DropNext(n int) {
+	faults.Lock()
+	faults.dropNext = n
+	faults.Unlock()
+}
+
+// clear faults
+func (faults *PipeFaults) Reset() {
+	faults.Lock()
+	faults.latency = 0
+	faults.dropRate = 0
+	faults.dropNext = 0
+	faults.Unlock()
+}
+
+// faults of a message
+func (faults *PipeFaults) inject() (time.Duration, bool) {
+	faults.Lock()
+	defer faults.Unlock()
+	if faults.dropNext > 0 {
+		faults.dropNext--
+		return faults.latency, true
+	}
+	return faults.latency, faults.dropRate > 0 && faults.rnd.Float64() < faults.dropRate
+}
+
+// pipe connection, the engine writes a whole message each time, so faults are injected by message
+type pipeConn struct {
+	net.Conn
+	faults *PipeFaults
+	local  net.Addr
+	remote net.Addr
+}
+
+// write with faults
+func (conn *pipeConn) Write(b []byte) (int, error) {
+	latency, drop := conn.faults.inject()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if drop {
+		return len(b), nil
+	}
+	return conn.Conn.Write(b)
+}
+
+// local address
+func (conn *pipeConn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+// remote address
+func (conn *pipeConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+// in-memory server of tcp engine, clients are connected by pipes without sockets, for handler tests
+type PipeServer struct {
+	*TcpEngin
+
+	mtx      sync.Mutex
+	faults   *PipeFaults
+	sessions map[*TcpClient]struct{}
+	connId   int64
+	refuse   bool
+}
+
+// close handler tag of pipe server
+type pipeServerCloseTag struct{}
+
+// connect a new pipe, the server side session is started with the engine
+func (server *PipeServer) dial() (net.Conn, error) {
+	server.mtx.Lock()
+	if server.refuse {
+		server.mtx.Unlock()
+		return nil, ErrPipeServerRefused
+	}
+	server.connId++
+	remote := pipeAddr(fmt.Sprintf("pipe:%d", server.connId))
+	server.mtx.Unlock()
+
+	c1, c2 := net.Pipe()
+	local := pipeAddr("pipe:0")
+	session := createPipeClient(&pipeConn{Conn: c1, faults: server.faults, local: local, remote: remote}, server.TcpEngin, server.NewCipher())
+	session.OnClose(pipeServerCloseTag{}, func(session *TcpClient) {
+		server.mtx.Lock()
+		delete(server.sessions, session)
+		server.mtx.Unlock()
+	})
+	server.mtx.Lock()
+	server.sessions[session] = struct{}{}
+	server.mtx.Unlock()
+	server.OnNewClient(session)
+	session.start()
+
+	return &pipeConn{Conn: c2, faults: server.faults, local: remote, remote: local}, nil
+}
+
+// faults of connections
+func (server *PipeServer) Faults() *PipeFaults {
+	return server.faults
+}
+
+// server side sessions
+func (server *PipeServer) Sessions() []*TcpClient {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	sessions := make([]*TcpClient, 0, len(server.sessions))
+	for session := range server.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// setting refuse new connections, such as server down when testing reconnect
+func (server *PipeServer) SetRefuse(refuse bool) {
+	server.mtx.Lock()
+	server.refuse = refuse
+	server.mtx.Unlock()
+}
+
+// disconnect all sessions by server
+func (server *PipeServer) Disconnect() {
+	for _, session := range server.Sessions() {
+		session.Stop()
+	}
+}
+
+// refuse new connections, disconnect all sessions and wait for handlers done
+func (server *PipeServer) Close() {
+	server.SetRefuse(true)
+	server.Disconnect()
+	server.Wait()
+}
+
+// connect a tcp client by pipe, same as NewTcpClient
+func (server *PipeServer) NewClient(engine *TcpEngin, cipher ICipher, autoReconn bool, onConnected func(*TcpClient)) (*TcpClient, error) {
+	client, err := server.newClient(engine, cipher, autoReconn, onConnected)
+	if err != nil {
+		return nil, err
+	}
+	if onConnected != nil {
+		util.Go(func() {
+			onConnected(client)
+		})
+	}
+	return client, nil
+}
+
+// connect a tcp client by pipe
+func (server *PipeServer) newClient(engine *TcpEngin, cipher ICipher, autoReconn bool, onConnected func(*TcpClient)) (*TcpClient, error) {
+	conn, err := server.dial()
+	if err != nil {
+		return nil, err
+	}
+	client := createPipeClient(conn, engine, cipher)
+	client.start()
+	if autoReconn {
+		client.autoReconnect(conn.RemoteAddr().String(), server.dial, onConnected)
+	}
+	return client, nil
+}
+
+// connect a rpc client by pipe, same as NewRpcClient
+func (server *PipeServer) NewRpcClient(engine *TcpEngin, codec ICodec, onConnected func(*RpcClient)) (*RpcClient, error) {
+	return newRpcClient(engine, codec, onConnected, func(engine *TcpEngin, cipher ICipher, onConnected func(*TcpClient)) (*TcpClient, error) {
+		return server.newClient(engine, cipher, true, onConnected)
+	})
+}
+
+// create tcp client of pipe connection
+func createPipeClient(conn net.Conn, parent *TcpEngin, cipher ICipher) *TcpClient {
+	if parent == nil {
+		parent = NewTcpEngine()
+	}
+	sendQsize := parent.SendQueueSize()
+	if sendQsize <= 0 {
+		sendQsize = DefaultSendQSize
+	}
+	return &TcpClient{
+		conn:       conn,
+		parent:     parent,
+		cipher:     cipher,
+		chSend:     make(chan asyncMessage, sendQsize),
+		onCloseMap: map[interface{}]func(*TcpClient){},
+		running:    true,
+	}
+}
+
+// pipe server factory, a new engine is used if engine is nil
+func NewPipeServer(engine *TcpEngin) *PipeServer {
+	if engine == nil {
+		engine = NewTcpEngine()
+	}
+	return &PipeServer{
+		TcpEngin: engine,
+		faults:   &PipeFaults{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))},
+		sessions: map[*TcpClient]struct{}{},
+	}
+}
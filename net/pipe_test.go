@@ -0,0 +1,117 @@
+package net
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipeHarness(t *testing.T) {
+	const cmdEcho = uint32(4327)
+
+	disconnected := int64(0)
+	engine := NewTcpEngine()
+	engine.Handle(cmdEcho, func(client *TcpClient, msg IMessage) {
+		client.SendMsg(NewMessage(cmdEcho, msg.Body()))
+	})
+	engine.HandleDisconnected(func(client *TcpClient) {
+		atomic.AddInt64(&disconnected, 1)
+	})
+
+	h, err := NewPipeHarness(engine)
+	if err != nil {
+		t.Fatalf("NewPipeHarness failed: %v", err)
+	}
+	defer h.Close()
+
+	h.Send(NewMessage(cmdEcho, []byte("hi")))
+	msg, err := h.Expect(cmdEcho, time.Second)
+	if err != nil || string(msg.Body()) != "hi" {
+		t.Fatalf("Expect failed: %v", err)
+	}
+	if session := h.Session(); session == nil || session.Ip() != "pipe" {
+		t.Fatalf("invalid server session: %v", session)
+	}
+	if _, err = h.Expect(cmdEcho, time.Millisecond*50); err == nil {
+		t.Fatalf("Expect without message should fail")
+	}
+
+	// dropped request
+	h.Faults().DropNext(1)
+	h.Send(NewMessage(cmdEcho, []byte("lost")))
+	if err = h.ExpectNone(time.Millisecond * 100); err != nil {
+		t.Fatalf("dropped message should not be echoed: %v", err)
+	}
+
+	// latency of request and response
+	h.Faults().SetLatency(time.Millisecond * 50)
+	begin := time.Now()
+	h.Send(NewMessage(cmdEcho, []byte("slow")))
+	if _, err = h.Expect(cmdEcho, time.Second); err != nil {
+		t.Fatalf("Expect with latency failed: %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed < time.Millisecond*100 {
+		t.Fatalf("echo with latency should take 100ms at least, got %v", elapsed)
+	}
+	h.Faults().Reset()
+
+	// reconnect after disconnected by server
+	h.Disconnect()
+	if err = h.WaitReconnected(time.Second * 3); err != nil {
+		t.Fatalf("WaitReconnected failed: %v", err)
+	}
+	if n := atomic.LoadInt64(&disconnected); n != 1 {
+		t.Fatalf("disconnected should be 1, got %d", n)
+	}
+	h.Send(NewMessage(cmdEcho, []byte("again")))
+	if msg, err = h.Expect(cmdEcho, time.Second); err != nil || string(msg.Body()) != "again" {
+		t.Fatalf("Expect after reconnected failed: %v", err)
+	}
+
+	// no reconnect while server refuses
+	h.Server.SetRefuse(true)
+	h.Disconnect()
+	if err = h.WaitReconnected(time.Millisecond * 300); err == nil {
+		t.Fatalf("client should not reconnect while server refuses")
+	}
+	h.Server.SetRefuse(false)
+	if err = h.WaitReconnected(time.Second * 3); err != nil {
+		t.Fatalf("WaitReconnected failed: %v", err)
+	}
+}
+
+func TestPipeRpcTimeout(t *testing.T) {
+	engine := NewTcpEngine()
+	engine.HandleRpcMethod("Hello", func(ctx *RpcContext) {
+		name := ""
+		ctx.Bind(&name)
+		ctx.Write("hello " + name)
+	})
+	server := NewPipeServer(engine)
+	defer server.Close()
+
+	client, err := server.NewRpcClient(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewRpcClient failed: %v", err)
+	}
+	defer client.Shutdown()
+
+	rsp := ""
+	if err = client.Call("Hello", "kiss", &rsp, time.Second); err != nil || rsp != "hello kiss" {
+		t.Fatalf("Call failed: %v, %v", err, rsp)
+	}
+
+	server.Faults().DropNext(1)
+	if err = client.Call("Hello", "kiss", &rsp, time.Millisecond*100); err != ErrRpcCallTimeout {
+		t.Fatalf("Call with request dropped should timeout, got %v", err)
+	}
+
+	server.Faults().SetLatency(time.Millisecond * 100)
+	if err = client.Call("Hello", "kiss", &rsp, time.Millisecond*100); err != ErrRpcCallTimeout {
+		t.Fatalf("Call with latency should timeout, got %v", err)
+	}
+	server.Faults().Reset()
+	if err = client.Call("Hello", "pipe", &rsp, time.Second); err != nil || rsp != "hello pipe" {
+		t.Fatalf("Call after faults reset failed: %v, %v", err, rsp)
+	}
+}
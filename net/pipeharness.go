@@ -0,0 +1,119 @@
+package net
+
+import (
+	"fmt"
+	"time"
+)
+
+// max messages kept by harness
+const pipeHarnessRecvSize = 1024
+
+// test harness of a server engine and a connected client in process, messages received by the client are kept for
+// Recv and Expect. the client reconnects automatically after disconnected, faults of Server apply to both sides
+type PipeHarness struct {
+	Server *PipeServer
+	Client *TcpClient
+
+	recv      chan IMessage
+	connected chan struct{}
+}
+
+// send message by client
+func (h *PipeHarness) Send(msg IMessage) error {
+	return h.Client.SendMsg(msg)
+}
+
+// next message received by client
+func (h *PipeHarness) Recv(timeout time.Duration) (IMessage, error) {
+	after := time.NewTimer(timeout)
+	defer after.Stop()
+	select {
+	case msg := <-h.recv:
+		return msg, nil
+	case <-after.C:
+		return nil, ErrPipeRecvTimeout
+	}
+}
+
+// next message received by client, which should be of cmd
+func (h *PipeHarness) Expect(cmd uint32, timeout time.Duration) (IMessage, error) {
+	msg, err := h.Recv(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("expect cmd %d: %v", cmd, err)
+	}
+	if msg.Cmd() != cmd {
+		return msg, fmt.Errorf("expect cmd %d, got cmd %d", cmd, msg.Cmd())
+	}
+	return msg, nil
+}
+
+// no message received by client in duration
+func (h *PipeHarness) ExpectNone(d time.Duration) error {
+	if msg, err := h.Recv(d); err == nil {
+		return fmt.Errorf("expect no message, got cmd %d", msg.Cmd())
+	}
+	return nil
+}
+
+// server side session of client, nil if disconnected
+func (h *PipeHarness) Session() *TcpClient {
+	for _, session := range h.Server.Sessions() {
+		return session
+	}
+	return nil
+}
+
+// faults of connection
+func (h *PipeHarness) Faults() *PipeFaults {
+	return h.Server.Faults()
+}
+
+// disconnect client by server
+func (h *PipeHarness) Disconnect() {
+	h.Server.Disconnect()
+}
+
+// wait for client reconnected after disconnected
+func (h *PipeHarness) WaitReconnected(timeout time.Duration) error {
+	after := time.NewTimer(timeout)
+	defer after.Stop()
+	select {
+	case <-h.connected:
+		return nil
+	case <-after.C:
+		return fmt.Errorf("wait reconnected timeout")
+	}
+}
+
+// shutdown client and close server
+func (h *PipeHarness) Close() {
+	h.Client.Shutdown()
+	h.Server.Close()
+}
+
+// pipe harness factory, server is the engine under test and a new engine is used if nil
+func NewPipeHarness(server *TcpEngin) (*PipeHarness, error) {
+	h := &PipeHarness{
+		Server:    NewPipeServer(server),
+		recv:      make(chan IMessage, pipeHarnessRecvSize),
+		connected: make(chan struct{}, 1),
+	}
+	engine := NewTcpEngine()
+	engine.HandleMessage(func(client *TcpClient, msg IMessage) {
+		select {
+		case h.recv <- msg:
+		default:
+		}
+	})
+	client, err := h.Server.newClient(engine, nil, true, func(*TcpClient) {
+		select {
+		case h.connected <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.Client = client
+	return h, nil
+}
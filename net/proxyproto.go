@@ -0,0 +1,286 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// max length of proxy protocol v1 header, including CRLF
+	proxyV1MaxLen = 107
+
+	// proxy protocol v2 commands
+	proxyV2CmdLocal = 0x0
+	proxyV2CmdProxy = 0x1
+
+	// proxy protocol v2 address families
+	proxyV2FamUnspec = 0x0
+	proxyV2FamInet   = 0x1
+	proxyV2FamInet6  = 0x2
+)
+
+var (
+	proxyV1Sig = []byte("PROXY ")
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxy protocol header
+type ProxyHeader struct {
+	// 1 or 2
+	Version int
+	// LOCAL command of v2 or UNKNOWN of v1, addresses should be ignored
+	Local bool
+	// client address
+	SrcAddr net.Addr
+	// proxy address the client connected to
+	DstAddr net.Addr
+}
+
+// encode as v1 header
+func (h *ProxyHeader) EncodeV1() []byte {
+	src, _ := h.SrcAddr.(*net.TCPAddr)
+	dst, _ := h.DstAddr.(*net.TCPAddr)
+	if h.Local || src == nil || dst == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	proto := "TCP4"
+	if src.IP.To4() == nil {
+		proto = "TCP6"
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, src.IP.String(), dst.IP.String(), src.Port, dst.Port))
+}
+
+// encode as v2 header
+func (h *ProxyHeader) EncodeV2() []byte {
+	src, _ := h.SrcAddr.(*net.TCPAddr)
+	dst, _ := h.DstAddr.(*net.TCPAddr)
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(proxyV2Sig)
+	if h.Local || src == nil || dst == nil {
+		buf.Write([]byte{0x20 | proxyV2CmdLocal, proxyV2FamUnspec, 0, 0})
+		return buf.Bytes()
+	}
+
+	var addrs []byte
+	fam := byte(proxyV2FamInet)
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		addrs = append(addrs, src4...)
+		addrs = append(addrs, dst4...)
+	} else {
+		fam = proxyV2FamInet6
+		addrs = append(addrs, src.IP.To16()...)
+		addrs = append(addrs, dst.IP.To16()...)
+	}
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, uint16(src.Port))
+	binary.BigEndian.PutUint16(ports[2:], uint16(dst.Port))
+	addrs = append(addrs, ports...)
+
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(addrs)))
+
+	buf.Write([]byte{0x20 | proxyV2CmdProxy, fam<<4 | 0x1})
+	buf.Write(length)
+	buf.Write(addrs)
+
+	return buf.Bytes()
+}
+
+// read proxy protocol v1 or v2 header, bytes after the header are not consumed
+func ReadProxyHeader(r io.Reader) (*ProxyHeader, error) {
+	sig := make([]byte, len(proxyV2Sig))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Sig) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(sig, proxyV1Sig) {
+		return readProxyHeaderV1(r, sig)
+	}
+	return nil, ErrProxyHeaderInvalid
+}
+
+// read the rest of v1 header byte by byte until CRLF
+func readProxyHeaderV1(r io.Reader, head []byte) (*ProxyHeader, error) {
+	line := append([]byte{}, head...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLen {
+			return nil, ErrProxyHeaderInvalid
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 {
+		return nil, ErrProxyHeaderInvalid
+	}
+
+	hdr := &ProxyHeader{Version: 1}
+	switch fields[1] {
+	case "UNKNOWN":
+		hdr.Local = true
+		return hdr, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrProxyHeaderInvalid
+	}
+	if len(fields) != 6 {
+		return nil, ErrProxyHeaderInvalid
+	}
+
+	srcIp, dstIp := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIp == nil || dstIp == nil || err1 != nil || err2 != nil {
+		return nil, ErrProxyHeaderInvalid
+	}
+	hdr.SrcAddr = &net.TCPAddr{IP: srcIp, Port: int(srcPort)}
+	hdr.DstAddr = &net.TCPAddr{IP: dstIp, Port: int(dstPort)}
+
+	return hdr, nil
+}
+
+// read the rest of v2 header after signature
+func readProxyHeaderV2(r io.Reader) (*ProxyHeader, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[0]>>4 != 0x2 {
+		return nil, ErrProxyHeaderInvalid
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	hdr := &ProxyHeader{Version: 2}
+	switch head[0] & 0xF {
+	case proxyV2CmdLocal:
+		hdr.Local = true
+		return hdr, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, ErrProxyHeaderInvalid
+	}
+
+	// only tcp and udp over ipv4/ipv6 carry usable addresses
+	ipLen := 0
+	switch head[1] >> 4 {
+	case proxyV2FamInet:
+		ipLen = net.IPv4len
+	case proxyV2FamInet6:
+		ipLen = net.IPv6len
+	default:
+		hdr.Local = true
+		return hdr, nil
+	}
+	if len(data) < ipLen*2+4 {
+		return nil, ErrProxyHeaderInvalid
+	}
+
+	srcIp := net.IP(append([]byte{}, data[:ipLen]...))
+	dstIp := net.IP(append([]byte{}, data[ipLen:ipLen*2]...))
+	srcPort := binary.BigEndian.Uint16(data[ipLen*2:])
+	dstPort := binary.BigEndian.Uint16(data[ipLen*2+2:])
+	hdr.SrcAddr = &net.TCPAddr{IP: srcIp, Port: int(srcPort)}
+	hdr.DstAddr = &net.TCPAddr{IP: dstIp, Port: int(dstPort)}
+
+	return hdr, nil
+}
+
+// proxy protocol settings
+type proxyProtocol struct {
+	// only connections from trusted proxies are parsed, and they must send the header
+	trusted IpNets
+	// header read timeout
+	timeout time.Duration
+}
+
+// read header from conn of trusted proxies, returns nil header for untrusted
+func (p *proxyProtocol) readHeader(conn net.Conn) (*ProxyHeader, error) {
+	if !p.trusted.Contains(addrIp(conn.RemoteAddr())) {
+		return nil, nil
+	}
+	if p.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(p.timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	return ReadProxyHeader(conn)
+}
+
+// new proxy protocol settings
+func newProxyProtocol(trustedProxies []string) (*proxyProtocol, error) {
+	trusted, err := ParseIpNets(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocol{
+		trusted: trusted,
+		timeout: DefaultProxyHeaderTimeout,
+	}, nil
+}
+
+// conn accepted by Listener, the header is parsed on first Read or RemoteAddr
+type proxyConn struct {
+	net.Conn
+	proxy      *proxyProtocol
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+// parse header once
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		hdr, err := c.proxy.readHeader(c.Conn)
+		if err != nil {
+			c.err = err
+			c.Conn.Close()
+			return
+		}
+		if hdr != nil && !hdr.Local {
+			c.remoteAddr = hdr.SrcAddr
+		}
+	})
+}
+
+// read
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
+
+// client address from the header, or the remote address if no header
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// close write
+func (c *proxyConn) CloseWrite() error {
+	if tc, ok := c.Conn.(*net.TCPConn); ok {
+		return tc.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,84 @@
+package net
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5678}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5678}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+
+	for _, hdr := range []*ProxyHeader{
+		&ProxyHeader{SrcAddr: src, DstAddr: dst},
+		&ProxyHeader{SrcAddr: src6, DstAddr: dst6},
+	} {
+		for version, data := range [][]byte{hdr.EncodeV1(), hdr.EncodeV2()} {
+			r := bytes.NewReader(append(data, "payload"...))
+			got, err := ReadProxyHeader(r)
+			if err != nil {
+				t.Fatalf("ReadProxyHeader v%v failed: %v", version+1, err)
+			}
+			if got.Version != version+1 || got.Local || got.SrcAddr.String() != hdr.SrcAddr.String() || got.DstAddr.String() != hdr.DstAddr.String() {
+				t.Fatalf("ReadProxyHeader v%v mismatch: %+v", version+1, got)
+			}
+			rest, _ := ioutil.ReadAll(r)
+			if string(rest) != "payload" {
+				t.Fatalf("ReadProxyHeader v%v consumed payload: %q", version+1, rest)
+			}
+		}
+	}
+
+	local := &ProxyHeader{Local: true}
+	for _, data := range [][]byte{local.EncodeV1(), local.EncodeV2()} {
+		got, err := ReadProxyHeader(bytes.NewReader(data))
+		if err != nil || !got.Local {
+			t.Fatalf("ReadProxyHeader local failed: %v, %+v", err, got)
+		}
+	}
+
+	if _, err := ReadProxyHeader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n\r\n"))); err != ErrProxyHeaderInvalid {
+		t.Fatalf("ReadProxyHeader should fail: %v", err)
+	}
+}
+
+func TestListenerProxyProtocol(t *testing.T) {
+	ln, err := NewListener("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer ln.Close()
+	if err = ln.(*Listener).EnableProxyProtocol([]string{"127.0.0.1"}); err != nil {
+		t.Fatalf("EnableProxyProtocol failed: %v", err)
+	}
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		hdr := &ProxyHeader{
+			SrcAddr: &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5678},
+			DstAddr: conn.RemoteAddr(),
+		}
+		conn.Write(append(hdr.EncodeV2(), "hello"...))
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer conn.Close()
+	if ip := addrIp(conn.RemoteAddr()); ip != "1.2.3.4" {
+		t.Fatalf("RemoteAddr should be 1.2.3.4, got %v", ip)
+	}
+	buf := make([]byte, 5)
+	if _, err = conn.Read(buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("Read failed: %v, %q", err, buf)
+	}
+}
@@ -0,0 +1,242 @@
+package net
+
+import (
+	"github.com/nothollyhigh/kiss/rate"
+	"github.com/nothollyhigh/kiss/util"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rate limit action
+type RateLimitAction int
+
+const (
+	// drop the message
+	RateLimitDrop RateLimitAction = iota
+	// delay the message on the read loop until a token is available, drop it if the wait exceeds MaxDelay
+	RateLimitDelay
+	// drop the message and disconnect the client
+	RateLimitDisconnect
+	// drop the message and call the limited handler
+	RateLimitCallback
+)
+
+// rate limit rule
+type RateLimitRule struct {
+	// tokens per second
+	Rate float64
+	// bucket size
+	Burst int
+	// action when limited
+	Action RateLimitAction
+	// max delay for RateLimitDelay
+	MaxDelay time.Duration
+}
+
+// rate limit stats
+type RateLimitStats struct {
+	Passed       int64
+	Dropped      int64
+	Delayed      int64
+	Disconnected int64
+	Callbacked   int64
+}
+
+// rate limit of a scope
+type rateLimit struct {
+	rule    RateLimitRule
+	limiter *rate.Limiter
+}
+
+// bucket key of per connection per cmd scope
+type rateCmdKey struct {
+	sess interface{}
+	cmd  uint32
+}
+
+// bucket key of engine scope
+type rateEngineKey struct{}
+
+// inbound message rate limiter, checks scopes in order: cmd, connection, ip, engine
+type RateLimiter struct {
+	sync.RWMutex
+
+	// all messages of the engines using this limiter
+	engine *rateLimit
+
+	// messages of each connection
+	conn *rateLimit
+
+	// messages of each ip
+	ip *rateLimit
+
+	// messages of each connection by cmd
+	cmds map[uint32]*rateLimit
+
+	// limited handler for RateLimitCallback
+	limitedHandler func(sess interface{}, msg IMessage)
+
+	// counters
+	stats      RateLimitStats
+	cmdLimited map[uint32]int64
+}
+
+// new rate limit by rule
+func newRateLimit(rule *RateLimitRule) *rateLimit {
+	if rule == nil {
+		return nil
+	}
+	return &rateLimit{
+		rule:    *rule,
+		limiter: rate.NewLimiter(rule.Rate, rule.Burst),
+	}
+}
+
+// setting limit for all messages of the engines, nil to disable
+func (l *RateLimiter) SetEngineLimit(rule *RateLimitRule) {
+	l.Lock()
+	l.engine = newRateLimit(rule)
+	l.Unlock()
+}
+
+// setting limit for messages of each connection, nil to disable
+func (l *RateLimiter) SetConnLimit(rule *RateLimitRule) {
+	l.Lock()
+	l.conn = newRateLimit(rule)
+	l.Unlock()
+}
+
+// setting limit for messages of each ip, nil to disable
+func (l *RateLimiter) SetIpLimit(rule *RateLimitRule) {
+	l.Lock()
+	l.ip = newRateLimit(rule)
+	l.Unlock()
+}
+
+// setting limit for messages of each connection by cmd, nil to disable
+func (l *RateLimiter) SetCmdLimit(cmd uint32, rule *RateLimitRule) {
+	l.Lock()
+	if rule == nil {
+		delete(l.cmds, cmd)
+	} else {
+		l.cmds[cmd] = newRateLimit(rule)
+	}
+	l.Unlock()
+}
+
+// setting limited handler for RateLimitCallback
+func (l *RateLimiter) HandleLimited(h func(sess interface{}, msg IMessage)) {
+	l.Lock()
+	l.limitedHandler = h
+	l.Unlock()
+}
+
+// on message limited
+func (l *RateLimiter) onLimited(sess interface{}, msg IMessage, action RateLimitAction) {
+	l.Lock()
+	l.cmdLimited[msg.Cmd()]++
+	h := l.limitedHandler
+	l.Unlock()
+
+	switch action {
+	case RateLimitDisconnect:
+		atomic.AddInt64(&l.stats.Disconnected, 1)
+	case RateLimitCallback:
+		atomic.AddInt64(&l.stats.Callbacked, 1)
+		if h != nil {
+			util.Safe(func() {
+				h(sess, msg)
+			})
+		}
+	default:
+		atomic.AddInt64(&l.stats.Dropped, 1)
+	}
+}
+
+// check message before dispatching, returns false and the action if the message is limited
+func (l *RateLimiter) Check(sess interface{}, ip string, msg IMessage) (bool, RateLimitAction) {
+	cmd := msg.Cmd()
+
+	l.RLock()
+	limits := [...]*rateLimit{l.cmds[cmd], l.conn, l.ip, l.engine}
+	l.RUnlock()
+
+	for i, lim := range limits {
+		if lim == nil {
+			continue
+		}
+
+		var key interface{}
+		switch i {
+		case 0:
+			key = rateCmdKey{sess, cmd}
+		case 1:
+			key = sess
+		case 2:
+			key = ip
+		default:
+			key = rateEngineKey{}
+		}
+
+		if lim.rule.Action == RateLimitDelay {
+			wait, ok := lim.limiter.Reserve(key, lim.rule.MaxDelay)
+			if !ok {
+				l.onLimited(sess, msg, RateLimitDrop)
+				return false, RateLimitDrop
+			}
+			if wait > 0 {
+				atomic.AddInt64(&l.stats.Delayed, 1)
+				time.Sleep(wait)
+			}
+			continue
+		}
+
+		if !lim.limiter.Allow(key) {
+			l.onLimited(sess, msg, lim.rule.Action)
+			return false, lim.rule.Action
+		}
+	}
+
+	atomic.AddInt64(&l.stats.Passed, 1)
+
+	return true, RateLimitDrop
+}
+
+// remove buckets of a disconnected client
+func (l *RateLimiter) Remove(sess interface{}) {
+	l.RLock()
+	defer l.RUnlock()
+	if l.conn != nil {
+		l.conn.limiter.Remove(sess)
+	}
+	for cmd, lim := range l.cmds {
+		lim.limiter.Remove(rateCmdKey{sess, cmd})
+	}
+}
+
+// stats
+func (l *RateLimiter) Stats() RateLimitStats {
+	return RateLimitStats{
+		Passed:       atomic.LoadInt64(&l.stats.Passed),
+		Dropped:      atomic.LoadInt64(&l.stats.Dropped),
+		Delayed:      atomic.LoadInt64(&l.stats.Delayed),
+		Disconnected: atomic.LoadInt64(&l.stats.Disconnected),
+		Callbacked:   atomic.LoadInt64(&l.stats.Callbacked),
+	}
+}
+
+// limited message num by cmd
+func (l *RateLimiter) CmdLimited(cmd uint32) int64 {
+	l.RLock()
+	defer l.RUnlock()
+	return l.cmdLimited[cmd]
+}
+
+// rate limiter factory
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		cmds:       map[uint32]*rateLimit{},
+		cmdLimited: map[uint32]int64{},
+	}
+}
@@ -0,0 +1,57 @@
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter()
+	limiter.SetCmdLimit(1, &RateLimitRule{Rate: 1, Burst: 2, Action: RateLimitDrop})
+	limiter.SetConnLimit(&RateLimitRule{Rate: 1, Burst: 3, Action: RateLimitDisconnect})
+
+	limited := 0
+	limiter.HandleLimited(func(sess interface{}, msg IMessage) {
+		limited++
+	})
+
+	msg1, msg2 := NewMessage(1, nil), NewMessage(2, nil)
+	if ok, _ := limiter.Check("c1", "127.0.0.1", msg1); !ok {
+		t.Fatalf("RateLimiter should pass msg1")
+	}
+	if ok, _ := limiter.Check("c1", "127.0.0.1", msg1); !ok {
+		t.Fatalf("RateLimiter should pass msg1 burst")
+	}
+	if ok, action := limiter.Check("c1", "127.0.0.1", msg1); ok || action != RateLimitDrop {
+		t.Fatalf("RateLimiter should drop msg1, got %v, %v", ok, action)
+	}
+	if ok, _ := limiter.Check("c1", "127.0.0.1", msg2); !ok {
+		t.Fatalf("RateLimiter should pass msg2")
+	}
+	if ok, action := limiter.Check("c1", "127.0.0.1", msg2); ok || action != RateLimitDisconnect {
+		t.Fatalf("RateLimiter should disconnect on msg2, got %v, %v", ok, action)
+	}
+	if ok, _ := limiter.Check("c2", "127.0.0.1", msg1); !ok {
+		t.Fatalf("RateLimiter should pass other connection")
+	}
+
+	limiter.SetIpLimit(&RateLimitRule{Rate: 1000, Burst: 1, Action: RateLimitDelay, MaxDelay: time.Second})
+	limiter.Check("c3", "127.0.0.2", msg2)
+	if ok, _ := limiter.Check("c3", "127.0.0.2", msg2); !ok {
+		t.Fatalf("RateLimiter should delay and pass msg2")
+	}
+
+	limiter.SetEngineLimit(&RateLimitRule{Rate: 1, Burst: 1, Action: RateLimitCallback})
+	limiter.Check("c4", "127.0.0.3", msg2)
+	if ok, _ := limiter.Check("c5", "127.0.0.4", msg2); ok || limited != 1 {
+		t.Fatalf("RateLimiter should callback, got %v, %v", ok, limited)
+	}
+
+	stats := limiter.Stats()
+	if stats.Dropped != 1 || stats.Disconnected != 1 || stats.Delayed != 1 || stats.Callbacked != 1 {
+		t.Fatalf("RateLimiter invalid stats: %+v", stats)
+	}
+	if limiter.CmdLimited(1) != 1 || limiter.CmdLimited(2) != 2 {
+		t.Fatalf("RateLimiter invalid cmd limited: %v, %v", limiter.CmdLimited(1), limiter.CmdLimited(2))
+	}
+}
@@ -0,0 +1,56 @@
+package net
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ip of a forwarding header entry, such as "1.2.3.4", "1.2.3.4:80", "[::1]:80"
+func forwardedIp(v string) string {
+	v = strings.TrimSpace(v)
+	if ip := net.ParseIP(v); ip != nil {
+		return ip.String()
+	}
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// client ip of http request, forwarding headers are only used when the request comes
+// from trusted proxies. X-Forwarded-For is walked from right to left and the first
+// untrusted ip is the client ip, X-Real-IP is used if there's no X-Forwarded-For.
+func RealIpFromRequest(r *http.Request, trustedProxies IpNets) string {
+	remoteIp := hostIp(r.RemoteAddr)
+	if !trustedProxies.Contains(remoteIp) {
+		return remoteIp
+	}
+
+	var ips []string
+	for _, line := range r.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		ips = append(ips, strings.Split(line, ",")...)
+	}
+	if len(ips) > 0 {
+		realIp := remoteIp
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := forwardedIp(ips[i])
+			if ip == "" {
+				break
+			}
+			realIp = ip
+			if !trustedProxies.Contains(ip) {
+				break
+			}
+		}
+		return realIp
+	}
+
+	if ip := forwardedIp(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+
+	return remoteIp
+}
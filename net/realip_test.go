@@ -0,0 +1,42 @@
+package net
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRealIpFromRequest(t *testing.T) {
+	trusted, err := ParseIpNets([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseIpNets failed: %v", err)
+	}
+
+	cases := []struct {
+		remote string
+		xff    []string
+		xri    string
+		want   string
+	}{
+		{"1.1.1.1:100", []string{"2.2.2.2"}, "", "1.1.1.1"},
+		{"10.0.0.1:100", nil, "", "10.0.0.1"},
+		{"10.0.0.1:100", []string{"3.3.3.3, 2.2.2.2, 10.0.0.2"}, "", "2.2.2.2"},
+		{"10.0.0.1:100", []string{"3.3.3.3", "2.2.2.2"}, "4.4.4.4", "2.2.2.2"},
+		{"10.0.0.1:100", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"10.0.0.1:100", []string{"bad, 10.0.0.2"}, "", "10.0.0.2"},
+		{"10.0.0.1:100", []string{"[2001:db8::1]:80"}, "", "2001:db8::1"},
+		{"10.0.0.1:100", nil, "4.4.4.4", "4.4.4.4"},
+	}
+
+	for i, c := range cases {
+		r := &http.Request{RemoteAddr: c.remote, Header: http.Header{}}
+		for _, v := range c.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if c.xri != "" {
+			r.Header.Set("X-Real-IP", c.xri)
+		}
+		if ip := RealIpFromRequest(r, trusted); ip != c.want {
+			t.Fatalf("case %v: RealIpFromRequest should be %v, got %v", i, c.want, ip)
+		}
+	}
+}
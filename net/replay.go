@@ -0,0 +1,131 @@
+package net
+
+import (
+	"sync"
+	"time"
+)
+
+// replay options
+type ReplayOpt struct {
+	// speed relative to captured timing, such as 2 for double speed, 0 replays without delay
+	Speed float64
+
+	// cipher of replay connections, gzip cipher with DefaultThreshold is used if nil
+	NewCipher func() ICipher
+
+	// handler of messages received by replay connections, connId is the captured connection id
+	OnMessage func(connId uint64, msg IMessage)
+
+	// wait for responses after all records replayed, before stopping connections not closed in capture
+	Wait time.Duration
+}
+
+// connection replaying a captured connection
+type replayConn struct {
+	client *TcpClient
+	sent   sync.WaitGroup
+}
+
+// wait for sent messages written until send block time, then stop
+func (conn *replayConn) stop() {
+	done := make(chan struct{})
+	go func() {
+		conn.sent.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(conn.client.parent.SockSendBlockTime()):
+	}
+	conn.client.Stop()
+}
+
+// replay inbound messages of capture records to tcp server at addr. each captured connection is replayed
+// by a new connection, outbound records are skipped. records are sent in captured order by the calling
+// goroutine, so a replay is deterministic as long as the server handles messages of a connection in order
+func Replay(addr string, records []*CaptureRecord, opt *ReplayOpt) error {
+	if opt == nil {
+		opt = &ReplayOpt{}
+	}
+	newCipher := opt.NewCipher
+	if newCipher == nil {
+		newCipher = func() ICipher {
+			return NewCipherGzip(DefaultThreshold)
+		}
+	}
+
+	conns := map[uint64]*replayConn{}
+	defer func() {
+		if len(conns) > 0 && opt.Wait > 0 {
+			time.Sleep(opt.Wait)
+		}
+		for _, conn := range conns {
+			conn.stop()
+		}
+	}()
+
+	open := func(connId uint64) (*replayConn, error) {
+		if conn, ok := conns[connId]; ok {
+			return conn, nil
+		}
+		// messages may be pushed once connected, so connId is bound to the engine of each connection
+		engine := NewTcpEngine()
+		engine.HandleMessage(func(client *TcpClient, msg IMessage) {
+			if opt.OnMessage != nil {
+				opt.OnMessage(connId, msg)
+			}
+		})
+		client, err := NewTcpClient(addr, engine, newCipher(), false, nil)
+		if err != nil {
+			return nil, err
+		}
+		conn := &replayConn{client: client}
+		conns[connId] = conn
+		return conn, nil
+	}
+
+	var prev time.Time
+	for _, rec := range records {
+		if opt.Speed > 0 && !prev.IsZero() && rec.Time.After(prev) {
+			time.Sleep(time.Duration(float64(rec.Time.Sub(prev)) / opt.Speed))
+		}
+		prev = rec.Time
+
+		switch rec.Direction {
+		case CaptureOpen:
+			if _, err := open(rec.ConnId); err != nil {
+				return err
+			}
+		case CaptureIn:
+			conn, err := open(rec.ConnId)
+			if err != nil {
+				return err
+			}
+			msg := NewMessage(rec.Msg.Cmd(), append([]byte{}, rec.Msg.Body()...))
+			msg.SetExt(rec.Msg.Ext())
+			conn.sent.Add(1)
+			err = conn.client.SendMsgWithCallback(msg, func(*TcpClient, error) {
+				conn.sent.Done()
+			})
+			if err != nil {
+				conn.sent.Done()
+				return err
+			}
+		case CaptureClose:
+			if conn, ok := conns[rec.ConnId]; ok {
+				delete(conns, rec.ConnId)
+				conn.stop()
+			}
+		}
+	}
+	return nil
+}
+
+// replay capture file to tcp server at addr, see Replay
+func ReplayFile(addr string, path string, opt *ReplayOpt) error {
+	records, err := ReadCaptureFile(path)
+	if err != nil {
+		return err
+	}
+	return Replay(addr, records, opt)
+}
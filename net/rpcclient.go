@@ -3,11 +3,27 @@ package net
 import (
 	"errors"
 	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/trace"
 	"github.com/nothollyhigh/kiss/util"
 	"sync/atomic"
 	"time"
 )
 
+var (
+	_ IRpcClient = (*RpcClient)(nil)
+	_ IRpcClient = (*WSRpcClient)(nil)
+)
+
+// rpc client of tcp or websocket, implemented by RpcClient and WSRpcClient
+type IRpcClient interface {
+	// send message which is not a rpc call
+	SendMsg(msg IMessage) error
+	// rpc call of method
+	Call(method string, req interface{}, rsp interface{}, timeout time.Duration) error
+	// setting handler of messages which are not rpc responses
+	HandleNotify(h func(msg IMessage))
+}
+
 // rpc message
 type RpcMessage struct {
 	msg IMessage
@@ -25,6 +41,7 @@ type RpcClient struct {
 	*TcpClient
 	sessionMap map[int64]*rpcsession
 	codec      ICodec
+	notify     func(msg IMessage)
 }
 
 // remove rpc session
@@ -40,6 +57,7 @@ func (client *RpcClient) removeSession(seq int64) {
 // call cmd
 func (client *RpcClient) callCmd(cmd uint32, data []byte) ([]byte, error) {
 	var session *rpcsession
+	var req IMessage
 	client.Lock()
 	if client.running {
 		session = &rpcsession{
@@ -47,6 +65,7 @@ func (client *RpcClient) callCmd(cmd uint32, data []byte) ([]byte, error) {
 			done: make(chan *RpcMessage, 1),
 		}
 		msg := NewRpcMessage(cmd, session.seq, data)
+		req = captureCopy(client.TcpClient, msg)
 		// client.chSend <- asyncMessage{msg.data, nil}
 		client.chSend <- asyncMessage{msg.Encrypt(client.SendSeq(), client.SendKey(), client.cipher), nil}
 		client.sessionMap[session.seq] = session
@@ -55,6 +74,7 @@ func (client *RpcClient) callCmd(cmd uint32, data []byte) ([]byte, error) {
 		return nil, ErrRpcClientIsDisconnected
 	}
 	client.Unlock()
+	captureSent(client.TcpClient, req)
 	defer client.removeSession(session.seq)
 	msg, ok := <-session.done
 	if !ok {
@@ -81,6 +101,7 @@ func (client *RpcClient) callCmdWithTimeout(cmd uint32, data []byte, timeout tim
 		done: make(chan *RpcMessage, 1),
 	}
 	msg := NewRpcMessage(cmd, session.seq, data)
+	captured := captureCopy(client.TcpClient, msg)
 	select {
 	//case client.chSend <- asyncMessage{msg.data, nil}:
 	case client.chSend <- asyncMessage{msg.Encrypt(client.SendSeq(), client.SendKey(), client.cipher), nil}:
@@ -91,6 +112,7 @@ func (client *RpcClient) callCmdWithTimeout(cmd uint32, data []byte, timeout tim
 	}
 
 	client.Unlock()
+	captureSent(client.TcpClient, captured)
 	defer client.removeSession(session.seq)
 	select {
 	case msg, ok := <-session.done:
@@ -117,6 +139,7 @@ func (client *RpcClient) callCmdWithTimer(cmd uint32, data []byte, after *time.T
 		done: make(chan *RpcMessage, 1),
 	}
 	msg := NewRpcMessage(cmd, session.seq, data)
+	captured := captureCopy(client.TcpClient, msg)
 	select {
 	//case client.chSend <- asyncMessage{msg.data, nil}:
 	case client.chSend <- asyncMessage{msg.Encrypt(client.SendSeq(), client.SendKey(), client.cipher), nil}:
@@ -127,6 +150,7 @@ func (client *RpcClient) callCmdWithTimer(cmd uint32, data []byte, after *time.T
 	}
 
 	client.Unlock()
+	captureSent(client.TcpClient, captured)
 	defer client.removeSession(session.seq)
 	select {
 	case msg, ok := <-session.done:
@@ -145,13 +169,22 @@ func (client *RpcClient) Codec() ICodec {
 	return client.codec
 }
 
+// setting handler of messages which are not rpc responses and have no handler, such as server notifications
+func (client *RpcClient) HandleNotify(h func(msg IMessage)) {
+	client.Lock()
+	client.notify = h
+	client.Unlock()
+}
+
 // call cmd
 func (client *RpcClient) CallCmd(cmd uint32, req interface{}, rsp interface{}) error {
 	data, err := client.codec.Marshal(req)
 	if err != nil {
 		return err
 	}
+	span, cmd, data := traceRpcCall(metricProtoTcp, cmd, "", data, nil)
 	rspdata, err := client.callCmd(cmd, data)
+	traceRpcDone(span, err)
 	if err != nil {
 		return err
 	}
@@ -167,7 +200,9 @@ func (client *RpcClient) CallCmdWithTimeout(cmd uint32, req interface{}, rsp int
 	if err != nil {
 		return err
 	}
+	span, cmd, data := traceRpcCall(metricProtoTcp, cmd, "", data, nil)
 	rspdata, err := client.callCmdWithTimeout(cmd, data, timeout)
+	traceRpcDone(span, err)
 	if err != nil {
 		return err
 	}
@@ -179,14 +214,22 @@ func (client *RpcClient) CallCmdWithTimeout(cmd uint32, req interface{}, rsp int
 
 // rpc call
 func (client *RpcClient) Call(method string, req interface{}, rsp interface{}, timeout time.Duration) error {
+	return client.CallWithParent(nil, method, req, rsp, timeout)
+}
+
+// rpc call in a child span of parent, such as RpcContext.Span of the handler, which is traced without
+// goroutine local spans and can be called from any goroutine. same as Call if parent is nil
+func (client *RpcClient) CallWithParent(parent *trace.Span, method string, req interface{}, rsp interface{}, timeout time.Duration) error {
 	data, err := client.codec.Marshal(req)
 	if err != nil {
 		return err
 	}
-	data = append(data, make([]byte, len(method)+1)...)
-	copy(data[len(data)-len(method)-1:], method)
-	data[len(data)-1] = byte(len(method))
-	rspdata, err := client.callCmdWithTimeout(CmdRpcMethod, data, timeout)
+	data = appendRpcMethod(data, method)
+	span, cmd, data := traceRpcCall(metricProtoTcp, CmdRpcMethod, method, data, parent)
+	begin := time.Now()
+	rspdata, err := client.callCmdWithTimeout(cmd, data, timeout)
+	metricRpcCall(method, begin, err)
+	traceRpcDone(span, err)
 	if err != nil {
 		return err
 	}
@@ -202,10 +245,12 @@ func (client *RpcClient) CallWithTimer(method string, req interface{}, rsp inter
 	if err != nil {
 		return err
 	}
-	data = append(data, make([]byte, len(method)+1)...)
-	copy(data[len(data)-len(method)-1:], method)
-	data[len(data)-1] = byte(len(method))
-	rspdata, err := client.callCmdWithTimer(CmdRpcMethod, data, after)
+	data = appendRpcMethod(data, method)
+	span, cmd, data := traceRpcCall(metricProtoTcp, CmdRpcMethod, method, data, nil)
+	begin := time.Now()
+	rspdata, err := client.callCmdWithTimer(cmd, data, after)
+	metricRpcCall(method, begin, err)
+	traceRpcDone(span, err)
 	if err != nil {
 		return err
 	}
@@ -274,6 +319,13 @@ func (client *RpcClient) CallWithTimer(method string, req interface{}, rsp inter
 
 // rpc client factory
 func NewRpcClient(addr string, engine *TcpEngin, codec ICodec, onConnected func(*RpcClient)) (*RpcClient, error) {
+	return newRpcClient(engine, codec, onConnected, func(engine *TcpEngin, cipher ICipher, onConnected func(*TcpClient)) (*TcpClient, error) {
+		return newTcpClient(addr, engine, cipher, true, onConnected)
+	})
+}
+
+// rpc client factory, the auto reconnect tcp client is created by connect
+func newRpcClient(engine *TcpEngin, codec ICodec, onConnected func(*RpcClient), connect func(engine *TcpEngin, cipher ICipher, onConnected func(*TcpClient)) (*TcpClient, error)) (*RpcClient, error) {
 	if engine == nil {
 		engine = NewTcpEngine()
 		engine.SetSendQueueSize(DefaultSockRpcSendQSize)
@@ -296,7 +348,7 @@ func NewRpcClient(addr string, engine *TcpEngin, codec ICodec, onConnected func(
 		cipher = NewCipherGzip(DefaultThreshold)
 	}
 
-	rpcclient.TcpClient, err = newTcpClient(addr, engine, cipher, true, func(c *TcpClient) {
+	rpcclient.TcpClient, err = connect(engine, cipher, func(c *TcpClient) {
 		if onConnected != nil {
 			onConnected(rpcclient)
 		}
@@ -352,6 +404,13 @@ func NewRpcClient(addr string, engine *TcpEngin, codec ICodec, onConnected func(
 				defer engine.Done()
 				defer util.HandlePanic()
 				handler(c, msg)
+				return
+			}
+			rpcclient.Lock()
+			notify := rpcclient.notify
+			rpcclient.Unlock()
+			if notify != nil {
+				notify(msg)
 			} else {
 				log.Debug("no handler for cmd %v", msg.Cmd())
 			}
@@ -5,6 +5,7 @@ import (
 	"encoding/gob"
 	"errors"
 	"github.com/golang/protobuf/proto"
+	"github.com/nothollyhigh/kiss/trace"
 	"github.com/vmihailenco/msgpack"
 )
 
@@ -16,14 +17,21 @@ var (
 type RpcContext struct {
 	method  string
 	client  *TcpClient
+	sess    ISession
 	message IMessage
+	span    *trace.Span
 }
 
-// tcp client
+// tcp client, nil if the session is not a tcp client
 func (ctx *RpcContext) Client() *TcpClient {
 	return ctx.client
 }
 
+// session
+func (ctx *RpcContext) Session() ISession {
+	return ctx.sess
+}
+
 // cmd
 func (ctx *RpcContext) Cmd() uint32 {
 	return ctx.message.Cmd()
@@ -39,12 +47,41 @@ func (ctx *RpcContext) Method() string {
 	return ctx.method
 }
 
+// server span of the call, nil if tracing is not enabled
+func (ctx *RpcContext) Span() *trace.Span {
+	return ctx.span
+}
+
+// call handler in server span, which is a child of the span context carried by the rpc frame
+func (ctx *RpcContext) call(h func(ctx *RpcContext)) {
+	var parent trace.SpanContext
+	if msg, ok := ctx.message.(*Message); ok {
+		parent = msg.trace
+	}
+	ctx.span = trace.StartSpanWithParent(traceSpanName(ctx.message.Cmd(), ctx.method), trace.KindServer, parent)
+	if ctx.span != nil {
+		ctx.span.SetAttribute("peer", ctx.sess.Ip())
+		defer ctx.span.Finish()
+		defer trace.Activate(ctx.span)()
+	}
+	h(ctx)
+}
+
+// encrypt and push response
+func (ctx *RpcContext) push(msg IMessage) error {
+	captured := captureCopy(ctx.sess, msg)
+	data := msg.Encrypt(ctx.sess.SendSeq(), ctx.sess.SendKey(), ctx.sess.Cipher())
+	err := ctx.sess.pushDataSync(data)
+	if err == nil {
+		captureSent(ctx.sess, captured)
+	}
+	return err
+}
+
 // write data
 func (ctx *RpcContext) WriteData(data []byte) error {
-	//case client.chSend <- asyncMessage{msg.Encrypt(client.SendSeq(), client.SendKey(), client.cipher), nil}:
 	msg := NewRpcMessage(ctx.message.Cmd(), ctx.message.Ext(), data)
-	data = msg.Encrypt(ctx.client.SendSeq(), ctx.client.SendKey(), ctx.client.cipher)
-	return ctx.client.pushDataSync(data)
+	return ctx.push(msg)
 }
 
 // write message
@@ -52,8 +89,7 @@ func (ctx *RpcContext) WriteMsg(msg IMessage) error {
 	if ctx.message != msg {
 		msg.SetExt(ctx.message.Ext())
 	}
-	data := msg.Encrypt(ctx.client.SendSeq(), ctx.client.SendKey(), ctx.client.cipher)
-	return ctx.client.pushDataSync(data)
+	return ctx.push(msg)
 }
 
 // bind data
@@ -68,8 +104,7 @@ func (ctx *RpcContext) Write(v interface{}) error {
 		return err
 	}
 	msg := NewRpcMessage(ctx.message.Cmd(), ctx.message.Ext(), data)
-	data = msg.Encrypt(ctx.client.SendSeq(), ctx.client.SendKey(), ctx.client.cipher)
-	return ctx.client.pushDataSync(data)
+	return ctx.push(msg)
 }
 
 // bind json
@@ -84,8 +119,7 @@ func (ctx *RpcContext) WriteJson(v interface{}) error {
 		return err
 	}
 	msg := NewRpcMessage(ctx.message.Cmd(), ctx.message.Ext(), data)
-	data = msg.Encrypt(ctx.client.SendSeq(), ctx.client.SendKey(), ctx.client.cipher)
-	return ctx.client.pushDataSync(data)
+	return ctx.push(msg)
 }
 
 // bind gob data
@@ -101,8 +135,7 @@ func (ctx *RpcContext) WriteGob(v interface{}) error {
 		return err
 	}
 	msg := NewRpcMessage(ctx.message.Cmd(), ctx.message.Ext(), buffer.Bytes())
-	data := msg.Encrypt(ctx.client.SendSeq(), ctx.client.SendKey(), ctx.client.cipher)
-	return ctx.client.pushDataSync(data)
+	return ctx.push(msg)
 }
 
 // bind msgpack data
@@ -117,8 +150,7 @@ func (ctx *RpcContext) WriteMsgpack(v interface{}) error {
 		return err
 	}
 	msg := NewRpcMessage(ctx.message.Cmd(), ctx.message.Ext(), data)
-	data = msg.Encrypt(ctx.client.SendSeq(), ctx.client.SendKey(), ctx.client.cipher)
-	return ctx.client.pushDataSync(data)
+	return ctx.push(msg)
 }
 
 // bind protobuf data
@@ -133,13 +165,23 @@ func (ctx *RpcContext) WriteProtobuf(v proto.Message) error {
 		return err
 	}
 	msg := NewRpcMessage(ctx.message.Cmd(), ctx.message.Ext(), data)
-	data = msg.Encrypt(ctx.client.SendSeq(), ctx.client.SendKey(), ctx.client.cipher)
-	return ctx.client.pushDataSync(data)
+	return ctx.push(msg)
 }
 
 // write error
 func (ctx *RpcContext) Error(errText string) error {
+	if ctx.method != "" {
+		metricRpcServerErrors.With(ctx.method).Inc()
+	} else {
+		metricRpcServerErrors.With(metricCmdLabel(ctx.message.Cmd(), true)).Inc()
+	}
+	ctx.span.SetError(errText)
 	msg := NewRpcMessage(CmdRpcError, ctx.message.Ext(), []byte(errText))
-	data := msg.Encrypt(ctx.client.SendSeq(), ctx.client.SendKey(), ctx.client.cipher)
-	return ctx.client.pushDataSync(data)
+	return ctx.push(msg)
+}
+
+// rpc context factory
+func newRpcContext(method string, sess ISession, msg IMessage) *RpcContext {
+	client, _ := sess.(*TcpClient)
+	return &RpcContext{method: method, client: client, sess: sess, message: msg}
 }
@@ -0,0 +1,51 @@
+package net
+
+var (
+	_ ISession = (*TcpClient)(nil)
+	_ ISession = (*WSClient)(nil)
+)
+
+// transport agnostic session, implemented by TcpClient and WSClient
+type ISession interface {
+	// ip
+	Ip() string
+	// port
+	Port() int
+	// setting real ip
+	SetRealIp(ip string)
+
+	// cipher
+	Cipher() ICipher
+	// setting cipher
+	SetCipher(cipher ICipher)
+	// receive sequence
+	RecvSeq() int64
+	// send sequence
+	SendSeq() int64
+	// receive key
+	RecvKey() uint32
+	// send key
+	SendKey() uint32
+
+	// user data
+	UserData() interface{}
+	// setting user data
+	SetUserData(data interface{})
+	// bind data by engine codec
+	Bind(data []byte, v interface{}) error
+
+	// send message
+	SendMsg(msg IMessage) error
+	// send encrypted data
+	SendData(data []byte) error
+
+	// setting close handler
+	OnSessionClose(tag interface{}, cb func(sess ISession))
+	// unsetting close handler
+	CancelOnClose(tag interface{})
+	// close
+	Close() error
+
+	// push data sync, using for rpc
+	pushDataSync(data []byte) error
+}
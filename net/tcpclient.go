@@ -2,6 +2,7 @@ package net
 
 import (
 	"bufio"
+	"bytes"
 	"github.com/nothollyhigh/kiss/log"
 	"github.com/nothollyhigh/kiss/util"
 	"io"
@@ -17,9 +18,12 @@ import (
 type TcpClient struct {
 	sync.RWMutex
 
-	// tcp connection
+	// tcp connection, nil for in-memory pipe client
 	Conn *net.TCPConn
 
+	// connection for io, the tcp connection or an in-memory pipe
+	conn net.Conn
+
 	// bufio Reader
 	reader io.Reader
 
@@ -53,11 +57,29 @@ type TcpClient struct {
 	// real ip
 	realIp string
 
+	// ip counted by ip filter
+	limitIp string
+
+	// real ip from proxy protocol header, can't be reset
+	proxyIp bool
+
 	// running flag
 	running bool
 
 	// shutdown flag
 	shutdown bool
+
+	// read and write loops
+	loopWg sync.WaitGroup
+
+	// migrating to successor process, the connection is kept open when loops stopped
+	migrating int32
+
+	// received bytes not handled yet: partial frame interrupted by migration, or carried from predecessor
+	pending []byte
+
+	// unread part of pending
+	pendingReader *bytes.Reader
 }
 
 // ip
@@ -65,8 +87,8 @@ func (client *TcpClient) Ip() string {
 	if client.realIp != "" {
 		return client.realIp
 	}
-	if client.Conn != nil {
-		addr := client.Conn.RemoteAddr().String()
+	if client.conn != nil {
+		addr := client.conn.RemoteAddr().String()
 		if pos := strings.LastIndex(addr, ":"); pos > 0 {
 			return addr[:pos]
 		}
@@ -76,8 +98,8 @@ func (client *TcpClient) Ip() string {
 
 // port
 func (client *TcpClient) Port() int {
-	if client.Conn != nil {
-		addr := client.Conn.RemoteAddr().String()
+	if client.conn != nil {
+		addr := client.conn.RemoteAddr().String()
 		if pos := strings.LastIndex(addr, ":"); pos > 0 {
 			if port, err := strconv.Atoi(addr[pos+1:]); err == nil {
 				return port
@@ -92,11 +114,14 @@ func (client *TcpClient) Reader() io.Reader {
 	if client.reader != nil {
 		return client.reader
 	}
-	return client.Conn
+	return client.conn
 }
 
 // set real ip
 func (client *TcpClient) SetRealIp(ip string) {
+	if client.proxyIp {
+		return
+	}
 	if client.realIp == "" {
 		client.realIp = ip
 	} else if DefaultEnableMultiSetRealIp {
@@ -130,14 +155,27 @@ func (client *TcpClient) CancelOnClose(tag interface{}) {
 	client.Unlock()
 }
 
+// setting close handler for ISession
+func (client *TcpClient) OnSessionClose(tag interface{}, cb func(sess ISession)) {
+	client.OnClose(tag, func(c *TcpClient) {
+		cb(c)
+	})
+}
+
 // send message
 func (client *TcpClient) SendMsg(msg IMessage) error {
 	var err error = nil
 	client.Lock()
 	if client.running {
+		// cmd may be flagged in place by Encrypt, such as gzip
+		label := metricCmdLabel(msg.Cmd(), true)
+		captured := client.parent.capture.copy(msg)
+		data := msg.Encrypt(client.SendSeq(), client.SendKey(), client.cipher)
 		select {
-		case client.chSend <- asyncMessage{msg.Encrypt(client.SendSeq(), client.SendKey(), client.cipher), nil}:
+		case client.chSend <- asyncMessage{data, nil}:
 			client.Unlock()
+			metricSent(metricProtoTcp, label, len(data))
+			client.parent.capture.record(client, metricProtoTcp, CaptureOut, captured)
 		default:
 			client.Unlock()
 			client.parent.OnSendQueueFull(client, msg)
@@ -159,9 +197,15 @@ func (client *TcpClient) SendMsgWithCallback(msg IMessage, cb func(*TcpClient, e
 	var err error = nil
 	client.Lock()
 	if client.running {
+		// cmd may be flagged in place by Encrypt, such as gzip
+		label := metricCmdLabel(msg.Cmd(), true)
+		captured := client.parent.capture.copy(msg)
+		data := msg.Encrypt(client.SendSeq(), client.SendKey(), client.cipher)
 		select {
-		case client.chSend <- asyncMessage{msg.Encrypt(client.SendSeq(), client.SendKey(), client.cipher), cb}:
+		case client.chSend <- asyncMessage{data, cb}:
 			client.Unlock()
+			metricSent(metricProtoTcp, label, len(data))
+			client.parent.capture.record(client, metricProtoTcp, CaptureOut, captured)
 		default:
 			client.Unlock()
 			client.parent.OnSendQueueFull(client, msg)
@@ -186,6 +230,7 @@ func (client *TcpClient) SendData(data []byte) error {
 		select {
 		case client.chSend <- asyncMessage{data, nil}:
 			client.Unlock()
+			metricSent(metricProtoTcp, metricCmdRaw, len(data))
 		default:
 			client.Unlock()
 			client.parent.OnSendQueueFull(client, data)
@@ -210,6 +255,7 @@ func (client *TcpClient) SendDataWithCallback(data []byte, cb func(*TcpClient, e
 		select {
 		case client.chSend <- asyncMessage{data, cb}:
 			client.Unlock()
+			metricSent(metricProtoTcp, metricCmdRaw, len(data))
 		default:
 			client.Unlock()
 			client.parent.OnSendQueueFull(client, data)
@@ -249,6 +295,7 @@ func (client *TcpClient) pushDataSync(data []byte) error {
 		select {
 		case client.chSend <- asyncMessage{data, nil}:
 			client.Unlock()
+			metricSent(metricProtoTcp, metricCmdRaw, len(data))
 		case <-after.C:
 			client.Unlock()
 			err = ErrRpcCallTimeout
@@ -306,6 +353,7 @@ func (client *TcpClient) SetUserData(data interface{}) {
 
 // client start
 func (client *TcpClient) start() {
+	client.loopWg.Add(2)
 	util.Go(client.readloop)
 	util.Go(client.writeloop)
 }
@@ -330,13 +378,14 @@ func (client *TcpClient) Keepalive(interval time.Duration) {
 }
 
 // restart for auto reconnect
-func (client *TcpClient) restart(conn *net.TCPConn) {
+func (client *TcpClient) restart(conn net.Conn) {
 	client.Lock()
 	defer client.Unlock()
 	if !client.running {
 		client.running = true
 
-		client.Conn = conn
+		client.Conn, _ = conn.(*net.TCPConn)
+		client.conn = conn
 		if client.cipher != nil {
 			client.cipher.Init()
 		}
@@ -346,6 +395,7 @@ func (client *TcpClient) restart(conn *net.TCPConn) {
 		}
 		client.chSend = make(chan asyncMessage, sendQsize)
 
+		client.loopWg.Add(2)
 		util.Go(client.writeloop)
 		util.Go(client.readloop)
 	}
@@ -361,17 +411,60 @@ func (client *TcpClient) stop() {
 
 	close(client.chSend)
 
-	client.Conn.CloseRead()
-	client.Conn.CloseWrite()
-	client.Conn.Close()
+	if client.Migrating() {
+		// connection is handed off and close handlers are called by migration
+		return
+	}
+
+	if client.Conn != nil {
+		client.Conn.CloseRead()
+		client.Conn.CloseWrite()
+	}
+	client.conn.Close()
 
+	client.onStopped()
+}
+
+// call close handlers
+func (client *TcpClient) onStopped() {
 	for _, cb := range client.onCloseMap {
 		cb(client)
 	}
 
+	if client.parent.rateLimiter != nil {
+		client.parent.rateLimiter.Remove(client)
+	}
+
 	client.parent.OnDisconnected(client)
 }
 
+// whether client is migrating or migrated to successor process
+func (client *TcpClient) Migrating() bool {
+	return atomic.LoadInt32(&client.migrating) != 0
+}
+
+// save bytes of frame interrupted by migration
+func (client *TcpClient) savePartial(data []byte) {
+	if client.Migrating() {
+		client.pending = append(client.pending[:0:0], data...)
+	}
+}
+
+// bytes received but not handled, after loops stopped for migration
+func (client *TcpClient) unhandled() []byte {
+	data := client.pending
+	if r, ok := client.reader.(*bufio.Reader); ok && r.Buffered() > 0 {
+		buffered, _ := r.Peek(r.Buffered())
+		data = append(data, buffered...)
+	}
+	if client.pendingReader != nil && client.pendingReader.Len() > 0 {
+		left := make([]byte, client.pendingReader.Len())
+		client.pendingReader.Read(left)
+		data = append(data, left...)
+	}
+	return data
+}
+
 // Stop
 func (client *TcpClient) Stop() error {
 	defer util.HandlePanic()
@@ -388,10 +481,18 @@ func (client *TcpClient) Stop() error {
 			return client.Conn.CloseWrite()
 			//return client.Conn.Close()
 		}
+		if client.conn != nil {
+			return client.conn.Close()
+		}
 	}
 	return ErrTcpClientIsStopped
 }
 
+// close for ISession
+func (client *TcpClient) Close() error {
+	return client.Stop()
+}
+
 // shutdown for auto reconnect client
 func (client *TcpClient) Shutdown() error {
 	client.Lock()
@@ -413,10 +514,13 @@ func (client *TcpClient) Shutdown() error {
 // }
 
 func (client *TcpClient) writeloop() {
+	defer client.loopWg.Done()
 	defer client.Stop()
 
 	var err error = nil
+	depth := metricSendQueueDepth.With(metricProtoTcp)
 	for asyncMsg := range client.chSend {
+		depth.Observe(float64(len(client.chSend)))
 		// err = client.send(&asyncMsg)
 		err = client.parent.Send(client, asyncMsg.data)
 		if asyncMsg.cb != nil {
@@ -431,11 +535,21 @@ func (client *TcpClient) writeloop() {
 
 // read loop
 func (client *TcpClient) readloop() {
+	defer client.loopWg.Done()
 	defer client.stop()
 	var imsg IMessage
 
+	var reader io.Reader = client.conn
+	client.pendingReader = nil
+	if len(client.pending) > 0 {
+		client.pendingReader = bytes.NewReader(client.pending)
+		client.pending = nil
+		reader = io.MultiReader(client.pendingReader, client.conn)
+	}
 	if client.parent.SockBufioReaderEnabled() && client.parent.SockRecvBufLen() > 0 {
-		client.reader = bufio.NewReaderSize(client.Conn, client.parent.SockRecvBufLen())
+		client.reader = bufio.NewReaderSize(reader, client.parent.SockRecvBufLen())
+	} else if client.pendingReader != nil {
+		client.reader = reader
 	} else {
 		client.reader = nil
 	}
@@ -469,6 +583,7 @@ func createTcpClient(conn *net.TCPConn, parent *TcpEngin, cipher ICipher) *TcpCl
 
 	client := &TcpClient{
 		Conn:       conn,
+		conn:       conn,
 		parent:     parent,
 		cipher:     cipher,
 		chSend:     make(chan asyncMessage, sendQsize),
@@ -484,11 +599,49 @@ func createTcpClient(conn *net.TCPConn, parent *TcpEngin, cipher ICipher) *TcpCl
 	return client
 }
 
+// reconnect by dial when closed, until shutdown
+func (client *TcpClient) autoReconnect(addr string, dial func() (net.Conn, error), onConnected func(*TcpClient)) {
+	client.OnClose("reconn", func(*TcpClient) {
+		util.Go(func() {
+			times := 0
+			tempDelay := time.Second / 10
+			for !client.shutdown {
+				times++
+				time.Sleep(tempDelay)
+				if conn, err := dial(); err == nil {
+					client.Lock()
+					defer client.Unlock()
+					if !client.shutdown {
+						log.Debug("TcpClient auto reconnect to %v %d success", addr, times)
+						client.recvSeq = 0
+						client.sendSeq = 0
+						util.Go(func() {
+							client.restart(conn)
+							if onConnected != nil {
+								onConnected(client)
+							}
+						})
+					} else {
+						conn.Close()
+					}
+					return
+				} else {
+					log.Debug("TcpClient auto reconnect to %v %d failed: %v", addr, times, err)
+				}
+				tempDelay *= 2
+				if tempDelay > time.Second*2 {
+					tempDelay = time.Second * 2
+				}
+			}
+		})
+	})
+}
+
 // tcp client factory
 func newTcpClient(addr string, parent *TcpEngin, cipher ICipher, autoReconn bool, onConnected func(*TcpClient)) (*TcpClient, error) {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
-		log.Debug("NewTcpClient failed: ", err)
+		log.Debug("NewTcpClient failed: %v", err)
 		return nil, err
 	}
 	conn, err := net.DialTCP("tcp", nil, tcpAddr)
@@ -501,40 +654,13 @@ func newTcpClient(addr string, parent *TcpEngin, cipher ICipher, autoReconn bool
 	client.start()
 
 	if autoReconn {
-		client.OnClose("reconn", func(*TcpClient) {
-			util.Go(func() {
-				times := 0
-				tempDelay := time.Second / 10
-				for !client.shutdown {
-					times++
-					time.Sleep(tempDelay)
-					if conn, err := net.DialTCP("tcp", nil, tcpAddr); err == nil {
-						client.Lock()
-						defer client.Unlock()
-						if !client.shutdown {
-							log.Debug("TcpClient auto reconnect to %v %d success", addr, times)
-							client.recvSeq = 0
-							client.sendSeq = 0
-							util.Go(func() {
-								client.restart(conn)
-								if onConnected != nil {
-									onConnected(client)
-								}
-							})
-						} else {
-							conn.Close()
-						}
-						return
-					} else {
-						log.Debug("TcpClient auto reconnect to %v %d failed: %v", addr, times, err)
-					}
-					tempDelay *= 2
-					if tempDelay > time.Second*2 {
-						tempDelay = time.Second * 2
-					}
-				}
-			})
-		})
+		client.autoReconnect(addr, func() (net.Conn, error) {
+			conn, err := net.DialTCP("tcp", nil, tcpAddr)
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		}, onConnected)
 	}
 
 	return client, nil
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,8 +26,17 @@ type TcpEngin struct {
 	// rpc method(string) handlers
 	rpcMethodHandlers map[string]func(*RpcContext)
 
-	// running flag
-	running bool
+	// handler dispatcher
+	dispatcher *Dispatcher
+
+	// inbound message rate limiter
+	rateLimiter *RateLimiter
+
+	// capture of decrypted messages
+	capture *Capture
+
+	// running flag, accessed atomically since read by accepting and reading goroutines
+	running int32
 
 	// codec
 	Codec ICodec
@@ -204,14 +214,20 @@ func (engine *TcpEngin) DefaultRecvMsg(client *TcpClient) IMessage {
 		dataLen: 0,
 	}
 
-	if pkt.err = client.Conn.SetReadDeadline(time.Now().Add(engine.sockRecvBlockTime)); pkt.err != nil {
-		log.Debug("%s RecvMsg SetReadDeadline Err: %v.", client.Conn.RemoteAddr().String(), pkt.err)
+	if pkt.err = client.conn.SetReadDeadline(time.Now().Add(engine.sockRecvBlockTime)); pkt.err != nil {
+		log.Debug("%s RecvMsg SetReadDeadline Err: %v.", client.conn.RemoteAddr().String(), pkt.err)
+		goto Exit
+	}
+
+	// migration sets flag before read deadline
+	if client.Migrating() {
 		goto Exit
 	}
 
 	pkt.readLen, pkt.err = io.ReadFull(client.Reader(), pkt.msg.data)
 	if pkt.err != nil || pkt.readLen < DEFAULT_MESSAGE_HEAD_LEN {
-		log.Debug("%s RecvMsg Read Head Err: %v, readLen: %d.", client.Conn.RemoteAddr().String(), pkt.err, pkt.readLen)
+		client.savePartial(pkt.msg.data[:pkt.readLen])
+		log.Debug("%s RecvMsg Read Head Err: %v, readLen: %d.", client.conn.RemoteAddr().String(), pkt.err, pkt.readLen)
 		goto Exit
 	}
 
@@ -219,14 +235,15 @@ func (engine *TcpEngin) DefaultRecvMsg(client *TcpClient) IMessage {
 
 	if pkt.dataLen > 0 {
 		if pkt.dataLen+DEFAULT_MESSAGE_HEAD_LEN > engine.sockMaxPackLen {
-			log.Debug("%s RecvMsg Read Body Err: Msg Len(%d) > MAXPACK_LEN(%d)", client.Conn.RemoteAddr().String(), pkt.dataLen+DEFAULT_MESSAGE_HEAD_LEN, engine.sockMaxPackLen)
+			log.Debug("%s RecvMsg Read Body Err: Msg Len(%d) > MAXPACK_LEN(%d)", client.conn.RemoteAddr().String(), pkt.dataLen+DEFAULT_MESSAGE_HEAD_LEN, engine.sockMaxPackLen)
 			goto Exit
 		}
 
 		pkt.msg.data = append(pkt.msg.data, make([]byte, pkt.dataLen)...)
 		pkt.readLen, pkt.err = io.ReadFull(client.Reader(), pkt.msg.data[DEFAULT_MESSAGE_HEAD_LEN:])
 		if pkt.err != nil {
-			log.Debug("%s RecvMsg Read Body Err: %v", client.Conn.RemoteAddr().String(), pkt.err)
+			client.savePartial(pkt.msg.data[:DEFAULT_MESSAGE_HEAD_LEN+pkt.readLen])
+			log.Debug("%s RecvMsg Read Body Err: %v", client.conn.RemoteAddr().String(), pkt.err)
 			goto Exit
 		}
 
@@ -235,7 +252,7 @@ func (engine *TcpEngin) DefaultRecvMsg(client *TcpClient) IMessage {
 	pkt.msg.rawData = pkt.msg.data
 	pkt.msg.data = nil
 	if _, pkt.err = pkt.msg.Decrypt(client.RecvSeq(), client.RecvKey(), client.Cipher()); pkt.err != nil {
-		log.Debug("%s RecvMsg Decrypt Err: %v", client.Conn.RemoteAddr().String(), pkt.err)
+		log.Debug("%s RecvMsg Decrypt Err: %v", client.conn.RemoteAddr().String(), pkt.err)
 		goto Exit
 	}
 
@@ -261,6 +278,7 @@ func (engine *TcpEngin) HandleRecv(recver func(client *TcpClient) IMessage) {
 
 // on tcp client send queue full
 func (engine *TcpEngin) OnSendQueueFull(client *TcpClient, msg interface{}) {
+	metricSendQueueDropped.With(metricProtoTcp).Inc()
 	if engine.SendQueueFullHandler != nil {
 		engine.SendQueueFullHandler(client, msg)
 	}
@@ -273,21 +291,21 @@ func (engine *TcpEngin) HandleSendQueueFull(h func(*TcpClient, interface{})) {
 
 // tcp client send data
 func (engine *TcpEngin) DefaultSend(client *TcpClient, data []byte) error {
-	err := client.Conn.SetWriteDeadline(time.Now().Add(engine.sockSendBlockTime))
+	err := client.conn.SetWriteDeadline(time.Now().Add(engine.sockSendBlockTime))
 	if err != nil {
-		log.Debug("%s Send SetReadDeadline Err: %v", client.Conn.RemoteAddr().String(), err)
+		log.Debug("%s Send SetReadDeadline Err: %v", client.conn.RemoteAddr().String(), err)
 		client.Stop()
 		return err
 	}
 
-	nwrite, err := client.Conn.Write(data)
+	nwrite, err := client.conn.Write(data)
 	if err != nil {
-		log.Debug("%s Send Write Err: %v", client.Conn.RemoteAddr().String(), err)
+		log.Debug("%s Send Write Err: %v", client.conn.RemoteAddr().String(), err)
 		client.Stop()
 		return err
 	}
 	if nwrite != len(data) {
-		log.Debug("%s Send Write Half", client.Conn.RemoteAddr().String())
+		log.Debug("%s Send Write Half", client.conn.RemoteAddr().String())
 		client.Stop()
 		return ErrTcpClientWriteHalf
 	}
@@ -321,17 +339,51 @@ func (engine *TcpEngin) DefaultOnMessage(client *TcpClient, msg IMessage) {
 	}
 
 	if handler, ok := engine.handlers[cmd]; ok {
-		engine.Add(1)
-		defer engine.Done()
-		defer util.HandlePanic()
-		handler(client, msg)
+		engine.dispatch(cmd, client, msg, func() {
+			handler(client, msg)
+		})
 	} else {
 		log.Debug("no handler for cmd %v", cmd)
 	}
 }
 
+// is running
+func (engine *TcpEngin) isRunning() bool {
+	return atomic.LoadInt32(&engine.running) != 0
+}
+
+// setting running flag, returns previous flag
+func (engine *TcpEngin) setRunning(running bool) bool {
+	v := int32(0)
+	if running {
+		v = 1
+	}
+	return atomic.SwapInt32(&engine.running, v) != 0
+}
+
+// dispatch handler by cmd or rpc method
+func (engine *TcpEngin) dispatch(tag interface{}, client *TcpClient, msg IMessage, h func()) {
+	engine.Add(1)
+	task := func() {
+		defer engine.Done()
+		defer util.HandlePanic()
+		defer metricHandlerDuration.With(metricProtoTcp, metricHandlerLabel(tag)).Since(time.Now())
+		h()
+	}
+
+	if engine.dispatcher == nil {
+		task()
+		return
+	}
+
+	if err := engine.dispatcher.Dispatch(tag, client, msg, task); err != nil {
+		engine.Done()
+		log.Debug("dispatch %v failed: %v, ip: %v", tag, err, client.Ip())
+	}
+}
+
 func (engine *TcpEngin) OnMessage(client *TcpClient, msg IMessage) {
-	if !engine.running {
+	if !engine.isRunning() {
 		// switch msg.Cmd() {
 		// case CmdPing:
 		// case CmdSetReaIp:
@@ -344,6 +396,21 @@ func (engine *TcpEngin) OnMessage(client *TcpClient, msg IMessage) {
 		return
 	}
 
+	traceExtract(msg)
+
+	_, handled := engine.handlers[msg.Cmd()]
+	metricRecv(metricProtoTcp, metricCmdLabel(msg.Cmd(), handled), msg)
+	engine.capture.record(client, metricProtoTcp, CaptureIn, msg)
+
+	if engine.rateLimiter != nil {
+		if ok, action := engine.rateLimiter.Check(client, client.Ip(), msg); !ok {
+			if action == RateLimitDisconnect {
+				client.Stop()
+			}
+			return
+		}
+	}
+
 	if engine.OnMsgHandler != nil {
 		engine.OnMsgHandler(client, msg)
 		return
@@ -404,12 +471,12 @@ func (engine *TcpEngin) HandleRpcCmd(cmd uint32, handler func(ctx *RpcContext),
 	if async {
 		engine.handlers[cmd] = func(client *TcpClient, msg IMessage) {
 			util.Go(func() {
-				handler(&RpcContext{client: client, message: msg})
+				newRpcContext("", client, msg).call(handler)
 			})
 		}
 	} else {
 		engine.handlers[cmd] = func(client *TcpClient, msg IMessage) {
-			handler(&RpcContext{client: client, message: msg})
+			newRpcContext("", client, msg).call(handler)
 		}
 	}
 }
@@ -417,25 +484,21 @@ func (engine *TcpEngin) HandleRpcCmd(cmd uint32, handler func(ctx *RpcContext),
 // on rpc method call
 func (engine *TcpEngin) onRpcMethod(client *TcpClient, imsg IMessage) {
 	msg := imsg.(*Message)
-	data := msg.Body()
-	if len(data) < 2 {
-		client.SendMsg(NewRpcMessage(CmdRpcError, msg.Ext(), []byte("invalid rpc payload")))
-		return
-	}
-	methodLen := int(data[len(data)-1])
-	if methodLen <= 0 || methodLen >= 128 || len(data)-1 < methodLen {
-		client.SendMsg(NewRpcMessage(CmdRpcError, msg.Ext(), []byte(fmt.Sprintf("invalid rpc method length %d, should be (1-127)", methodLen))))
+	method, err := rpcMethodOf(msg)
+	if err != nil {
+		client.SendMsg(NewRpcMessage(CmdRpcError, msg.Ext(), []byte(err.Error())))
 		return
 	}
-	method := string(data[(len(data) - 1 - methodLen):(len(data) - 1)])
 	handler, ok := engine.rpcMethodHandlers[method]
 	if !ok {
 		client.SendMsg(NewRpcMessage(CmdRpcError, msg.Ext(), []byte(fmt.Sprintf("invalid rpc method %s", method))))
 		return
 	}
-	// rawmsg := msg.(IMessage)
-	msg.data = msg.data[:(len(msg.data) - 1 - methodLen)]
-	handler(&RpcContext{method: method, client: client, message: msg})
+	trimRpcMethod(msg, method)
+	ctx := newRpcContext(method, client, msg)
+	engine.dispatch(method, client, msg, func() {
+		handler(ctx)
+	})
 }
 
 // init rpc handler
@@ -463,16 +526,67 @@ func (engine *TcpEngin) HandleRpcMethod(method string, handler func(ctx *RpcCont
 	if async {
 		engine.rpcMethodHandlers[method] = func(ctx *RpcContext) {
 			util.Go(func() {
-				handler(ctx)
+				ctx.call(handler)
 			})
 		}
 	} else {
-		engine.rpcMethodHandlers[method] = handler
+		engine.rpcMethodHandlers[method] = func(ctx *RpcContext) {
+			ctx.call(handler)
+		}
 	}
 
 	log.Debug("HandleRpcMethod: %v", method)
 }
 
+// handler dispatcher
+func (engine *TcpEngin) Dispatcher() *Dispatcher {
+	return engine.dispatcher
+}
+
+// setting handler dispatch mode by cmd, DispatchKeyed should be set by SetDispatchKey
+func (engine *TcpEngin) SetDispatchMode(cmd uint32, mode DispatchMode) error {
+	return engine.dispatcher.SetMode(cmd, mode)
+}
+
+// setting keyed handler dispatch by cmd, handlers with the same key run in order
+func (engine *TcpEngin) SetDispatchKey(cmd uint32, key func(client *TcpClient, msg IMessage) interface{}) {
+	engine.dispatcher.SetKey(cmd, func(sess interface{}, msg IMessage) interface{} {
+		return key(sess.(*TcpClient), msg)
+	})
+}
+
+// setting handler dispatch mode by rpc method, DispatchKeyed should be set by SetRpcMethodDispatchKey
+func (engine *TcpEngin) SetRpcMethodDispatchMode(method string, mode DispatchMode) error {
+	return engine.dispatcher.SetMode(method, mode)
+}
+
+// setting keyed handler dispatch by rpc method, handlers with the same key run in order
+func (engine *TcpEngin) SetRpcMethodDispatchKey(method string, key func(ctx *RpcContext) interface{}) {
+	engine.dispatcher.SetKey(method, func(sess interface{}, msg IMessage) interface{} {
+		return key(newRpcContext(method, sess.(*TcpClient), msg))
+	})
+}
+
+// setting shared worker pool for DispatchPool, timeout 0 means block until pushed
+func (engine *TcpEngin) SetWorkerPool(pool *util.WorkerPool, timeout time.Duration) {
+	engine.dispatcher.SetWorkerPool(pool, timeout)
+}
+
+// inbound message rate limiter
+func (engine *TcpEngin) RateLimiter() *RateLimiter {
+	return engine.rateLimiter
+}
+
+// setting inbound message rate limiter, nil to disable
+func (engine *TcpEngin) SetRateLimiter(limiter *RateLimiter) {
+	engine.rateLimiter = limiter
+}
+
+// setting capture of inbound and outbound messages, nil to disable
+func (engine *TcpEngin) SetCapture(c *Capture) {
+	engine.capture = c
+}
+
 // socket nodelay
 func (engine *TcpEngin) SockNoDelay() bool {
 	return engine.sockNoDelay
@@ -605,10 +719,11 @@ func (engine *TcpEngin) BroadCast(msg IMessage) {
 // tcp engine factory
 func NewTcpEngine() *TcpEngin {
 	engine := &TcpEngin{
-		clients:  map[*TcpClient]struct{}{},
-		handlers: map[uint32]func(*TcpClient, IMessage){},
-		running:  true,
-		Codec:    DefaultCodec,
+		clients:    map[*TcpClient]struct{}{},
+		handlers:   map[uint32]func(*TcpClient, IMessage){},
+		dispatcher: NewDispatcher(),
+		running:    1,
+		Codec:      DefaultCodec,
 
 		sockNoDelay:            DefaultSockNodelay,
 		sockKeepAlive:          DefaultSockKeepalive,
@@ -24,28 +24,35 @@ type TcpServer struct {
 	stopTimeout   time.Duration
 	onStopTimeout func()
 	onStopHandler func(server *TcpServer)
+	ipFilter      *IpFilter
+	proxyProto    *proxyProtocol
+	draining      bool
+
+	// session migration hooks
+	migrateOutHandler func(client *TcpClient) ([]byte, error)
+	migrateInHandler  func(client *TcpClient, state []byte) error
 }
 
+// close handler tag of server
+type tcpServerCloseTag struct{}
+
 // add client
 func (server *TcpServer) addClient(client *TcpClient) {
-	if server.enableBroad {
-		server.Lock()
-		server.clients[client] = struct{}{}
-		server.Unlock()
-
-	}
+	server.Lock()
+	server.clients[client] = struct{}{}
+	server.Unlock()
 	atomic.AddInt64(&server.currLoad, 1)
-	server.OnNewClient(client)
+	metricConnections.With(metricProtoTcp, server.tag).Inc()
+	client.OnClose(tcpServerCloseTag{}, server.deleClient)
 }
 
 // delete client
 func (server *TcpServer) deleClient(client *TcpClient) {
-	if server.enableBroad {
-		server.Lock()
-		delete(server.clients, client)
-		server.Unlock()
-	}
+	server.Lock()
+	delete(server.clients, client)
+	server.Unlock()
 	atomic.AddInt64(&server.currLoad, -1)
+	metricConnections.With(metricProtoTcp, server.tag).Dec()
 }
 
 // stop all clients
@@ -58,6 +65,122 @@ func (server *TcpServer) stopClients() {
 	}
 }
 
+// read proxy protocol header before handling accepted connection
+func (server *TcpServer) onProxyAccept(conn *net.TCPConn, proxy *proxyProtocol) {
+	hdr, err := proxy.readHeader(conn)
+	if err != nil {
+		log.Debug("[TcpServer %s] read proxy header from %v failed: %v", server.tag, conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	if !server.isRunning() {
+		conn.Close()
+		return
+	}
+
+	realIp := ""
+	if hdr != nil && !hdr.Local {
+		realIp = addrIp(hdr.SrcAddr)
+	}
+	server.onAccept(conn, realIp)
+}
+
+// handle accepted connection, realIp is from proxy protocol header
+func (server *TcpServer) onAccept(conn *net.TCPConn, realIp string) {
+	if server.maxLoad != 0 && atomic.LoadInt64(&server.currLoad) >= server.maxLoad {
+		conn.Close()
+		return
+	}
+
+	// if runtime.GOOS == "linux" {
+	// conn.File() cause block mod and create new os thread for socket, then beyond max thread num
+	// 	if file, err = conn.File(); err == nil {
+	// 		idx = uint64(file.Fd())
+	// 	}
+	// } else {
+	// 	idx = server.accepted
+	// 	server.accepted++
+	// }
+
+	limitIp := ""
+	if server.ipFilter != nil {
+		ip := realIp
+		if ip == "" {
+			ip = addrIp(conn.RemoteAddr())
+		}
+		if realIp != "" || !server.ipFilter.IsTrustedProxy(ip) {
+			if err := server.ipFilter.Acquire(ip); err != nil {
+				log.Debug("[TcpServer %s] refuse %v: %v", server.tag, ip, err)
+				conn.Close()
+				return
+			}
+			limitIp = ip
+		}
+	}
+
+	atomic.AddInt64(&server.accepted, 1)
+	metricAccepted.With(metricProtoTcp, server.tag).Inc()
+
+	if err := server.OnNewConn(conn); err == nil {
+		client := server.CreateClient(conn, server.TcpEngin, server.NewCipher())
+		if realIp != "" {
+			client.realIp = realIp
+			client.proxyIp = true
+		}
+		server.setLimitIp(client, limitIp)
+		server.addClient(client)
+		server.OnNewClient(client)
+		client.start()
+	} else {
+		if limitIp != "" {
+			server.ipFilter.Release(limitIp)
+		}
+		log.Debug("[TcpServer %s] init conn error: %v\n", server.tag, err)
+	}
+}
+
+// setting the ip counted by ip filter, released when client closed
+func (server *TcpServer) setLimitIp(client *TcpClient, ip string) {
+	client.limitIp = ip
+	if ip != "" {
+		filter := server.ipFilter
+		client.OnClose(ipFilterCloseTag{}, func(*TcpClient) {
+			filter.Release(ip)
+		})
+	}
+}
+
+// on set real ip, with ip filter only trusted proxies can set real ip
+func (server *TcpServer) onSetRealIp(client *TcpClient, msg IMessage) {
+	filter := server.ipFilter
+	if filter == nil {
+		client.SetRealIp(string(msg.Body()))
+		return
+	}
+
+	remoteIp := addrIp(client.conn.RemoteAddr())
+	if !filter.IsTrustedProxy(remoteIp) {
+		log.Debug("[TcpServer %s] ignore set real ip from untrusted %v", server.tag, remoteIp)
+		return
+	}
+
+	client.SetRealIp(string(msg.Body()))
+	realIp := client.Ip()
+	if realIp == client.limitIp {
+		return
+	}
+	if err := filter.Acquire(realIp); err != nil {
+		log.Debug("[TcpServer %s] refuse real ip %v: %v", server.tag, realIp, err)
+		client.Stop()
+		return
+	}
+	if client.limitIp != "" {
+		client.CancelOnClose(ipFilterCloseTag{})
+		filter.Release(client.limitIp)
+	}
+	server.setLimitIp(client, realIp)
+}
+
 // listener loop
 func (server *TcpServer) listenerLoop() error {
 	log.Debug("[TcpServer %s] Running on: \"%s\"", server.tag, server.addr)
@@ -66,32 +189,17 @@ func (server *TcpServer) listenerLoop() error {
 	var (
 		err       error
 		conn      *net.TCPConn
-		client    *TcpClient
 		tempDelay time.Duration
 	)
-	for server.running {
+	for server.isRunning() {
 		if conn, err = server.listener.AcceptTCP(); err == nil {
-			if server.maxLoad == 0 || atomic.LoadInt64(&server.currLoad) < server.maxLoad {
-				// if runtime.GOOS == "linux" {
-				// conn.File() cause block mod and create new os thread for socket, then beyond max thread num
-				// 	if file, err = conn.File(); err == nil {
-				// 		idx = uint64(file.Fd())
-				// 	}
-				// } else {
-				// 	idx = server.accepted
-				// 	server.accepted++
-				// }
-				server.accepted++
-
-				if err = server.OnNewConn(conn); err == nil {
-					client = server.CreateClient(conn, server.TcpEngin, server.NewCipher())
-					client.start()
-					server.addClient(client)
-				} else {
-					log.Debug("[TcpServer %s] init conn error: %v\n", server.tag, err)
-				}
+			if proxy := server.proxyProto; proxy != nil {
+				c := conn
+				util.Go(func() {
+					server.onProxyAccept(c, proxy)
+				})
 			} else {
-				conn.Close()
+				server.onAccept(conn, "")
 			}
 		} else {
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
@@ -107,7 +215,7 @@ func (server *TcpServer) listenerLoop() error {
 				time.Sleep(tempDelay)
 			} else {
 				log.Debug("[TcpServer %s] Accept error: %v", server.tag, err)
-				if server.onStopHandler != nil {
+				if server.onStopHandler != nil && !server.draining {
 					server.onStopHandler(server)
 				}
 				break
@@ -121,20 +229,14 @@ func (server *TcpServer) listenerLoop() error {
 // start
 func (server *TcpServer) Start(addr string) error {
 	server.Lock()
-	running := server.running
-	server.running = true
+	running := server.setRunning(true)
 	server.Unlock()
 
 	if !running {
 		server.Add(1)
 
-		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
-		if err != nil {
-			log.Fatal("[TcpServer %s] ResolveTCPAddr error: %v", server.tag, err)
-			return err
-		}
-
-		server.listener, err = net.ListenTCP("tcp", tcpAddr)
+		var err error
+		server.listener, err = ListenTcp(addr)
 		if err != nil {
 			log.Fatal("[TcpServer %s] Listening error: %v", server.tag, err)
 			return err
@@ -151,8 +253,7 @@ func (server *TcpServer) Start(addr string) error {
 // stop
 func (server *TcpServer) Stop() {
 	server.Lock()
-	running := server.running
-	server.running = false
+	running := server.setRunning(false)
 	server.Unlock()
 	defer util.HandlePanic()
 
@@ -176,7 +277,12 @@ func (server *TcpServer) Stop() {
 
 	server.Wait()
 
-	server.stopClients()
+	// clients are tracked for migration and admin, but only closed by broadcast server as before
+	if server.enableBroad {
+		server.stopClients()
+	}
+
+	server.dispatcher.stopOwned()
 
 	if server.onStopHandler != nil {
 		server.onStopHandler(server)
@@ -184,6 +290,36 @@ func (server *TcpServer) Stop() {
 	log.Debug("[TcpServer %s] Stop Done.", server.tag)
 }
 
+// setting session migration hooks: out serializes user state of client migrating to successor, in restores
+// user state of client migrated from predecessor
+func (server *TcpServer) HandleMigrate(out func(client *TcpClient) ([]byte, error), in func(client *TcpClient, state []byte) error) {
+	server.migrateOutHandler = out
+	server.migrateInHandler = in
+}
+
+// drain: stop accepting, wait for clients disconnected until timeout, then stop
+func (server *TcpServer) Drain(timeout time.Duration) {
+	server.Lock()
+	running := server.isRunning()
+	if running {
+		server.draining = true
+	}
+	server.Unlock()
+	if !running {
+		return
+	}
+
+	server.listener.Close()
+	log.Debug("[TcpServer %s] Draining %v clients...", server.tag, server.CurrLoad())
+
+	deadline := time.Now().Add(timeout)
+	for server.CurrLoad() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	server.Stop()
+}
+
 // stop with timeout
 func (server *TcpServer) StopWithTimeout(stopTimeout time.Duration, onStopTimeout func()) {
 	server.stopTimeout = stopTimeout
@@ -203,6 +339,13 @@ func (server *TcpServer) Serve(addr string, stopTimeout time.Duration) {
 	}
 
 	util.HandleSignal(func(sig os.Signal) {
+		if isHotRestartSignal(sig) {
+			if _, err := HotRestart(0); err == nil {
+				server.Drain(stopTimeout)
+				os.Exit(0)
+			}
+			return
+		}
 		if sig == syscall.SIGTERM || sig == syscall.SIGINT {
 			server.Stop()
 			os.Exit(0)
@@ -227,7 +370,28 @@ func (server *TcpServer) SetMaxConcurrent(maxLoad int64) {
 
 // total accept num
 func (server *TcpServer) AcceptedNum() int64 {
-	return server.accepted
+	return atomic.LoadInt64(&server.accepted)
+}
+
+// ip filter
+func (server *TcpServer) IpFilter() *IpFilter {
+	return server.ipFilter
+}
+
+// setting ip filter, nil to disable
+func (server *TcpServer) SetIpFilter(filter *IpFilter) {
+	server.ipFilter = filter
+}
+
+// enable proxy protocol v1/v2 for connections from trusted proxies, the header is read
+// before OnNewConn and the client address in it is used as the client's real ip
+func (server *TcpServer) EnableProxyProtocol(trustedProxies []string) error {
+	proxy, err := newProxyProtocol(trustedProxies)
+	if err != nil {
+		return err
+	}
+	server.proxyProto = proxy
+	return nil
 }
 
 // setting server stop handler
@@ -270,13 +434,9 @@ func (server *TcpServer) BroadcastWithFilter(msg IMessage, filter func(*TcpClien
 func NewTcpServer(tag string) *TcpServer {
 	server := &TcpServer{
 		TcpEngin: &TcpEngin{
-			clients: map[*TcpClient]struct{}{},
-			handlers: map[uint32]func(*TcpClient, IMessage){
-				CmdSetReaIp: func(client *TcpClient, msg IMessage) {
-					ip := msg.Body()
-					client.SetRealIp(string(ip))
-				},
-			},
+			clients:    map[*TcpClient]struct{}{},
+			handlers:   map[uint32]func(*TcpClient, IMessage){},
+			dispatcher: NewDispatcher(),
 
 			sockNoDelay:            DefaultSockNodelay,
 			sockKeepAlive:          DefaultSockKeepalive,
@@ -299,7 +459,7 @@ func NewTcpServer(tag string) *TcpServer {
 		return cipher
 	})
 
-	server.HandleDisconnected(server.deleClient)
+	server.handlers[CmdSetReaIp] = server.onSetRealIp
 
 	return server
 }
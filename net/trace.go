@@ -0,0 +1,66 @@
+package net
+
+import (
+	"encoding/binary"
+	"github.com/nothollyhigh/kiss/trace"
+	"strconv"
+)
+
+// span name of rpc method or cmd
+func traceSpanName(cmd uint32, method string) string {
+	if method != "" {
+		return method
+	}
+	return "cmd " + strconv.FormatUint(uint64(cmd), 10)
+}
+
+// start client span of rpc call as a child of parent, or of the active span of current goroutine if parent is nil.
+// the span context is appended to data and cmd is flagged with CmdFlagMaskTrace
+func traceRpcCall(proto string, cmd uint32, method string, data []byte, parent *trace.Span) (*trace.Span, uint32, []byte) {
+	var span *trace.Span
+	if parent != nil {
+		span = trace.StartSpanWithParent(traceSpanName(cmd, method), trace.KindClient, parent.Context())
+	} else {
+		span = trace.StartSpan(traceSpanName(cmd, method), trace.KindClient)
+	}
+	if span == nil {
+		return nil, cmd, data
+	}
+	span.SetAttribute("proto", proto)
+	return span, cmd | CmdFlagMaskTrace, span.Context().AppendTo(data)
+}
+
+// finish client span of rpc call
+func traceRpcDone(span *trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.SetError(err.Error())
+	}
+	span.Finish()
+}
+
+// strip span context from message flagged with CmdFlagMaskTrace, should be called before dispatching
+func traceExtract(imsg IMessage) {
+	msg, ok := imsg.(*Message)
+	if !ok {
+		return
+	}
+	cmd := msg.Cmd()
+	if cmd&CmdFlagMaskTrace == 0 {
+		return
+	}
+	msg.SetCmd(cmd &^ CmdFlagMaskTrace)
+
+	bodyLen := len(msg.data) - DEFAULT_MESSAGE_HEAD_LEN
+	if bodyLen < trace.SpanContextLen {
+		return
+	}
+	sc, err := trace.ParseSpanContext(msg.data[len(msg.data)-trace.SpanContextLen:])
+	if err == nil {
+		msg.trace = sc
+	}
+	msg.data = msg.data[:len(msg.data)-trace.SpanContextLen]
+	binary.LittleEndian.PutUint32(msg.data[DEFAULT_BODY_LEN_IDX_BEGIN:DEFAULT_BODY_LEN_IDX_END], uint32(bodyLen-trace.SpanContextLen))
+}
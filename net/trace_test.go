@@ -0,0 +1,229 @@
+package net
+
+import (
+	"bytes"
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/trace"
+	"github.com/nothollyhigh/kiss/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRpcTrace(t *testing.T) {
+	exporter := trace.NewMemoryExporter()
+	trace.SetExporter(exporter)
+	defer trace.SetExporter(nil)
+	trace.SetGoroutineLocal(true)
+	defer trace.SetGoroutineLocal(false)
+
+	logBuf := &bytes.Buffer{}
+	logger := log.NewLogger()
+	logger.SetOutput(logBuf)
+
+	dbAddr := freeTcpAddr(t)
+	db := NewTcpServer("trace-db")
+	db.HandleRpcMethod("Load", func(ctx *RpcContext) {
+		logger.Info("load %v", string(ctx.Body()))
+		ctx.Write("data")
+	}, true)
+	go db.Start(dbAddr)
+	defer db.Stop()
+
+	var dbClient *RpcClient
+	var err error
+	for i := 0; i < 50; i++ {
+		if dbClient, err = NewRpcClient(dbAddr, nil, nil, nil); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatalf("NewRpcClient failed: %v", err)
+	}
+	defer dbClient.Shutdown()
+
+	gateAddr := freeTcpAddr(t)
+	gate := NewTcpServer("trace-gate")
+	gate.HandleRpcMethod("Login", func(ctx *RpcContext) {
+		if ctx.Span() == nil {
+			ctx.Error("no span")
+			return
+		}
+		rsp := ""
+		if err := dbClient.Call("Load", ctx.Body(), &rsp, time.Second*3); err != nil {
+			ctx.Error(err.Error())
+			return
+		}
+		ctx.Error("denied")
+	})
+	go gate.Start(gateAddr)
+	defer gate.Stop()
+
+	var gateClient *RpcClient
+	for i := 0; i < 50; i++ {
+		if gateClient, err = NewRpcClient(gateAddr, nil, nil, nil); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatalf("NewRpcClient failed: %v", err)
+	}
+	defer gateClient.Shutdown()
+
+	root := trace.StartSpan("player", trace.KindInternal)
+	trace.WithSpan(root, func() {
+		err = gateClient.Call("Login", []byte("alice"), nil, time.Second*3)
+	})
+	root.Finish()
+	if err == nil || err.Error() != "denied" {
+		t.Fatalf("Call should fail with denied, got %v", err)
+	}
+
+	traceId := root.TraceId()
+	want := []struct {
+		name   string
+		kind   string
+		parent string
+	}{
+		{"Load", "server", "Load"},
+		{"Load", "client", "Login"},
+		{"Login", "server", "Login"},
+		{"Login", "client", "player"},
+		{"player", "internal", ""},
+	}
+	var spans []*trace.SpanData
+	for i := 0; i < 100; i++ {
+		if spans = exporter.Trace(traceId); len(spans) >= len(want) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if len(spans) != len(want) {
+		t.Fatalf("spans of trace should be %d, got %d", len(want), len(spans))
+	}
+
+	byId := map[string]*trace.SpanData{}
+	for _, s := range spans {
+		byId[s.SpanId] = s
+	}
+	find := func(name, kind string) *trace.SpanData {
+		for _, s := range spans {
+			if s.Name == name && s.Kind == kind {
+				return s
+			}
+		}
+		t.Fatalf("span %v %v not exported", name, kind)
+		return nil
+	}
+	for _, w := range want {
+		s := find(w.name, w.kind)
+		if w.parent == "" {
+			if s.ParentId != "" {
+				t.Fatalf("span %v should be root, got parent %v", s.Name, s.ParentId)
+			}
+			continue
+		}
+		parent, ok := byId[s.ParentId]
+		if !ok {
+			t.Fatalf("parent of span %v %v not exported", s.Name, s.Kind)
+		}
+		// server spans are children of client spans with the same name
+		if parent.Name != w.parent || (w.kind == "server" && parent.Kind != "client") {
+			t.Fatalf("parent of span %v %v should be %v, got %v %v", s.Name, s.Kind, w.parent, parent.Name, parent.Kind)
+		}
+	}
+	if s := find("Login", "server"); s.Error != "denied" {
+		t.Fatalf("server span error should be denied, got %v", s.Error)
+	}
+
+	if !strings.Contains(logBuf.String(), "[trace:"+traceId+"]") {
+		t.Fatalf("log should contain trace id %v, got %v", traceId, logBuf.String())
+	}
+}
+
+func TestRpcTraceParent(t *testing.T) {
+	exporter := trace.NewMemoryExporter()
+	trace.SetExporter(exporter)
+	defer trace.SetExporter(nil)
+
+	dbAddr := freeTcpAddr(t)
+	db := NewTcpServer("trace-parent-db")
+	db.HandleRpcMethod("Load", func(ctx *RpcContext) {
+		ctx.Write("data")
+	}, true)
+	go db.Start(dbAddr)
+	defer db.Stop()
+
+	var dbClient, gateClient *RpcClient
+	var err error
+	for i := 0; i < 50; i++ {
+		if dbClient, err = NewRpcClient(dbAddr, nil, nil, nil); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatalf("NewRpcClient failed: %v", err)
+	}
+	defer dbClient.Shutdown()
+
+	gateAddr := freeTcpAddr(t)
+	gate := NewTcpServer("trace-parent-gate")
+	// without goroutine local spans, the server span is passed explicitly to a call from another goroutine
+	gate.HandleRpcMethod("Login", func(ctx *RpcContext) {
+		done := make(chan error, 1)
+		util.Go(func() {
+			rsp := ""
+			done <- dbClient.CallWithParent(ctx.Span(), "Load", ctx.Body(), &rsp, time.Second*3)
+		})
+		if err := <-done; err != nil {
+			ctx.Error(err.Error())
+			return
+		}
+		ctx.Write("ok")
+	})
+	go gate.Start(gateAddr)
+	defer gate.Stop()
+
+	for i := 0; i < 50; i++ {
+		if gateClient, err = NewRpcClient(gateAddr, nil, nil, nil); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatalf("NewRpcClient failed: %v", err)
+	}
+	defer gateClient.Shutdown()
+
+	root := trace.StartSpan("player", trace.KindInternal)
+	rsp := ""
+	if err = gateClient.CallWithParent(root, "Login", []byte("alice"), &rsp, time.Second*3); err != nil {
+		t.Fatalf("CallWithParent failed: %v", err)
+	}
+	root.Finish()
+
+	var spans []*trace.SpanData
+	for i := 0; i < 100; i++ {
+		if spans = exporter.Trace(root.TraceId()); len(spans) >= 5 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if len(spans) != 5 {
+		t.Fatalf("spans of trace should be 5, got %d", len(spans))
+	}
+	byId := map[string]*trace.SpanData{}
+	for _, s := range spans {
+		byId[s.SpanId] = s
+	}
+	for _, s := range spans {
+		if s.Name == "Load" && s.Kind == "client" {
+			if parent := byId[s.ParentId]; parent == nil || parent.Name != "Login" || parent.Kind != "server" {
+				t.Fatalf("parent of Load client span should be Login server span, got %+v", parent)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package net
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// websocket handshake rejected with http status and reason
+type WSAuthError struct {
+	Status int
+	Reason string
+}
+
+// error
+func (e *WSAuthError) Error() string {
+	return e.Reason
+}
+
+// websocket handshake error factory
+func NewWSAuthError(status int, reason string) *WSAuthError {
+	return &WSAuthError{Status: status, Reason: reason}
+}
+
+// http status and reason of handshake error, def is used for other errors
+func wsAuthStatus(err error, def int) (int, string) {
+	if e, ok := err.(*WSAuthError); ok {
+		return e.Status, e.Reason
+	}
+	return def, err.Error()
+}
+
+// connection token of request, from "Authorization: Bearer <token>" or query "token"
+func WSTokenFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return r.URL.Query().Get("token")
+}
+
+// whether origin matches pattern: "*", "https://a.com", "a.com", "*.a.com" or "https://*.a.com"
+func originMatch(origin *url.URL, pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+	host := strings.ToLower(origin.Host)
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		if !strings.EqualFold(pattern[:i], origin.Scheme) {
+			return false
+		}
+		pattern = pattern[i+3:]
+	}
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// whether Origin header matches one of origins, requests without Origin are from non-browser clients and allowed
+func originAllowed(origins []string, r *http.Request) bool {
+	if len(origins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range origins {
+		if originMatch(u, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// check Origin header
+func (s *WSServer) checkOrigin(r *http.Request) bool {
+	return originAllowed(s.allowedOrigins, r)
+}
+
+// setting allowed origins, nil to allow all
+func (s *WSServer) SetAllowedOrigins(origins []string) {
+	s.allowedOrigins = origins
+}
+
+// setting auth handler, called with the token of WSTokenFromRequest before upgrade. returning
+// a *WSAuthError rejects the request with its status and reason, other errors with 401
+func (s *WSServer) HandleAuth(h func(r *http.Request, token string) (identity interface{}, err error)) {
+	s.authHandler = h
+}
+
+// identity returned by auth handler
+func (cli *WSClient) Identity() interface{} {
+	return cli.identity
+}
+
+// setting identity
+func (cli *WSClient) SetIdentity(identity interface{}) {
+	cli.identity = identity
+}
+
+// sign connection token for identity, valid for ttl: base64(identity|expire).base64(hmac-sha256)
+func SignToken(secret []byte, identity string, ttl time.Duration) string {
+	payload := identity + "|" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify connection token signed by SignToken, returns identity
+func VerifyToken(secret []byte, token string) (string, error) {
+	pos := strings.IndexByte(token, '.')
+	if pos < 0 {
+		return "", ErrTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:pos])
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	sign, err := base64.RawURLEncoding.DecodeString(token[pos+1:])
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(sign, mac.Sum(nil)) {
+		return "", ErrTokenInvalid
+	}
+
+	sep := strings.LastIndexByte(string(payload), '|')
+	if sep < 0 {
+		return "", ErrTokenInvalid
+	}
+	expire, err := strconv.ParseInt(string(payload[sep+1:]), 10, 64)
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	if time.Now().Unix() > expire {
+		return "", ErrTokenExpired
+	}
+	return string(payload[:sep]), nil
+}
@@ -0,0 +1,94 @@
+package net
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketAuth(t *testing.T) {
+	const cmdWhoami = uint32(1)
+	secret := []byte("kiss")
+
+	server, err := NewWebsocketServer("auth", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.SetAllowedOrigins([]string{"https://*.kiss.com"})
+	server.HandleAuth(func(r *http.Request, token string) (interface{}, error) {
+		if token == "" {
+			return nil, NewWSAuthError(http.StatusUnauthorized, "token required")
+		}
+		if token == "banned" {
+			return nil, NewWSAuthError(http.StatusForbidden, "banned")
+		}
+		return VerifyToken(secret, token)
+	})
+	server.HandleWs("/ws")
+	server.Handle(cmdWhoami, func(cli *WSClient, msg IMessage) {
+		cli.SendMsg(NewMessage(cmdWhoami, []byte(cli.Identity().(string))))
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	addr := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws"
+
+	dial := func(origin string, token string) (*websocket.Conn, int, string) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		if token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+		conn, rsp, err := websocket.DefaultDialer.Dial(addr, header)
+		if err != nil {
+			if rsp == nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			buf := make([]byte, 64)
+			n, _ := rsp.Body.Read(buf)
+			return nil, rsp.StatusCode, strings.TrimSpace(string(buf[:n]))
+		}
+		return conn, http.StatusSwitchingProtocols, ""
+	}
+
+	for _, c := range []struct {
+		origin string
+		token  string
+		status int
+		reason string
+	}{
+		{"https://evil.com", SignToken(secret, "u1", time.Minute), http.StatusForbidden, "origin not allowed"},
+		{"https://www.kiss.com", "", http.StatusUnauthorized, "token required"},
+		{"https://www.kiss.com", "banned", http.StatusForbidden, "banned"},
+		{"", SignToken(secret, "u1", -time.Minute), http.StatusUnauthorized, ErrTokenExpired.Error()},
+		{"", SignToken([]byte("other"), "u1", time.Minute), http.StatusUnauthorized, ErrTokenInvalid.Error()},
+	} {
+		conn, status, reason := dial(c.origin, c.token)
+		if conn != nil {
+			conn.Close()
+			t.Fatalf("%v %v should be refused", c.origin, c.token)
+		}
+		if status != c.status || reason != c.reason {
+			t.Fatalf("%v %v should be refused with %v %v, got %v %v", c.origin, c.token, c.status, c.reason, status, reason)
+		}
+	}
+
+	conn, _, _ := dial("https://www.kiss.com", SignToken(secret, "u|1", time.Minute))
+	if conn == nil {
+		t.Fatalf("valid token refused")
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	conn.WriteMessage(websocket.TextMessage, NewMessage(cmdWhoami, nil).Data())
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if msg := RawMessage(data); string(msg.Body()) != "u|1" {
+		t.Fatalf("identity should be u|1, got %s", msg.Body())
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/nothollyhigh/kiss/log"
 	"github.com/nothollyhigh/kiss/util"
+	"math/rand"
+	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,6 +25,12 @@ type WSClient struct {
 	// real ip
 	realIp string
 
+	// ip counted by ip filter
+	limitIp string
+
+	// real ip from forwarding headers, can't be reset
+	proxyIp bool
+
 	// send queue
 	chSend chan wsAsyncMessage
 
@@ -47,12 +55,47 @@ type WSClient struct {
 	// user data
 	userdata interface{}
 
+	// identity returned by WSServer's auth handler
+	identity interface{}
+
 	// client close callbacks
 	onCloseMap map[interface{}]func(*WSClient)
+
+	// read and write loops of current connection
+	loopWg sync.WaitGroup
+
+	// options for client created by NewWebsocketClientWithOpt
+	opt *WSClientOpt
+
+	// shutdown flag for auto reconnect client
+	closed bool
+
+	// messages unsent when disconnected, replayed after reconnected
+	unsent []wsAsyncMessage
+
+	// negotiated subprotocol
+	subprotocol string
+
+	// websocket message type of subprotocol, 0 means engine's MessageType
+	frameType int
+
+	// permessage-deflate negotiated
+	compressed bool
+
+	// http fallback session, Conn is nil if not nil
+	poll *wsPollSession
+}
+
+// start read and write loops
+func (cli *WSClient) start() {
+	cli.loopWg.Add(2)
+	util.Go(cli.readloop)
+	util.Go(cli.writeloop)
 }
 
 // read loop
 func (cli *WSClient) readloop() {
+	defer cli.loopWg.Done()
 	defer util.HandlePanic()
 	defer cli.Stop()
 
@@ -68,12 +111,28 @@ func (cli *WSClient) readloop() {
 
 // write loop
 func (cli *WSClient) writeloop() {
+	defer cli.loopWg.Done()
 	defer cli.Stop()
 	defer util.HandlePanic()
 
+	cli.RLock()
+	chSend := cli.chSend
+	replay := cli.opt != nil && cli.opt.ReplayUnsent
+	cli.RUnlock()
+
 	var err error
-	for msg := range cli.chSend {
-		err = cli.WSEngine.Send(cli, msg.data)
+	depth := metricSendQueueDepth.With(metricProtoWS)
+	for msg := range chSend {
+		depth.Observe(float64(len(chSend)))
+		if cli.poll != nil {
+			err = cli.poll.push(msg.data)
+		} else {
+			err = cli.WSEngine.Send(cli, msg.data)
+		}
+		if err != nil && replay {
+			cli.saveUnsent(msg)
+			break
+		}
 		if msg.cb != nil {
 			msg.cb(cli, err)
 		}
@@ -83,6 +142,22 @@ func (cli *WSClient) writeloop() {
 
 		atomic.AddInt64(&cli.sendSeq, 1)
 	}
+
+	if replay {
+		cli.Stop()
+		for msg := range chSend {
+			cli.saveUnsent(msg)
+		}
+	}
+}
+
+// save unsent message for replaying
+func (cli *WSClient) saveUnsent(msg wsAsyncMessage) {
+	cli.Lock()
+	if len(cli.unsent) < cap(cli.chSend) {
+		cli.unsent = append(cli.unsent, msg)
+	}
+	cli.Unlock()
 }
 
 // keepalive
@@ -95,13 +170,16 @@ func (cli *WSClient) Keepalive(interval time.Duration) {
 
 		cli.Lock()
 		running := cli.running
+		closed := cli.closed
 		cli.Unlock()
 
-		if !running {
+		if closed || (!running && (cli.opt == nil || !cli.opt.AutoReconnect)) {
 			return
 		}
 
-		cli.SendMsg(msg)
+		if running {
+			cli.SendMsg(msg)
+		}
 	}
 }
 
@@ -159,6 +237,14 @@ func (cli *WSClient) Ip() string {
 	return "0.0.0.0"
 }
 
+// ip of remote address
+func (cli *WSClient) remoteIp() string {
+	if cli.poll != nil {
+		return cli.poll.remoteIp
+	}
+	return addrIp(cli.Conn.RemoteAddr())
+}
+
 // port
 func (cli *WSClient) Port() int {
 	if cli.Conn != nil {
@@ -174,6 +260,9 @@ func (cli *WSClient) Port() int {
 
 // setting real ip
 func (cli *WSClient) SetRealIp(ip string) {
+	if cli.proxyIp {
+		return
+	}
 	if cli.realIp == "" {
 		cli.realIp = ip
 	} else if DefaultEnableMultiSetRealIp {
@@ -194,9 +283,15 @@ func (cli *WSClient) SendMsg(msg IMessage) error {
 	var err error = nil
 	cli.Lock()
 	if cli.running {
+		// cmd may be flagged in place by Encrypt, such as gzip
+		label := metricCmdLabel(msg.Cmd(), true)
+		captured := cli.capture.copy(msg)
+		data := msg.Encrypt(cli.SendSeq(), cli.SendKey(), cli.cipher)
 		select {
-		case cli.chSend <- wsAsyncMessage{msg.Encrypt(cli.SendSeq(), cli.SendKey(), cli.cipher), nil}:
+		case cli.chSend <- wsAsyncMessage{data, nil}:
 			cli.Unlock()
+			metricSent(metricProtoWS, label, len(data))
+			cli.capture.record(cli, metricProtoWS, CaptureOut, captured)
 		default:
 			cli.Unlock()
 			cli.OnSendQueueFull(cli, msg)
@@ -218,9 +313,15 @@ func (cli *WSClient) SendMsgWithCallback(msg IMessage, cb func(*WSClient, error)
 	var err error = nil
 	cli.Lock()
 	if cli.running {
+		// cmd may be flagged in place by Encrypt, such as gzip
+		label := metricCmdLabel(msg.Cmd(), true)
+		captured := cli.capture.copy(msg)
+		data := msg.Encrypt(cli.SendSeq(), cli.SendKey(), cli.cipher)
 		select {
-		case cli.chSend <- wsAsyncMessage{msg.Encrypt(cli.SendSeq(), cli.SendKey(), cli.cipher), cb}:
+		case cli.chSend <- wsAsyncMessage{data, cb}:
 			cli.Unlock()
+			metricSent(metricProtoWS, label, len(data))
+			cli.capture.record(cli, metricProtoWS, CaptureOut, captured)
 		default:
 			cli.Unlock()
 			cli.OnSendQueueFull(cli, msg)
@@ -245,6 +346,7 @@ func (cli *WSClient) SendData(data []byte) error {
 		select {
 		case cli.chSend <- wsAsyncMessage{data, nil}:
 			cli.Unlock()
+			metricSent(metricProtoWS, metricCmdRaw, len(data))
 		default:
 			cli.Unlock()
 			cli.OnSendQueueFull(cli, data)
@@ -269,6 +371,7 @@ func (cli *WSClient) SendDataWithCallback(data []byte, cb func(*WSClient, error)
 		select {
 		case cli.chSend <- wsAsyncMessage{data, cb}:
 			cli.Unlock()
+			metricSent(metricProtoWS, metricCmdRaw, len(data))
 		default:
 			cli.Unlock()
 			cli.OnSendQueueFull(cli, data)
@@ -285,13 +388,48 @@ func (cli *WSClient) SendDataWithCallback(data []byte, cb func(*WSClient, error)
 	return err
 }
 
+// push data sync, using for rpc
+func (cli *WSClient) pushDataSync(data []byte) error {
+	defer util.HandlePanic()
+	var err error = nil
+	cli.Lock()
+	if cli.running {
+		timeout := cli.WriteTimeout
+		if timeout <= 0 {
+			timeout = DefaultWriteTimeout
+		}
+		after := time.NewTimer(timeout)
+		defer after.Stop()
+		select {
+		case cli.chSend <- wsAsyncMessage{data, nil}:
+			cli.Unlock()
+			metricSent(metricProtoWS, metricCmdRaw, len(data))
+		case <-after.C:
+			cli.Unlock()
+			err = ErrRpcCallTimeout
+		}
+	} else {
+		cli.Unlock()
+		err = ErrWSClientIsStopped
+	}
+	if err != nil {
+		log.Debug("pushDataSync -> %v failed: %v", cli.Ip(), err)
+	}
+
+	return err
+}
+
 // Stop
 func (cli *WSClient) Stop() {
 	cli.Lock()
 	running := cli.running
 	if running {
 		cli.running = false
-		cli.Conn.Close()
+		if cli.poll != nil {
+			cli.poll.close()
+		} else {
+			cli.Conn.Close()
+		}
 		close(cli.chSend)
 	}
 	cli.Unlock()
@@ -301,6 +439,10 @@ func (cli *WSClient) Stop() {
 			cb(cli)
 		}
 		cli.RUnlock()
+
+		if cli.rateLimiter != nil {
+			cli.rateLimiter.Remove(cli)
+		}
 	}
 }
 
@@ -318,6 +460,19 @@ func (cli *WSClient) CancelOnClose(tag interface{}) {
 	cli.Unlock()
 }
 
+// setting close handler for ISession
+func (cli *WSClient) OnSessionClose(tag interface{}, cb func(sess ISession)) {
+	cli.OnClose(tag, func(c *WSClient) {
+		cb(c)
+	})
+}
+
+// close for ISession
+func (cli *WSClient) Close() error {
+	cli.Stop()
+	return nil
+}
+
 // default create websocket client by websocket server
 func newClient(conn *websocket.Conn, engine *WSEngine) *WSClient {
 	sendQSize := DefaultSendQSize
@@ -334,6 +489,7 @@ func newClient(conn *websocket.Conn, engine *WSEngine) *WSClient {
 		cipher:     cipher,
 		onCloseMap: map[interface{}]func(*WSClient){},
 	}
+	cli.initSubprotocol()
 
 	addr := conn.RemoteAddr().String()
 	if pos := strings.LastIndex(addr, ":"); pos > 0 {
@@ -355,8 +511,7 @@ func NewWebsocketClient(addr string) (*WSClient, error) {
 
 	cli := newClient(conn, NewWebsocketEngine())
 
-	util.Go(cli.readloop)
-	util.Go(cli.writeloop)
+	cli.start()
 
 	return cli, nil
 }
@@ -374,8 +529,189 @@ func NewWebsocketTLSClient(addr string) (*WSClient, error) {
 
 	cli := newClient(conn, NewWebsocketEngine())
 
-	util.Go(cli.readloop)
-	util.Go(cli.writeloop)
+	cli.start()
+
+	return cli, nil
+}
+
+// websocket client options
+type WSClientOpt struct {
+	// handshake request header
+	Header http.Header
+
+	// dialer, websocket.DefaultDialer if nil
+	Dialer *websocket.Dialer
+
+	// reconnect when disconnected until Shutdown
+	AutoReconnect bool
+
+	// reconnect backoff, the delay doubles from min to max with jitter
+	ReconnectMinInterval time.Duration
+	ReconnectMaxInterval time.Duration
+
+	// keepalive interval, 0 means DefaultWSKeepaliveTime, negative disables keepalive
+	KeepaliveInterval time.Duration
+
+	// replay messages unsent when disconnected after reconnected, the data is resent as encrypted
+	// before disconnected, so it should not be used with ciphers depending on send sequence
+	ReplayUnsent bool
+
+	// called after connected and each reconnected, such as for login and resubscribing
+	OnConnected func(cli *WSClient)
+}
+
+// jittered reconnect delay
+func (opt *WSClientOpt) reconnectDelay(times int) time.Duration {
+	min, max := opt.ReconnectMinInterval, opt.ReconnectMaxInterval
+	if min <= 0 {
+		min = DefaultWSReconnectMinInterval
+	}
+	if max < min {
+		max = DefaultWSReconnectMaxInterval
+		if max < min {
+			max = min
+		}
+	}
+	delay := max
+	if times < 32 {
+		if d := min << uint(times-1); d > 0 && d < max {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// dial by options
+func (opt *WSClientOpt) dial(addr string, engine *WSEngine) (*websocket.Conn, bool, error) {
+	dialer := opt.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	if engine.compression && !dialer.EnableCompression {
+		tmp := *dialer
+		tmp.EnableCompression = true
+		dialer = &tmp
+	}
+	conn, rsp, err := dialer.Dial(addr, opt.Header)
+	if err != nil {
+		return nil, false, err
+	}
+	return conn, wsDeflateInHeader(rsp.Header), nil
+}
+
+// restart with new connection after reconnected
+func (cli *WSClient) restart(conn *websocket.Conn, compressed bool) bool {
+	cli.Lock()
+	defer cli.Unlock()
+
+	if cli.running || cli.closed {
+		return false
+	}
+
+	cli.Conn = conn
+	cli.running = true
+	cli.cipher = cli.NewCipher()
+	cli.initSubprotocol()
+	cli.initCompression(compressed)
+	cli.recvSeq = 0
+	cli.sendSeq = 0
+	if addr := conn.RemoteAddr().String(); strings.LastIndex(addr, ":") > 0 {
+		cli.realIp = addr[:strings.LastIndex(addr, ":")]
+	}
+
+	cli.chSend = make(chan wsAsyncMessage, cap(cli.chSend))
+	for _, msg := range cli.unsent {
+		cli.chSend <- msg
+	}
+	cli.unsent = nil
+
+	cli.start()
+
+	return true
+}
+
+// reconnect until success or shutdown
+func (cli *WSClient) reconnect(addr string) {
+	// wait for loops of the old connection
+	cli.loopWg.Wait()
+
+	for times := 1; ; times++ {
+		time.Sleep(cli.opt.reconnectDelay(times))
+
+		cli.RLock()
+		closed := cli.closed
+		cli.RUnlock()
+		if closed {
+			return
+		}
+
+		conn, compressed, err := cli.opt.dial(addr, cli.WSEngine)
+		if err != nil {
+			log.Debug("Websocket auto reconnect to %v %d failed: %v", addr, times, err)
+			continue
+		}
+		if !cli.restart(conn, compressed) {
+			conn.Close()
+			return
+		}
+
+		log.Debug("Websocket auto reconnect to %v %d success", addr, times)
+		if cli.opt.OnConnected != nil {
+			cli.opt.OnConnected(cli)
+		}
+		return
+	}
+}
+
+// shutdown for auto reconnect client
+func (cli *WSClient) Shutdown() {
+	cli.Lock()
+	cli.closed = true
+	cli.Unlock()
+	cli.Stop()
+}
+
+// websocket client factory with engine and options, handlers can be registered on engine before connecting
+func NewWebsocketClientWithOpt(addr string, engine *WSEngine, opt *WSClientOpt) (*WSClient, error) {
+	if engine == nil {
+		engine = NewWebsocketEngine()
+	}
+	if opt == nil {
+		opt = &WSClientOpt{}
+	}
+
+	conn, compressed, err := opt.dial(addr, engine)
+	if err != nil {
+		return nil, err
+	}
+
+	cli := newClient(conn, engine)
+	cli.initCompression(compressed)
+	cli.opt = opt
+
+	if opt.AutoReconnect {
+		cli.OnClose("reconn", func(*WSClient) {
+			util.Go(func() {
+				cli.reconnect(addr)
+			})
+		})
+	}
+
+	cli.start()
+
+	if opt.KeepaliveInterval >= 0 {
+		interval := opt.KeepaliveInterval
+		if interval == 0 {
+			interval = DefaultWSKeepaliveTime
+		}
+		util.Go(func() {
+			cli.Keepalive(interval)
+		})
+	}
+
+	if opt.OnConnected != nil {
+		opt.OnConnected(cli)
+	}
 
 	return cli, nil
 }
@@ -0,0 +1,81 @@
+package net
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketClientReconnect(t *testing.T) {
+	const cmdEcho = uint32(1)
+
+	server, err := NewWebsocketServer("reconnect", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.HandleWs("/ws")
+	server.HandleConnect(func(cli *WSClient, w http.ResponseWriter, r *http.Request) error {
+		if r.Header.Get("X-Token") != "kiss" {
+			return ErrWSClientIsStopped
+		}
+		return nil
+	})
+	server.Handle(cmdEcho, func(cli *WSClient, msg IMessage) {
+		cli.SendMsg(NewMessage(cmdEcho, msg.Body()))
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	engine := NewWebsocketEngine()
+	chEcho := make(chan string, 8)
+	engine.Handle(cmdEcho, func(cli *WSClient, msg IMessage) {
+		chEcho <- string(msg.Body())
+	})
+
+	chConnected := make(chan struct{}, 8)
+	client, err := NewWebsocketClientWithOpt("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", engine, &WSClientOpt{
+		Header:               http.Header{"X-Token": []string{"kiss"}},
+		AutoReconnect:        true,
+		ReconnectMinInterval: time.Millisecond * 10,
+		ReconnectMaxInterval: time.Millisecond * 50,
+		OnConnected: func(cli *WSClient) {
+			chConnected <- struct{}{}
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWebsocketClientWithOpt failed: %v", err)
+	}
+	defer client.Shutdown()
+
+	echo := func(body string) {
+		client.SendMsg(NewMessage(cmdEcho, []byte(body)))
+		select {
+		case got := <-chEcho:
+			if got != body {
+				t.Fatalf("echo should be %v, got %v", body, got)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("echo %v timeout", body)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-chConnected:
+		case <-time.After(time.Second * 3):
+			t.Fatalf("connect %v timeout", i)
+		}
+		echo("hello")
+		server.stopClients()
+	}
+
+	client.Shutdown()
+	time.Sleep(time.Millisecond * 100)
+	select {
+	case <-chConnected:
+		t.Fatalf("should not reconnect after Shutdown")
+	default:
+	}
+}
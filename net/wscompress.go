@@ -0,0 +1,59 @@
+package net
+
+import (
+	"github.com/nothollyhigh/kiss/log"
+	"net/http"
+	"strings"
+)
+
+// whether permessage-deflate is in Sec-WebSocket-Extensions
+func wsDeflateInHeader(h http.Header) bool {
+	for _, line := range h[http.CanonicalHeaderKey("Sec-WebSocket-Extensions")] {
+		for _, ext := range strings.Split(line, ",") {
+			if name := strings.SplitN(ext, ";", 2)[0]; strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enable permessage-deflate for messages not shorter than threshold, level is compress/flate level.
+// CipherGzip is disabled for connections with deflate negotiated to avoid double compression
+func (engine *WSEngine) EnableCompression(threshold int, level int) {
+	engine.compression = true
+	engine.compressionThreshold = threshold
+	engine.compressionLevel = level
+}
+
+// permessage-deflate enabled
+func (engine *WSEngine) CompressionEnabled() bool {
+	return engine.compression
+}
+
+// init compression after handshake
+func (cli *WSClient) initCompression(negotiated bool) {
+	cli.compressed = negotiated && cli.compression
+	if !cli.compressed {
+		// negotiated by upgrader shared with other engines
+		cli.Conn.EnableWriteCompression(false)
+		return
+	}
+	if err := cli.Conn.SetCompressionLevel(cli.compressionLevel); err != nil {
+		log.Debug("Websocket SetCompressionLevel %v failed: %v", cli.compressionLevel, err)
+	}
+	if _, ok := cli.cipher.(*CipherGzip); ok {
+		cli.cipher = nil
+	}
+}
+
+// permessage-deflate negotiated
+func (cli *WSClient) Compressed() bool {
+	return cli.compressed
+}
+
+// enable permessage-deflate, see WSEngine.EnableCompression
+func (s *WSServer) EnableCompression(threshold int, level int) {
+	s.WSEngine.EnableCompression(threshold, level)
+	s.upgrader.EnableCompression = true
+}
@@ -0,0 +1,76 @@
+package net
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketCompression(t *testing.T) {
+	const cmdEcho = uint32(1)
+
+	server, err := NewWebsocketServer("compression", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.EnableCompression(16, 1)
+	server.HandleNewCipher(func() ICipher {
+		return NewCipherGzip(16)
+	})
+	server.HandleWs("/ws")
+	server.Handle(cmdEcho, func(cli *WSClient, msg IMessage) {
+		if !cli.Compressed() || cli.Cipher() != nil {
+			t.Errorf("server side should be compressed without cipher, got %v, %v", cli.Compressed(), cli.Cipher())
+		}
+		cli.SendMsg(NewMessage(cmdEcho, msg.Body()))
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	addr := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws"
+
+	for _, enable := range []bool{true, false} {
+		done := make(chan string, 1)
+		engine := NewWebsocketEngine()
+		engine.HandleNewCipher(func() ICipher {
+			return NewCipherGzip(16)
+		})
+		if enable {
+			engine.EnableCompression(16, 1)
+		}
+		engine.Handle(cmdEcho, func(cli *WSClient, msg IMessage) {
+			done <- string(msg.Body())
+		})
+
+		client, err := NewWebsocketClientWithOpt(addr, engine, nil)
+		if err != nil {
+			t.Fatalf("NewWebsocketClientWithOpt failed: %v", err)
+		}
+		if client.Compressed() != enable {
+			t.Fatalf("Compressed should be %v", enable)
+		}
+		if _, isGzip := client.Cipher().(*CipherGzip); isGzip == enable {
+			t.Fatalf("CipherGzip should be used only without deflate, compression: %v", enable)
+		}
+
+		if !enable {
+			// server handler asserts compression, only check the handshake here
+			client.Shutdown()
+			continue
+		}
+
+		body := strings.Repeat("kiss", 256)
+		for _, b := range []string{"tiny", body} {
+			client.SendMsg(NewMessage(cmdEcho, []byte(b)))
+			select {
+			case rsp := <-done:
+				if rsp != b {
+					t.Fatalf("echo mismatch, len %v != %v", len(rsp), len(b))
+				}
+			case <-time.After(time.Second * 3):
+				t.Fatalf("echo timeout")
+			}
+		}
+		client.Shutdown()
+	}
+}
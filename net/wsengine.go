@@ -1,6 +1,7 @@
 package net
 
 import (
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/nothollyhigh/kiss/log"
 	"github.com/nothollyhigh/kiss/util"
@@ -34,12 +35,29 @@ type WSEngine struct {
 	// shutdown flag
 	shutdown bool
 
+	// permessage-deflate
+	compression          bool
+	compressionThreshold int
+	compressionLevel     int
+
 	//ctypto cipher
 	cipher ICipher
 
 	// message handlers
 	handlers map[uint32]func(cli *WSClient, msg IMessage)
 
+	// rpc method handlers
+	rpcMethodHandlers map[string]func(*RpcContext)
+
+	// handler dispatcher
+	dispatcher *Dispatcher
+
+	// inbound message rate limiter
+	rateLimiter *RateLimiter
+
+	// capture of decrypted messages
+	capture *Capture
+
 	// user defined message handler
 	messageHandler func(cli *WSClient, msg IMessage)
 
@@ -79,17 +97,34 @@ func (engine *WSEngine) RecvMsg(cli *WSClient) IMessage {
 		return nil
 	}
 
+	msg, err := engine.decodeMsg(cli, data)
+	if err != nil {
+		log.Debug("%s RecvMsg failed: %v", cli.Conn.RemoteAddr().String(), err)
+		return nil
+	}
+
+	return msg
+}
+
+// decode json frame of kiss.json subprotocol and decrypt message
+func (engine *WSEngine) decodeMsg(cli *WSClient, data []byte) (*Message, error) {
+	var err error
+	if cli.subprotocol == WSSubprotocolJson {
+		if data, err = wsJsonDecode(data); err != nil {
+			return nil, err
+		}
+	}
+
 	msg := &Message{
 		rawData: data,
 		data:    nil,
 	}
 
 	if _, err = msg.Decrypt(cli.RecvSeq(), cli.RecvKey(), cli.Cipher()); err != nil {
-		log.Debug("%s RecvMsg Decrypt Err: %v", cli.Conn.RemoteAddr().String(), err)
-		return nil
+		return nil, err
 	}
 
-	return msg
+	return msg, nil
 }
 
 // send websocket data
@@ -111,7 +146,18 @@ func (engine *WSEngine) Send(cli *WSClient, data []byte) error {
 		}
 	}
 
-	err = cli.Conn.WriteMessage(engine.MessageType, data)
+	if cli.compressed {
+		cli.Conn.EnableWriteCompression(len(data) >= engine.compressionThreshold)
+	}
+
+	if cli.subprotocol == WSSubprotocolJson {
+		if data, err = wsJsonEncode(data); err != nil {
+			log.Debug("%s Send encode json frame failed: %v", cli.Conn.RemoteAddr().String(), err)
+			return err
+		}
+	}
+
+	err = cli.Conn.WriteMessage(cli.messageType(), data)
 	if err != nil {
 		log.Debug("%s Send Write Err: %v", cli.Conn.RemoteAddr().String(), err)
 		cli.Stop()
@@ -148,6 +194,82 @@ func (engine *WSEngine) Handle(cmd uint32, h func(cli *WSClient, msg IMessage))
 	engine.handlers[cmd] = h
 }
 
+// handle rpc cmd
+func (engine *WSEngine) HandleRpcCmd(cmd uint32, handler func(ctx *RpcContext), async bool) {
+	checkUserCmd(cmd)
+	if _, ok := engine.handlers[cmd]; ok {
+		panic(fmt.Errorf("Websocket HandleRpcCmd failed: handler for cmd %v exists", cmd))
+	}
+	if async {
+		engine.handlers[cmd] = func(cli *WSClient, msg IMessage) {
+			util.Go(func() {
+				newRpcContext("", cli, msg).call(handler)
+			})
+		}
+	} else {
+		engine.handlers[cmd] = func(cli *WSClient, msg IMessage) {
+			newRpcContext("", cli, msg).call(handler)
+		}
+	}
+}
+
+// on rpc method call
+func (engine *WSEngine) onRpcMethod(cli *WSClient, imsg IMessage) {
+	msg := imsg.(*Message)
+	method, err := rpcMethodOf(msg)
+	if err != nil {
+		cli.SendMsg(NewRpcMessage(CmdRpcError, msg.Ext(), []byte(err.Error())))
+		return
+	}
+	handler, ok := engine.rpcMethodHandlers[method]
+	if !ok {
+		cli.SendMsg(NewRpcMessage(CmdRpcError, msg.Ext(), []byte(fmt.Sprintf("invalid rpc method %s", method))))
+		return
+	}
+	trimRpcMethod(msg, method)
+	ctx := newRpcContext(method, cli, msg)
+	engine.dispatch(method, cli, msg, func() {
+		handler(ctx)
+	})
+}
+
+// init rpc handler
+func (engine *WSEngine) initRpcHandler() {
+	if engine.rpcMethodHandlers == nil {
+		engine.rpcMethodHandlers = map[string]func(*RpcContext){}
+		engine.handlers[CmdRpcMethod] = engine.onRpcMethod
+	}
+}
+
+// setting handle rpc method
+func (engine *WSEngine) HandleRpcMethod(method string, handler func(ctx *RpcContext), args ...interface{}) {
+	engine.initRpcHandler()
+	if _, ok := engine.rpcMethodHandlers[method]; ok {
+		panic(fmt.Errorf("Websocket HandleRpcMethod failed: handler for method %v exists", method))
+	}
+
+	async := false
+	if len(args) > 0 {
+		if a, ok := args[0].(bool); ok {
+			async = a
+		}
+	}
+
+	if async {
+		engine.rpcMethodHandlers[method] = func(ctx *RpcContext) {
+			util.Go(func() {
+				ctx.call(handler)
+			})
+		}
+	} else {
+		engine.rpcMethodHandlers[method] = func(ctx *RpcContext) {
+			ctx.call(handler)
+		}
+	}
+
+	log.Debug("Websocket HandleRpcMethod: %v", method)
+}
+
 // setting receive message handler
 func (engine *WSEngine) HandleRecv(recver func(cli *WSClient) IMessage) {
 	engine.recvHandler = recver
@@ -160,6 +282,7 @@ func (engine *WSEngine) HandleSend(sender func(cli *WSClient, data []byte) error
 
 // handle send queue full
 func (engine *WSEngine) OnSendQueueFull(cli *WSClient, msg interface{}) {
+	metricSendQueueDropped.With(metricProtoWS).Inc()
 	if engine.sendQueueFullHandler != nil {
 		engine.sendQueueFullHandler(cli, msg)
 	}
@@ -198,11 +321,31 @@ func (engine *WSEngine) onMessage(cli *WSClient, msg IMessage) {
 		return
 	}
 
+	traceExtract(msg)
+
+	_, handled := engine.handlers[msg.Cmd()]
+	metricRecv(metricProtoWS, metricCmdLabel(msg.Cmd(), handled), msg)
+	engine.capture.record(cli, metricProtoWS, CaptureIn, msg)
+
+	if engine.rateLimiter != nil {
+		if ok, action := engine.rateLimiter.Check(cli, cli.Ip(), msg); !ok {
+			if action == RateLimitDisconnect {
+				cli.Stop()
+			}
+			return
+		}
+	}
+
 	if engine.messageHandler != nil {
 		engine.messageHandler(cli, msg)
 		return
 	}
 
+	engine.defaultOnMessage(cli, msg)
+}
+
+// handle message by cmd
+func (engine *WSEngine) defaultOnMessage(cli *WSClient, msg IMessage) {
 	cmd := msg.Cmd()
 	if cmd == CmdPing {
 		cli.SendMsg(NewMessage(CmdPing2, nil))
@@ -213,15 +356,112 @@ func (engine *WSEngine) onMessage(cli *WSClient, msg IMessage) {
 	}
 
 	if h, ok := engine.handlers[cmd]; ok {
-		engine.Add(1)
-		defer engine.Done()
-		defer util.HandlePanic()
-		h(cli, msg)
+		engine.dispatch(cmd, cli, msg, func() {
+			h(cli, msg)
+		})
 	} else {
 		log.Debug("Websocket no handler for cmd: %v", cmd)
 	}
 }
 
+// dispatch handler by cmd
+func (engine *WSEngine) dispatch(tag interface{}, cli *WSClient, msg IMessage, h func()) {
+	engine.Add(1)
+	task := func() {
+		defer engine.Done()
+		defer util.HandlePanic()
+		defer metricHandlerDuration.With(metricProtoWS, metricHandlerLabel(tag)).Since(time.Now())
+		h()
+	}
+
+	if engine.dispatcher == nil {
+		task()
+		return
+	}
+
+	if err := engine.dispatcher.Dispatch(tag, cli, msg, task); err != nil {
+		engine.Done()
+		log.Debug("Websocket dispatch %v failed: %v, ip: %v", tag, err, cli.Ip())
+	}
+}
+
+// handler dispatcher
+func (engine *WSEngine) Dispatcher() *Dispatcher {
+	return engine.dispatcher
+}
+
+// setting handler dispatch mode by cmd, DispatchKeyed should be set by SetDispatchKey
+func (engine *WSEngine) SetDispatchMode(cmd uint32, mode DispatchMode) error {
+	return engine.dispatcher.SetMode(cmd, mode)
+}
+
+// setting keyed handler dispatch by cmd, handlers with the same key run in order
+func (engine *WSEngine) SetDispatchKey(cmd uint32, key func(cli *WSClient, msg IMessage) interface{}) {
+	engine.dispatcher.SetKey(cmd, func(sess interface{}, msg IMessage) interface{} {
+		return key(sess.(*WSClient), msg)
+	})
+}
+
+// setting handler dispatch mode by rpc method, DispatchKeyed should be set by SetRpcMethodDispatchKey
+func (engine *WSEngine) SetRpcMethodDispatchMode(method string, mode DispatchMode) error {
+	return engine.dispatcher.SetMode(method, mode)
+}
+
+// setting keyed handler dispatch by rpc method, handlers with the same key run in order
+func (engine *WSEngine) SetRpcMethodDispatchKey(method string, key func(ctx *RpcContext) interface{}) {
+	engine.dispatcher.SetKey(method, func(sess interface{}, msg IMessage) interface{} {
+		return key(newRpcContext(method, sess.(*WSClient), msg))
+	})
+}
+
+// setting shared worker pool for DispatchPool, timeout 0 means block until pushed
+func (engine *WSEngine) SetWorkerPool(pool *util.WorkerPool, timeout time.Duration) {
+	engine.dispatcher.SetWorkerPool(pool, timeout)
+}
+
+// inbound message rate limiter
+func (engine *WSEngine) RateLimiter() *RateLimiter {
+	return engine.rateLimiter
+}
+
+// setting inbound message rate limiter, nil to disable
+func (engine *WSEngine) SetRateLimiter(limiter *RateLimiter) {
+	engine.rateLimiter = limiter
+}
+
+// setting capture of inbound and outbound messages, nil to disable
+func (engine *WSEngine) SetCapture(c *Capture) {
+	engine.capture = c
+}
+
+// new engine of same config and handlers, without locks and state of engine
+func (engine *WSEngine) clone() *WSEngine {
+	return &WSEngine{
+		Codec:        engine.Codec,
+		ReadTimeout:  engine.ReadTimeout,
+		WriteTimeout: engine.WriteTimeout,
+		ReadLimit:    engine.ReadLimit,
+		SendQSize:    engine.SendQSize,
+		MessageType:  engine.MessageType,
+
+		compression:          engine.compression,
+		compressionThreshold: engine.compressionThreshold,
+		compressionLevel:     engine.compressionLevel,
+
+		cipher:               engine.cipher,
+		handlers:             engine.handlers,
+		rpcMethodHandlers:    engine.rpcMethodHandlers,
+		dispatcher:           engine.dispatcher,
+		rateLimiter:          engine.rateLimiter,
+		capture:              engine.capture,
+		messageHandler:       engine.messageHandler,
+		recvHandler:          engine.recvHandler,
+		sendHandler:          engine.sendHandler,
+		sendQueueFullHandler: engine.sendQueueFullHandler,
+		newCipherHandler:     engine.newCipherHandler,
+	}
+}
+
 // websocket engine factory
 func NewWebsocketEngine() *WSEngine {
 	engine := &WSEngine{
@@ -231,7 +471,12 @@ func NewWebsocketEngine() *WSEngine {
 		ReadLimit:    DefaultReadLimit,
 		SendQSize:    DefaultSendQSize,
 		MessageType:  websocket.TextMessage,
-		shutdown:     false,
+
+		compressionThreshold: DefaultWSCompressionThreshold,
+		compressionLevel:     DefaultWSCompressionLevel,
+
+		shutdown:   false,
+		dispatcher: NewDispatcher(),
 		handlers: map[uint32]func(*WSClient, IMessage){
 			CmdSetReaIp: func(cli *WSClient, msg IMessage) {
 				ip := msg.Body()
@@ -0,0 +1,83 @@
+package net
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/json-iterator/go"
+)
+
+const (
+	// websocket subprotocol: kiss frames in binary messages
+	WSSubprotocolBin = "kiss.bin"
+	// websocket subprotocol: kiss frames as json envelopes in text messages
+	WSSubprotocolJson = "kiss.json"
+)
+
+// json envelope of kiss.json subprotocol, body is json if it's valid json, or base64 in bin
+type WSJsonFrame struct {
+	Cmd  uint32              `json:"cmd"`
+	Ext  int64               `json:"ext,omitempty"`
+	Body jsoniter.RawMessage `json:"body,omitempty"`
+	Bin  []byte              `json:"bin,omitempty"`
+}
+
+// encode kiss frame to json envelope
+func wsJsonEncode(data []byte) ([]byte, error) {
+	if len(data) < DEFAULT_MESSAGE_HEAD_LEN {
+		return nil, ErrorRpcInvalidMessageHeadLen
+	}
+	msg := RawMessage(data)
+	frame := &WSJsonFrame{
+		Cmd: msg.Cmd(),
+		Ext: msg.Ext(),
+	}
+	if body := msg.Body(); len(body) > 0 {
+		if json.Valid(body) {
+			frame.Body = body
+		} else {
+			frame.Bin = body
+		}
+	}
+	return json.Marshal(frame)
+}
+
+// decode json envelope to kiss frame
+func wsJsonDecode(data []byte) ([]byte, error) {
+	frame := &WSJsonFrame{}
+	if err := json.Unmarshal(data, frame); err != nil {
+		return nil, err
+	}
+	body := []byte(frame.Body)
+	if len(frame.Bin) > 0 {
+		body = frame.Bin
+	}
+	msg := NewRpcMessage(frame.Cmd, frame.Ext, body)
+	return msg.data, nil
+}
+
+// websocket message type and cipher of negotiated subprotocol
+func (cli *WSClient) initSubprotocol() {
+	cli.subprotocol = cli.Conn.Subprotocol()
+	switch cli.subprotocol {
+	case WSSubprotocolBin:
+		cli.frameType = websocket.BinaryMessage
+	case WSSubprotocolJson:
+		// browser clients read json bodies directly, no kiss layer compression
+		cli.frameType = websocket.TextMessage
+		cli.cipher = nil
+	default:
+		cli.frameType = 0
+	}
+}
+
+// negotiated subprotocol
+func (cli *WSClient) Subprotocol() string {
+	return cli.subprotocol
+}
+
+// websocket message type
+func (cli *WSClient) messageType() int {
+	if cli.frameType != 0 {
+		return cli.frameType
+	}
+	return cli.MessageType
+}
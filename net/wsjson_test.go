@@ -0,0 +1,62 @@
+package net
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketSubprotocol(t *testing.T) {
+	const cmdEcho = uint32(1)
+
+	server, err := NewWebsocketServer("subprotocol", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.HandleWs("/ws")
+	server.Handle(cmdEcho, func(cli *WSClient, msg IMessage) {
+		cli.SendMsg(NewRpcMessage(cmdEcho, msg.Ext(), msg.Body()))
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	addr := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws"
+
+	dial := func(subprotocol string) *websocket.Conn {
+		dialer := &websocket.Dialer{Subprotocols: []string{subprotocol}}
+		conn, _, err := dialer.Dial(addr, nil)
+		if err != nil {
+			t.Fatalf("Dial %v failed: %v", subprotocol, err)
+		}
+		if conn.Subprotocol() != subprotocol {
+			t.Fatalf("Subprotocol should be %v, got %v", subprotocol, conn.Subprotocol())
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+		return conn
+	}
+
+	jsonConn := dial(WSSubprotocolJson)
+	defer jsonConn.Close()
+	for _, req := range []string{
+		`{"cmd":1,"ext":7,"body":{"name":"kiss"}}`,
+		`{"cmd":1,"ext":8,"bin":"AAEC"}`,
+	} {
+		jsonConn.WriteMessage(websocket.TextMessage, []byte(req))
+		mt, data, err := jsonConn.ReadMessage()
+		if err != nil || mt != websocket.TextMessage || string(data) != req {
+			t.Fatalf("json echo failed: %v, %v, %s", err, mt, data)
+		}
+	}
+
+	binConn := dial(WSSubprotocolBin)
+	defer binConn.Close()
+	binConn.WriteMessage(websocket.BinaryMessage, NewMessage(cmdEcho, []byte("kiss")).Data())
+	mt, data, err := binConn.ReadMessage()
+	if err != nil || mt != websocket.BinaryMessage {
+		t.Fatalf("binary echo failed: %v, %v", err, mt)
+	}
+	if msg := RawMessage(data); msg.Cmd() != cmdEcho || string(msg.Body()) != "kiss" {
+		t.Fatalf("binary echo mismatch: %v, %s", msg.Cmd(), msg.Body())
+	}
+}
@@ -0,0 +1,528 @@
+package net
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/json-iterator/go"
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/util"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// http fallback session of websocket route, messages sent by handlers are queued until polled
+type wsPollSession struct {
+	sync.Mutex
+
+	// session id
+	sid string
+
+	// client used by websocket engine handlers
+	cli *WSClient
+
+	// ip of the open request
+	remoteIp string
+
+	// frames are json envelopes of kiss.json if true
+	json bool
+
+	// frames waiting for poll or sse, kept until acked by polls with ack
+	queue [][]byte
+
+	// seq of queue[0], frames are numbered from 1
+	headSeq uint64
+
+	// max queued frames
+	qsize int
+
+	// notified when frames pushed
+	notify chan struct{}
+
+	// closed when session closed
+	done chan struct{}
+
+	// messages of a session are handled in order, as websocket readloop does
+	recvMtx sync.Mutex
+
+	// number of poll and sse requests attached
+	attached int
+
+	// close session when idle without poll or sse attached
+	idleTimer *time.Timer
+}
+
+// push encrypted message data to queue
+func (p *wsPollSession) push(data []byte) error {
+	if p.json {
+		var err error
+		if data, err = wsJsonEncode(data); err != nil {
+			return err
+		}
+	}
+
+	p.Lock()
+	if len(p.queue) >= p.qsize {
+		p.Unlock()
+		return ErrWSPollQueueIsFull
+	}
+	p.queue = append(p.queue, data)
+	p.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pop all queued frames, delivered at most once
+func (p *wsPollSession) pop() [][]byte {
+	p.Lock()
+	frames := p.queue
+	p.headSeq += uint64(len(frames))
+	p.queue = nil
+	p.Unlock()
+	return frames
+}
+
+// remove frames of seq <= ack, return frames left and seq of the last one, delivered at least once
+func (p *wsPollSession) unacked(ack uint64) ([][]byte, uint64) {
+	p.Lock()
+	defer p.Unlock()
+	if ack >= p.headSeq {
+		n := ack - p.headSeq + 1
+		if n > uint64(len(p.queue)) {
+			n = uint64(len(p.queue))
+		}
+		for i := uint64(0); i < n; i++ {
+			p.queue[i] = nil
+		}
+		p.queue = p.queue[n:]
+		p.headSeq += n
+	}
+	frames := make([][]byte, len(p.queue))
+	copy(frames, p.queue)
+	return frames, p.headSeq + uint64(len(frames)) - 1
+}
+
+// close session
+func (p *wsPollSession) close() {
+	p.Lock()
+	p.idleTimer.Stop()
+	p.Unlock()
+	close(p.done)
+}
+
+// attach poll or sse request, session won't be closed by idle timer while attached
+func (p *wsPollSession) attach() {
+	p.Lock()
+	p.attached++
+	p.idleTimer.Stop()
+	p.Unlock()
+}
+
+// detach poll or sse request
+func (p *wsPollSession) detach(idle time.Duration) {
+	p.Lock()
+	p.attached--
+	if p.attached == 0 {
+		p.idleTimer.Reset(idle)
+	}
+	p.Unlock()
+}
+
+// reset idle timer
+func (p *wsPollSession) touch(idle time.Duration) {
+	p.Lock()
+	if p.attached == 0 {
+		p.idleTimer.Reset(idle)
+	}
+	p.Unlock()
+}
+
+// split concatenated kiss frames of binary body
+func splitFrames(data []byte) ([][]byte, error) {
+	frames := [][]byte{}
+	for len(data) > 0 {
+		if len(data) < DEFAULT_MESSAGE_HEAD_LEN {
+			return nil, ErrorRpcInvalidMessageHeadLen
+		}
+		frameLen := DEFAULT_MESSAGE_HEAD_LEN + int(binary.LittleEndian.Uint32(data[:4]))
+		if frameLen < DEFAULT_MESSAGE_HEAD_LEN || frameLen > len(data) {
+			return nil, fmt.Errorf("invalid frame length %d, left %d", frameLen, len(data))
+		}
+		frames = append(frames, data[:frameLen])
+		data = data[frameLen:]
+	}
+	return frames, nil
+}
+
+// split json frames of json body, a frame or an array of frames
+func splitJsonFrames(data []byte) ([][]byte, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		raws := []jsoniter.RawMessage{}
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return nil, err
+		}
+		frames := make([][]byte, len(raws))
+		for i, raw := range raws {
+			frames[i] = raw
+		}
+		return frames, nil
+	}
+	return [][]byte{data}, nil
+}
+
+// response header of long-poll with ack, seq of the last frame
+const wsPollSeqHeader = "X-Kiss-Seq"
+
+// close handler tag of http fallback session
+type wsPollCloseTag struct{}
+
+// websocket http fallback of route
+type wsPoll struct {
+	server *WSServer
+	route  *wsRoute
+
+	mtx      sync.Mutex
+	sessions map[string]*wsPollSession
+
+	// long-poll wait time
+	pollTimeout time.Duration
+
+	// idle time before session closed
+	sessionTimeout time.Duration
+}
+
+// get session by sid of request
+func (fb *wsPoll) session(w http.ResponseWriter, r *http.Request) (*wsPollSession, bool) {
+	sid := r.URL.Query().Get("sid")
+	fb.mtx.Lock()
+	p, ok := fb.sessions[sid]
+	fb.mtx.Unlock()
+	if !ok {
+		http.Error(w, "session not found", http.StatusGone)
+	}
+	return p, ok
+}
+
+// open session: POST {path}/open?format=bin|json, response: {"sid":"...","format":"..."}
+func (fb *wsPoll) onOpen(w http.ResponseWriter, r *http.Request) {
+	defer util.HandlePanic()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := WSSubprotocolBin
+	if f := r.URL.Query().Get("format"); f == "json" || f == WSSubprotocolJson {
+		format = WSSubprotocolJson
+	}
+
+	s := fb.server
+	a, ok := s.admit(fb.route, w, r)
+	if !ok {
+		return
+	}
+
+	sid := make([]byte, 16)
+	if _, err := rand.Read(sid); err != nil {
+		s.release(a)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	engine := fb.route.engine
+	cli := newPollClient(engine, a.remoteIp, format)
+	p := cli.poll
+	p.sid = hex.EncodeToString(sid)
+	p.idleTimer = time.AfterFunc(fb.sessionTimeout, cli.Stop)
+	s.addClient(cli, a)
+
+	fb.mtx.Lock()
+	fb.sessions[p.sid] = p
+	fb.mtx.Unlock()
+
+	cli.OnClose(wsPollCloseTag{}, func(*WSClient) {
+		util.Go(func() {
+			fb.mtx.Lock()
+			delete(fb.sessions, p.sid)
+			fb.mtx.Unlock()
+
+			cli.loopWg.Wait()
+			s.deleteClient(cli, a)
+
+			if s.disconnectHandler != nil {
+				s.disconnectHandler(cli, wsNopResponseWriter{}, r)
+			}
+		})
+	})
+
+	if s.connectHandler != nil && s.connectHandler(cli, w, r) != nil {
+		cli.Stop()
+		return
+	}
+
+	cli.loopWg.Add(1)
+	util.Go(cli.writeloop)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	data, _ := json.Marshal(map[string]string{"sid": p.sid, "format": format})
+	w.Write(data)
+}
+
+// send messages: POST {path}/send?sid=, body is concatenated kiss frames, or json frames for json format
+func (fb *wsPoll) onSend(w http.ResponseWriter, r *http.Request) {
+	defer util.HandlePanic()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, ok := fb.session(w, r)
+	if !ok {
+		return
+	}
+	p.touch(fb.sessionTimeout)
+
+	cli := p.cli
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, cli.ReadLimit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var frames [][]byte
+	if p.json {
+		frames, err = splitJsonFrames(body)
+	} else {
+		frames, err = splitFrames(body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.recvMtx.Lock()
+	defer p.recvMtx.Unlock()
+	for _, data := range frames {
+		msg, err := cli.WSEngine.decodeMsg(cli, data)
+		if err != nil {
+			log.Debug("[WSServer] poll session %v decode failed: %v", p.sid, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			cli.Stop()
+			return
+		}
+		atomic.AddInt64(&cli.recvSeq, 1)
+		cli.WSEngine.onMessage(cli, msg)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// long-poll: GET {path}/poll?sid=&ack=, response is concatenated kiss frames, or a json array for json format.
+// 204 if no message in poll timeout, 410 if session closed.
+// without ack, frames are removed once written, and lost if the response is lost. with ack, the seq of the last
+// frame is returned in header X-Kiss-Seq, frames are kept and sent again until a poll with ack >= their seq
+func (fb *wsPoll) onPoll(w http.ResponseWriter, r *http.Request) {
+	defer util.HandlePanic()
+
+	p, ok := fb.session(w, r)
+	if !ok {
+		return
+	}
+
+	withAck := false
+	ack := uint64(0)
+	if v := r.URL.Query().Get("ack"); v != "" {
+		var err error
+		if ack, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "invalid ack", http.StatusBadRequest)
+			return
+		}
+		withAck = true
+	}
+
+	p.attach()
+	defer p.detach(fb.sessionTimeout)
+
+	after := time.NewTimer(fb.pollTimeout)
+	defer after.Stop()
+
+	for {
+		var frames [][]byte
+		if withAck {
+			var last uint64
+			if frames, last = p.unacked(ack); len(frames) > 0 {
+				w.Header().Set(wsPollSeqHeader, strconv.FormatUint(last, 10))
+			}
+		} else {
+			frames = p.pop()
+		}
+		if len(frames) > 0 {
+			w.Header().Set("Cache-Control", "no-store")
+			if p.json {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte{'['})
+				w.Write(bytes.Join(frames, []byte{','}))
+				w.Write([]byte{']'})
+			} else {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(bytes.Join(frames, nil))
+			}
+			return
+		}
+
+		select {
+		case <-p.notify:
+		case <-p.done:
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		case <-after.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// server-sent events: GET {path}/sse?sid=, each message is an event, data is json frame for json format,
+// or base64 of kiss frame. event "close" is sent when session closed. frames are delivered at most once, messages
+// written to a broken stream are lost
+func (fb *wsPoll) onSse(w http.ResponseWriter, r *http.Request) {
+	defer util.HandlePanic()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	p, ok := fb.session(w, r)
+	if !ok {
+		return
+	}
+	p.attach()
+	defer p.detach(fb.sessionTimeout)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(fb.pollTimeout)
+	defer ticker.Stop()
+
+	for {
+		if frames := p.pop(); len(frames) > 0 {
+			for _, data := range frames {
+				w.Write([]byte("data: "))
+				if p.json {
+					w.Write(data)
+				} else {
+					w.Write([]byte(base64.StdEncoding.EncodeToString(data)))
+				}
+				w.Write([]byte("\n\n"))
+			}
+			flusher.Flush()
+		}
+
+		select {
+		case <-p.notify:
+		case <-p.done:
+			w.Write([]byte("event: close\ndata:\n\n"))
+			flusher.Flush()
+			return
+		case <-ticker.C:
+			w.Write([]byte(": ping\n\n"))
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// close session: POST {path}/close?sid=
+func (fb *wsPoll) onClose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if p, ok := fb.session(w, r); ok {
+		p.cli.Stop()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// response writer for disconnect handler of http fallback session
+type wsNopResponseWriter struct{}
+
+func (wsNopResponseWriter) Header() http.Header         { return http.Header{} }
+func (wsNopResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (wsNopResponseWriter) WriteHeader(int)             {}
+
+// http fallback client, used by websocket engine handlers as a websocket client
+func newPollClient(engine *WSEngine, remoteIp string, format string) *WSClient {
+	sendQSize := DefaultSendQSize
+	if engine.SendQSize > 0 {
+		sendQSize = engine.SendQSize
+	}
+
+	cli := &WSClient{
+		WSEngine:    engine,
+		chSend:      make(chan wsAsyncMessage, sendQSize),
+		running:     true,
+		cipher:      engine.NewCipher(),
+		onCloseMap:  map[interface{}]func(*WSClient){},
+		realIp:      remoteIp,
+		subprotocol: format,
+	}
+	if format == WSSubprotocolJson {
+		cli.cipher = nil
+	}
+	cli.poll = &wsPollSession{
+		cli:      cli,
+		remoteIp: remoteIp,
+		json:     format == WSSubprotocolJson,
+		qsize:    sendQSize,
+		headSeq:  1,
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	return cli
+}
+
+// setting http fallback of websocket route registered by HandleWs or HandleWsWithEngine, for networks
+// blocking websocket. routes: POST path/open, POST path/send, GET path/poll, GET path/sse, POST path/close
+func (s *WSServer) HandleWsFallback(path string) {
+	route, ok := s.routes[path]
+	if !ok {
+		panic(fmt.Errorf("WSServer HandleWsFallback failed: websocket route %v not exists", path))
+	}
+
+	fb := &wsPoll{
+		server:         s,
+		route:          route,
+		sessions:       map[string]*wsPollSession{},
+		pollTimeout:    DefaultWSPollTimeout,
+		sessionTimeout: DefaultWSPollSessionTimeout,
+	}
+	s.wsRoutes[path+"/open"] = fb.onOpen
+	s.wsRoutes[path+"/send"] = fb.onSend
+	s.wsRoutes[path+"/poll"] = fb.onPoll
+	s.wsRoutes[path+"/sse"] = fb.onSse
+	s.wsRoutes[path+"/close"] = fb.onClose
+}
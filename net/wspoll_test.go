@@ -0,0 +1,163 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketFallback(t *testing.T) {
+	const cmdEcho = uint32(1)
+
+	server, err := NewWebsocketServer("fallback", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.HandleWs("/ws")
+	server.HandleWsFallback("/ws")
+	server.Handle(cmdEcho, func(cli *WSClient, msg IMessage) {
+		cli.SendMsg(NewRpcMessage(cmdEcho, msg.Ext(), msg.Body()))
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	base := httpServer.URL + "/ws"
+
+	open := func(format string) string {
+		rsp, err := http.Post(base+"/open?format="+format, "", nil)
+		if err != nil || rsp.StatusCode != http.StatusOK {
+			t.Fatalf("open failed: %v, %v", err, rsp)
+		}
+		defer rsp.Body.Close()
+		ret := map[string]string{}
+		if err = json.NewDecoder(rsp.Body).Decode(&ret); err != nil || ret["sid"] == "" {
+			t.Fatalf("open response invalid: %v, %v", err, ret)
+		}
+		return ret["sid"]
+	}
+	send := func(sid string, body []byte) {
+		rsp, err := http.Post(base+"/send?sid="+sid, "", bytes.NewReader(body))
+		if err != nil || rsp.StatusCode != http.StatusNoContent {
+			t.Fatalf("send failed: %v, %v", err, rsp)
+		}
+		rsp.Body.Close()
+	}
+
+	// long-poll with binary frames
+	sid := open("bin")
+	body := append(NewRpcMessage(cmdEcho, 1, []byte("a")).Data(), NewRpcMessage(cmdEcho, 2, []byte("b")).Data()...)
+	send(sid, body)
+	received := []byte{}
+	for i := 0; i < 10 && len(received) < len(body); i++ {
+		rsp, err := http.Get(base + "/poll?sid=" + sid)
+		if err != nil {
+			t.Fatalf("poll failed: %v", err)
+		}
+		data, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		received = append(received, data...)
+	}
+	frames, err := splitFrames(received)
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("poll should receive 2 frames, got %v, %v", len(frames), err)
+	}
+	for i, data := range frames {
+		if msg := RawMessage(data); msg.Ext() != int64(i+1) || string(msg.Body()) != string([]byte{'a' + byte(i)}) {
+			t.Fatalf("frame %v mismatch: %v, %s", i, msg.Ext(), msg.Body())
+		}
+	}
+	if server.CurrLoad() != 1 || server.RouteLoad("/ws") != 1 {
+		t.Fatalf("load should be 1, got %v", server.CurrLoad())
+	}
+	rsp, err := http.Post(base+"/close?sid="+sid, "", nil)
+	if err != nil || rsp.StatusCode != http.StatusNoContent {
+		t.Fatalf("close failed: %v, %v", err, rsp)
+	}
+	rsp.Body.Close()
+	if rsp, _ = http.Get(base + "/poll?sid=" + sid); rsp.StatusCode != http.StatusGone {
+		t.Fatalf("poll closed session should be 410, got %v", rsp.StatusCode)
+	}
+
+	// long-poll with ack, frames are sent again until acked
+	sid = open("bin")
+	poll := func(ack string) (int64, string) {
+		rsp, err := http.Get(base + "/poll?sid=" + sid + "&ack=" + ack)
+		if err != nil || rsp.StatusCode != http.StatusOK {
+			t.Fatalf("poll with ack failed: %v, %v", err, rsp)
+		}
+		defer rsp.Body.Close()
+		data, _ := ioutil.ReadAll(rsp.Body)
+		frames, err := splitFrames(data)
+		if err != nil || len(frames) != 1 {
+			t.Fatalf("poll with ack should receive 1 frame, got %v, %v", len(frames), err)
+		}
+		return RawMessage(frames[0]).Ext(), rsp.Header.Get("X-Kiss-Seq")
+	}
+	send(sid, NewRpcMessage(cmdEcho, 1, []byte("a")).Data())
+	for i := 0; i < 2; i++ {
+		if ext, seq := poll("0"); ext != 1 || seq != "1" {
+			t.Fatalf("unacked frame should be sent again, got ext %v, seq %v", ext, seq)
+		}
+	}
+	send(sid, NewRpcMessage(cmdEcho, 2, []byte("b")).Data())
+	if ext, seq := poll("1"); ext != 2 || seq != "2" {
+		t.Fatalf("acked frame should be removed, got ext %v, seq %v", ext, seq)
+	}
+	if rsp, _ = http.Get(base + "/poll?sid=" + sid + "&ack=x"); rsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("poll with invalid ack should be 400, got %v", rsp.StatusCode)
+	}
+	rsp.Body.Close()
+	if rsp, err = http.Post(base+"/close?sid="+sid, "", nil); err == nil {
+		rsp.Body.Close()
+	}
+
+	// sse with json frames
+	sid = open("json")
+	rsp, err = http.Get(base + "/sse?sid=" + sid)
+	if err != nil || rsp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("sse failed: %v, %v", err, rsp)
+	}
+	defer rsp.Body.Close()
+	events := make(chan string, 4)
+	go func() {
+		scanner := bufio.NewScanner(rsp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				events <- strings.TrimPrefix(line, "data: ")
+			} else if line == "event: close" {
+				events <- line
+			}
+		}
+	}()
+
+	req := `{"cmd":1,"ext":3,"body":{"name":"kiss"}}`
+	send(sid, []byte("["+req+"]"))
+	select {
+	case ev := <-events:
+		if ev != req {
+			t.Fatalf("sse event mismatch: %v", ev)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("sse timeout")
+	}
+
+	server.stopClients()
+	select {
+	case ev := <-events:
+		if ev != "event: close" {
+			t.Fatalf("sse should be closed, got %v", ev)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("sse close timeout")
+	}
+	for i := 0; i < 100 && server.CurrLoad() != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if server.CurrLoad() != 0 || server.ClientNum() != 0 {
+		t.Fatalf("load should be 0 after closed, got %v, %v", server.CurrLoad(), server.ClientNum())
+	}
+}
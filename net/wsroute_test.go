@@ -0,0 +1,82 @@
+package net
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketRouteEngine(t *testing.T) {
+	const cmdWhich = uint32(1)
+
+	server, err := NewWebsocketServer("route", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.HandleWs("/game")
+	server.Handle(cmdWhich, func(cli *WSClient, msg IMessage) {
+		cli.SendMsg(NewMessage(cmdWhich, []byte("game")))
+	})
+
+	chat := NewWebsocketEngine()
+	chat.HandleNewCipher(func() ICipher { return nil })
+	chat.Handle(cmdWhich, func(cli *WSClient, msg IMessage) {
+		if cli.Cipher() != nil {
+			t.Errorf("chat route should use its own cipher")
+		}
+		cli.SendMsg(NewMessage(cmdWhich, []byte("chat")))
+	})
+	server.HandleWsWithEngine("/chat", chat)
+	server.SetRouteMaxConcurrent("/chat", 1)
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	base := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	which := func(path string) (*websocket.Conn, string) {
+		conn, _, err := websocket.DefaultDialer.Dial(base+path, nil)
+		if err != nil {
+			return nil, ""
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+		conn.WriteMessage(websocket.TextMessage, NewMessage(cmdWhich, nil).Data())
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("%v ReadMessage failed: %v", path, err)
+		}
+		return conn, string(RawMessage(data).Body())
+	}
+
+	gameConn, name := which("/game")
+	if name != "game" {
+		t.Fatalf("/game should be served by server engine, got %v", name)
+	}
+	defer gameConn.Close()
+
+	chatConn, name := which("/chat")
+	if name != "chat" {
+		t.Fatalf("/chat should be served by chat engine, got %v", name)
+	}
+
+	if server.RouteLoad("/game") != 1 || server.RouteLoad("/chat") != 1 || server.CurrLoad() != 2 {
+		t.Fatalf("load mismatch: %v, %v, %v", server.RouteLoad("/game"), server.RouteLoad("/chat"), server.CurrLoad())
+	}
+	if server.RouteLoad("/none") != -1 {
+		t.Fatalf("load of unknown route should be -1")
+	}
+
+	if conn, _ := which("/chat"); conn != nil {
+		conn.Close()
+		t.Fatalf("/chat should refuse when overloaded")
+	}
+
+	chatConn.Close()
+	for i := 0; i < 100 && server.RouteLoad("/chat") != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if server.RouteLoad("/chat") != 0 {
+		t.Fatalf("/chat load should be 0 after closed, got %v", server.RouteLoad("/chat"))
+	}
+}
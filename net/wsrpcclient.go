@@ -0,0 +1,249 @@
+package net
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/trace"
+	"github.com/nothollyhigh/kiss/util"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// websocket rpc client
+type WSRpcClient struct {
+	*WSClient
+
+	// sessions lock, WSClient's lock is held while running close handlers
+	mtx        sync.Mutex
+	sessionMap map[int64]*rpcsession
+	codec      ICodec
+	notify     func(msg IMessage)
+}
+
+// add rpc session
+func (client *WSRpcClient) addSession(session *rpcsession) {
+	client.mtx.Lock()
+	client.sessionMap[session.seq] = session
+	client.mtx.Unlock()
+}
+
+// remove rpc session
+func (client *WSRpcClient) removeSession(seq int64) {
+	client.mtx.Lock()
+	delete(client.sessionMap, seq)
+	client.mtx.Unlock()
+}
+
+// get rpc session
+func (client *WSRpcClient) getSession(seq int64) (*rpcsession, bool) {
+	client.mtx.Lock()
+	defer client.mtx.Unlock()
+	session, ok := client.sessionMap[seq]
+	return session, ok
+}
+
+// close all rpc sessions
+func (client *WSRpcClient) closeSessions() {
+	client.mtx.Lock()
+	defer client.mtx.Unlock()
+	for _, session := range client.sessionMap {
+		close(session.done)
+	}
+	client.sessionMap = map[int64]*rpcsession{}
+}
+
+// call cmd, wait until response if after is nil, fails at once if send queue is full
+func (client *WSRpcClient) callCmdWithTimer(cmd uint32, data []byte, after *time.Timer) ([]byte, error) {
+	var timeout <-chan time.Time
+	if after != nil {
+		timeout = after.C
+	}
+
+	client.Lock()
+	if !client.running {
+		client.Unlock()
+		return nil, ErrRpcClientIsDisconnected
+	}
+
+	session := &rpcsession{
+		seq:  atomic.AddInt64(&client.sendSeq, 1),
+		done: make(chan *RpcMessage, 1),
+	}
+	// registered before sent, the response may arrive before chSend returns
+	client.addSession(session)
+	msg := NewRpcMessage(cmd, session.seq, data)
+	captured := captureCopy(client.WSClient, msg)
+	select {
+	case client.chSend <- wsAsyncMessage{msg.Encrypt(client.SendSeq(), client.SendKey(), client.cipher), nil}:
+	default:
+		client.Unlock()
+		client.removeSession(session.seq)
+		client.OnSendQueueFull(client.WSClient, msg)
+		return nil, ErrWSClientSendQueueIsFull
+	}
+	client.Unlock()
+	captureSent(client.WSClient, captured)
+
+	defer client.removeSession(session.seq)
+	select {
+	case msg, ok := <-session.done:
+		if !ok {
+			return nil, ErrRpcClientIsDisconnected
+		}
+		return msg.msg.Body(), msg.err
+	case <-timeout:
+		return nil, ErrRpcCallTimeout
+	}
+}
+
+// codec
+func (client *WSRpcClient) Codec() ICodec {
+	return client.codec
+}
+
+// call by codec
+func (client *WSRpcClient) call(parent *trace.Span, cmd uint32, method string, req interface{}, rsp interface{}, after *time.Timer) error {
+	data, err := client.codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if method != "" {
+		data = appendRpcMethod(data, method)
+	}
+	span, cmd, data := traceRpcCall(metricProtoWS, cmd, method, data, parent)
+	begin := time.Now()
+	rspdata, err := client.callCmdWithTimer(cmd, data, after)
+	if method != "" {
+		metricRpcCall(method, begin, err)
+	}
+	traceRpcDone(span, err)
+	if err != nil {
+		return err
+	}
+	if rsp != nil {
+		err = client.codec.Unmarshal(rspdata, rsp)
+	}
+	return err
+}
+
+// setting handler of messages which are not rpc responses and have no handler, such as server notifications
+func (client *WSRpcClient) HandleNotify(h func(msg IMessage)) {
+	client.mtx.Lock()
+	client.notify = h
+	client.mtx.Unlock()
+}
+
+// call cmd
+func (client *WSRpcClient) CallCmd(cmd uint32, req interface{}, rsp interface{}) error {
+	return client.call(nil, cmd, "", req, rsp, nil)
+}
+
+// call cmd with timeout
+func (client *WSRpcClient) CallCmdWithTimeout(cmd uint32, req interface{}, rsp interface{}, timeout time.Duration) error {
+	after := time.NewTimer(timeout)
+	defer after.Stop()
+	return client.call(nil, cmd, "", req, rsp, after)
+}
+
+// rpc call
+func (client *WSRpcClient) Call(method string, req interface{}, rsp interface{}, timeout time.Duration) error {
+	after := time.NewTimer(timeout)
+	defer after.Stop()
+	return client.call(nil, CmdRpcMethod, method, req, rsp, after)
+}
+
+// rpc call in a child span of parent, see RpcClient.CallWithParent
+func (client *WSRpcClient) CallWithParent(parent *trace.Span, method string, req interface{}, rsp interface{}, timeout time.Duration) error {
+	after := time.NewTimer(timeout)
+	defer after.Stop()
+	return client.call(parent, CmdRpcMethod, method, req, rsp, after)
+}
+
+// rpc call
+func (client *WSRpcClient) CallWithTimer(method string, req interface{}, rsp interface{}, after *time.Timer) error {
+	return client.call(nil, CmdRpcMethod, method, req, rsp, after)
+}
+
+// on message
+func (client *WSRpcClient) onMessage(cli *WSClient, msg IMessage) {
+	switch msg.Cmd() {
+	case CmdPing2:
+	case CmdRpcMethod:
+		if session, ok := client.getSession(msg.Ext()); ok {
+			session.done <- &RpcMessage{msg, nil}
+		} else {
+			log.Debug("Websocket no rpcsession waiting for rpc response seq: %v", msg.Ext())
+		}
+	case CmdRpcError:
+		if session, ok := client.getSession(msg.Ext()); ok {
+			session.done <- &RpcMessage{msg, errors.New(string(msg.Body()))}
+		} else {
+			log.Debug("Websocket no rpcsession waiting for rpc response, cmd %v, ip: %v", msg.Cmd(), cli.Ip())
+		}
+	default:
+		// response of CallCmd has the same cmd as request
+		if msg.Ext() != 0 {
+			if session, ok := client.getSession(msg.Ext()); ok {
+				session.done <- &RpcMessage{msg, nil}
+				return
+			}
+		}
+		if _, ok := cli.WSEngine.handlers[msg.Cmd()]; !ok && msg.Cmd() != CmdPing {
+			client.mtx.Lock()
+			notify := client.notify
+			client.mtx.Unlock()
+			if notify != nil {
+				notify(msg)
+				return
+			}
+		}
+		cli.WSEngine.defaultOnMessage(cli, msg)
+	}
+}
+
+// websocket rpc client factory, the client receives rpc responses by a copy of engine, whose message handler is
+// replaced, so engine can be shared by clients
+func NewWebsocketRpcClient(addr string, engine *WSEngine, codec ICodec) (*WSRpcClient, error) {
+	if engine == nil {
+		engine = NewWebsocketEngine()
+	}
+
+	// message handler is set on a copy, engine can be shared by clients
+	engine = engine.clone()
+
+	if codec == nil {
+		codec = DefaultCodec
+		log.Debug("use default rpc codec: %v", DefaultRpcCodecType)
+	}
+
+	dialer := &websocket.Dialer{}
+	dialer.TLSClientConfig = &tls.Config{}
+	dialer.EnableCompression = engine.compression
+	conn, rsp, err := dialer.Dial(addr, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rpcclient := &WSRpcClient{
+		WSClient:   newClient(conn, engine),
+		sessionMap: map[int64]*rpcsession{},
+		codec:      codec,
+	}
+	rpcclient.initCompression(wsDeflateInHeader(rsp.Header))
+
+	engine.HandleMessage(rpcclient.onMessage)
+
+	rpcclient.OnClose("-", func(*WSClient) {
+		rpcclient.closeSessions()
+	})
+
+	rpcclient.start()
+	util.Go(func() {
+		rpcclient.Keepalive(DefaultWSKeepaliveTime)
+	})
+
+	return rpcclient, nil
+}
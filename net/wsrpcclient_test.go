@@ -0,0 +1,78 @@
+package net
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketRpc(t *testing.T) {
+	const cmdAdd = uint32(1)
+
+	server, err := NewWebsocketServer("rpc", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewWebsocketServer failed: %v", err)
+	}
+	server.HandleWs("/ws")
+	server.HandleRpcMethod("Hello", func(ctx *RpcContext) {
+		req := ""
+		if err := ctx.Bind(&req); err != nil {
+			ctx.Error(err.Error())
+			return
+		}
+		if req == "" {
+			ctx.Error("empty name")
+			return
+		}
+		ctx.Write("hello " + req)
+	})
+	server.HandleRpcCmd(cmdAdd, func(ctx *RpcContext) {
+		req := []int{}
+		ctx.Bind(&req)
+		ctx.Write(req[0] + req[1])
+	}, false)
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	// clients sharing an engine receive their own responses
+	engine := NewWebsocketEngine()
+	client, err := NewWebsocketRpcClient("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", engine, nil)
+	if err != nil {
+		t.Fatalf("NewWebsocketRpcClient failed: %v", err)
+	}
+	defer client.Stop()
+	other, err := NewWebsocketRpcClient("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", engine, nil)
+	if err != nil {
+		t.Fatalf("NewWebsocketRpcClient failed: %v", err)
+	}
+	defer other.Stop()
+	if engine.messageHandler != nil {
+		t.Fatalf("message handler of shared engine should not be replaced")
+	}
+
+	rsp := ""
+	if err = client.Call("Hello", "kiss", &rsp, time.Second*3); err != nil || rsp != "hello kiss" {
+		t.Fatalf("Call failed: %v, %v", err, rsp)
+	}
+	if err = other.Call("Hello", "other", &rsp, time.Second*3); err != nil || rsp != "hello other" {
+		t.Fatalf("Call by other client failed: %v, %v", err, rsp)
+	}
+	if err = client.Call("Hello", "", &rsp, time.Second*3); err == nil || err.Error() != "empty name" {
+		t.Fatalf("Call should fail with empty name: %v", err)
+	}
+	if err = client.Call("NotExist", "", &rsp, time.Second*3); err == nil {
+		t.Fatalf("Call NotExist should fail")
+	}
+
+	sum := 0
+	if err = client.CallCmdWithTimeout(cmdAdd, []int{1, 2}, &sum, time.Second*3); err != nil || sum != 3 {
+		t.Fatalf("CallCmdWithTimeout failed: %v, %v", err, sum)
+	}
+
+	client.Stop()
+	if err = client.Call("Hello", "kiss", &rsp, time.Second); err != ErrRpcClientIsDisconnected {
+		t.Fatalf("Call after stop should fail: %v", err)
+	}
+}
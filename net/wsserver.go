@@ -1,6 +1,7 @@
 package net
 
 import (
+	"context"
 	"github.com/gorilla/websocket"
 	"github.com/nothollyhigh/kiss/log"
 	"github.com/nothollyhigh/kiss/util"
@@ -44,6 +45,21 @@ type WSServer struct {
 
 	// routers
 	wsRoutes map[string]func(http.ResponseWriter, *http.Request)
+
+	// websocket routes
+	routes map[string]*wsRoute
+
+	// ip filter
+	ipFilter *IpFilter
+
+	// proxies trusted to set forwarding headers
+	trustedProxies IpNets
+
+	// allowed origins, empty means all
+	allowedOrigins []string
+
+	// auth handler
+	authHandler func(r *http.Request, token string) (interface{}, error)
 }
 
 // serve http
@@ -66,18 +82,49 @@ func (s *WSServer) SetUpgrader(upgrader *websocket.Upgrader) {
 	s.upgrader = upgrader
 }
 
-// handle websocket request
-func (s *WSServer) onWebsocketRequest(w http.ResponseWriter, r *http.Request) {
-	defer util.HandlePanic()
+// websocket route with its own engine
+type wsRoute struct {
+	path   string
+	engine *WSEngine
+
+	// current load of route
+	currLoad int64
+
+	// max load of route, 0 means unlimited
+	maxLoad int64
+}
+
+// admitted request
+type wsAdmission struct {
+	route    *wsRoute
+	remoteIp string
+	realIp   string
+	limitIp  string
+	identity interface{}
+}
 
+// check origin, request handler, load, ip filter and auth, release must be called if admitted
+func (s *WSServer) admit(route *wsRoute, w http.ResponseWriter, r *http.Request) (*wsAdmission, bool) {
 	if s.shutdown {
 		http.NotFound(w, r)
-		return
+		return nil, false
 	}
 
-	if s.requestHandler != nil && s.requestHandler(w, r) != nil {
-		http.NotFound(w, r)
-		return
+	if !s.checkOrigin(r) {
+		log.Debug("[WSServer] refuse origin %v", r.Header.Get("Origin"))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, false
+	}
+
+	if s.requestHandler != nil {
+		if err := s.requestHandler(w, r); err != nil {
+			if e, ok := err.(*WSAuthError); ok {
+				http.Error(w, e.Reason, e.Status)
+			} else {
+				http.NotFound(w, r)
+			}
+			return nil, false
+		}
 	}
 
 	online := atomic.AddInt64(&s.currLoad, 1)
@@ -85,27 +132,111 @@ func (s *WSServer) onWebsocketRequest(w http.ResponseWriter, r *http.Request) {
 	if s.maxLoad > 0 && online > s.maxLoad {
 		atomic.AddInt64(&s.currLoad, -1)
 		http.NotFound(w, r)
-		return
+		return nil, false
 	}
 
-	conn, err := s.upgrader.Upgrade(w, r, nil)
-	if err != nil {
+	routeOnline := atomic.AddInt64(&route.currLoad, 1)
+	if route.maxLoad > 0 && routeOnline > route.maxLoad {
+		atomic.AddInt64(&route.currLoad, -1)
+		atomic.AddInt64(&s.currLoad, -1)
 		http.NotFound(w, r)
-		return
+		return nil, false
+	}
+
+	a := &wsAdmission{
+		route:    route,
+		remoteIp: hostIp(r.RemoteAddr),
+		realIp:   RealIpFromRequest(r, s.trustedProxies),
 	}
 
-	var cli = newClient(conn, s.WSEngine)
+	if s.ipFilter != nil {
+		ip := a.realIp
+		if ip != a.remoteIp || !s.ipFilter.IsTrustedProxy(ip) {
+			if err := s.ipFilter.Acquire(ip); err != nil {
+				s.release(a)
+				log.Debug("[WSServer] refuse %v: %v", ip, err)
+				http.Error(w, err.Error(), ipFilterHttpStatus(err))
+				return nil, false
+			}
+			a.limitIp = ip
+		}
+	}
+
+	if s.authHandler != nil {
+		identity, err := s.authHandler(r, WSTokenFromRequest(r))
+		if err != nil {
+			s.release(a)
+			status, reason := wsAuthStatus(err, http.StatusUnauthorized)
+			log.Debug("[WSServer] auth %v failed: %v, %v", a.realIp, status, reason)
+			http.Error(w, reason, status)
+			return nil, false
+		}
+		a.identity = identity
+	}
+
+	return a, true
+}
+
+// release load and ip filter of admitted request
+func (s *WSServer) release(a *wsAdmission) {
+	if a.limitIp != "" {
+		s.ipFilter.Release(a.limitIp)
+	}
+	atomic.AddInt64(&a.route.currLoad, -1)
+	atomic.AddInt64(&s.currLoad, -1)
+}
+
+// init admitted client and add it to server
+func (s *WSServer) addClient(cli *WSClient, a *wsAdmission) {
+	cli.identity = a.identity
+	cli.limitIp = a.limitIp
+	if a.realIp != a.remoteIp {
+		cli.realIp = a.realIp
+		cli.proxyIp = true
+	}
 	s.Lock()
 	s.clients[cli] = struct{}{}
 	s.Unlock()
+	metricAccepted.With(metricProtoWS, a.route.path).Inc()
+	metricConnections.With(metricProtoWS, a.route.path).Inc()
+}
 
-	conn.SetReadLimit(s.ReadLimit)
+// remove client from server and release its admission
+func (s *WSServer) deleteClient(cli *WSClient, a *wsAdmission) {
+	s.Lock()
+	delete(s.clients, cli)
+	s.Unlock()
+	metricConnections.With(metricProtoWS, a.route.path).Dec()
+
+	// real ip may be reset by CmdSetReaIp
+	a.limitIp = cli.limitIp
+	s.release(a)
+}
+
+// handle websocket request
+func (s *WSServer) onWebsocketRequest(route *wsRoute, w http.ResponseWriter, r *http.Request) {
+	defer util.HandlePanic()
+
+	a, ok := s.admit(route, w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.release(a)
+		return
+	}
+
+	engine := route.engine
+	var cli = newClient(conn, engine)
+	cli.initCompression(s.upgrader.EnableCompression && wsDeflateInHeader(r.Header))
+	s.addClient(cli, a)
+
+	conn.SetReadLimit(engine.ReadLimit)
 
 	defer func() {
-		s.Lock()
-		delete(s.clients, cli)
-		s.Unlock()
-		atomic.AddInt64(&s.currLoad, -1)
+		s.deleteClient(cli, a)
 
 		cli.Stop()
 
@@ -119,18 +250,19 @@ func (s *WSServer) onWebsocketRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	conn.SetPingHandler(func(string) error {
-		if s.ReadTimeout > 0 {
-			conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		if engine.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(engine.ReadTimeout))
 		}
 		return nil
 	})
 	conn.SetPongHandler(func(string) error {
-		if s.ReadTimeout > 0 {
-			conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		if engine.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(engine.ReadTimeout))
 		}
 		return nil
 	})
 
+	cli.loopWg.Add(2)
 	go cli.writeloop()
 
 	cli.readloop()
@@ -151,6 +283,56 @@ func (server *WSServer) SetMaxConcurrent(maxLoad int64) {
 	server.maxLoad = maxLoad
 }
 
+// ip filter
+func (s *WSServer) IpFilter() *IpFilter {
+	return s.ipFilter
+}
+
+// setting ip filter, nil to disable
+func (s *WSServer) SetIpFilter(filter *IpFilter) {
+	s.ipFilter = filter
+}
+
+// setting proxies trusted to set X-Forwarded-For/X-Real-IP, nil to disable
+func (s *WSServer) SetTrustedProxies(cidrs []string) error {
+	nets, err := ParseIpNets(cidrs)
+	if err != nil {
+		return err
+	}
+	s.trustedProxies = nets
+	return nil
+}
+
+// on set real ip, with ip filter only trusted proxies can set real ip
+func (s *WSServer) onSetRealIp(cli *WSClient, msg IMessage) {
+	filter := s.ipFilter
+	if filter == nil {
+		cli.SetRealIp(string(msg.Body()))
+		return
+	}
+
+	remoteIp := cli.remoteIp()
+	if !filter.IsTrustedProxy(remoteIp) {
+		log.Debug("[WSServer] ignore set real ip from untrusted %v", remoteIp)
+		return
+	}
+
+	cli.SetRealIp(string(msg.Body()))
+	realIp := cli.Ip()
+	if realIp == cli.limitIp {
+		return
+	}
+	if err := filter.Acquire(realIp); err != nil {
+		log.Debug("[WSServer] refuse real ip %v: %v", realIp, err)
+		cli.Stop()
+		return
+	}
+	if cli.limitIp != "" {
+		filter.Release(cli.limitIp)
+	}
+	cli.limitIp = realIp
+}
+
 // client num
 func (s *WSServer) ClientNum() int {
 	s.Lock()
@@ -158,7 +340,7 @@ func (s *WSServer) ClientNum() int {
 	return len(s.clients)
 }
 
-// setting request handler
+// setting request handler, returning a *WSAuthError rejects with its status, other errors with 404
 func (s *WSServer) HandleRequest(h func(w http.ResponseWriter, r *http.Request) error) {
 	s.requestHandler = h
 }
@@ -175,7 +357,65 @@ func (s *WSServer) HandleDisconnect(h func(cli *WSClient, w http.ResponseWriter,
 
 // setting websocket router
 func (s *WSServer) HandleWs(path string) {
-	s.wsRoutes[path] = s.onWebsocketRequest
+	s.HandleWsWithEngine(path, s.WSEngine)
+}
+
+// setting websocket router with its own engine, such as handlers, cipher, read limit and send queue size.
+// connect, disconnect, auth handlers and ip filter are shared by all routes.
+// enable compression of the engine before registering
+func (s *WSServer) HandleWsWithEngine(path string, engine *WSEngine) {
+	if engine == nil {
+		engine = s.WSEngine
+	}
+	if engine != s.WSEngine {
+		engine.handlers[CmdSetReaIp] = s.onSetRealIp
+		if engine.compression {
+			s.upgrader.EnableCompression = true
+		}
+	}
+	route := &wsRoute{path: path, engine: engine}
+	s.routes[path] = route
+	s.wsRoutes[path] = func(w http.ResponseWriter, r *http.Request) {
+		s.onWebsocketRequest(route, w, r)
+	}
+}
+
+// current load of route, -1 if route not exists
+func (s *WSServer) RouteLoad(path string) int64 {
+	route, ok := s.routes[path]
+	if !ok {
+		return -1
+	}
+	return atomic.LoadInt64(&route.currLoad)
+}
+
+// setting max concurrent of route, 0 means unlimited
+func (s *WSServer) SetRouteMaxConcurrent(path string, maxLoad int64) {
+	if route, ok := s.routes[path]; ok {
+		route.maxLoad = maxLoad
+	}
+}
+
+// engines of routes except the server's engine
+func (s *WSServer) routeEngines() []*WSEngine {
+	engines := []*WSEngine{}
+	for _, route := range s.routes {
+		engine := route.engine
+		if engine == s.WSEngine {
+			continue
+		}
+		exists := false
+		for _, e := range engines {
+			if e == engine {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			engines = append(engines, engine)
+		}
+	}
+	return engines
 }
 
 // setting http router
@@ -193,7 +433,7 @@ func (s *WSServer) stopClients() {
 	}
 }
 
-// graceful shutdown
+// graceful shutdown, stops all clients after running handlers done, then shutdown http server
 func (s *WSServer) Shutdown(timeout time.Duration, cb func(error)) {
 	s.Lock()
 	shutdown := s.shutdown
@@ -202,32 +442,51 @@ func (s *WSServer) Shutdown(timeout time.Duration, cb func(error)) {
 	if !shutdown {
 		log.Debug("WSServer Shutdown ...")
 
+		engines := s.routeEngines()
+		for _, engine := range engines {
+			engine.Lock()
+			engine.shutdown = true
+			engine.Unlock()
+		}
+
 		if timeout <= 0 {
 			timeout = DefaultShutdownTimeout
 		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
 
-		done := make(chan struct{}, 1)
+		done := make(chan error, 1)
 		util.Go(func() {
 			s.Wait()
+			for _, engine := range engines {
+				engine.Wait()
+			}
 
 			s.stopClients()
+			s.dispatcher.stopOwned()
+			for _, engine := range engines {
+				engine.dispatcher.stopOwned()
+			}
 
-			s.HttpServer.Shutdown()
-
-			done <- struct{}{}
+			done <- s.HttpServer.ShutdownWithContext(ctx)
 		})
 
-		after := time.NewTimer(timeout)
-		defer after.Stop()
+		var err error
 		select {
-		case <-after.C:
-			log.Debug("WSServer Shutdown timeout")
-			if cb != nil {
-				cb(ErrWSEngineShutdownTimeout)
+		case <-ctx.Done():
+			err = ErrWSEngineShutdownTimeout
+		case err = <-done:
+			if err == ErrHttpServerShutdownTimeout {
+				err = ErrWSEngineShutdownTimeout
 			}
-		case <-done:
+		}
+		if err != nil {
+			log.Debug("WSServer Shutdown failed: %v", err)
+		} else {
 			log.Debug("WSServer Shutdown success")
-			cb(nil)
+		}
+		if cb != nil {
+			cb(err)
 		}
 	}
 }
@@ -238,11 +497,17 @@ func NewWebsocketServer(tag string, addr string) (*WSServer, error) {
 	svr := &WSServer{
 		WSEngine: NewWebsocketEngine(),
 		maxLoad:  DefaultMaxOnline,
-		upgrader: &websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		upgrader: &websocket.Upgrader{
+			CheckOrigin:  func(r *http.Request) bool { return true },
+			Subprotocols: []string{WSSubprotocolBin, WSSubprotocolJson},
+		},
 		clients:  map[*WSClient]struct{}{},
 		wsRoutes: map[string]func(http.ResponseWriter, *http.Request){},
+		routes:   map[string]*wsRoute{},
 	}
 
+	svr.handlers[CmdSetReaIp] = svr.onSetRealIp
+
 	svr.HttpServer, err = NewHttpServer(tag, addr, svr, time.Second*5, nil, func() {
 		os.Exit(-1)
 	})
@@ -1,105 +1,169 @@
 package rate
 
-// deprecated
-
-// import (
-// 	"fmt"
-// 	"github.com/nothollyhigh/kiss/log"
-// 	"github.com/nothollyhigh/kiss/timer"
-// 	"runtime"
-// 	"sync"
-// 	"time"
-// )
-
-// var (
-// 	defaultLimiter = &Limiter{
-// 		trigger:    timer.New("kiss-default-limiter"),
-// 		bucketsMap: map[string]chan empty{},
-// 	}
-
-// 	ErrTimeout = fmt.Errorf("rate Wait timeout")
-// )
-
-// type empty struct{}
-
-// type Limiter struct {
-// 	sync.RWMutex
-// 	trigger    *timer.Timer
-// 	bucketsMap map[string]chan empty
-// }
-
-// func (l *Limiter) get(times int, interval time.Duration, constant bool, tags ...interface{}) chan empty {
-// 	_, file, line, _ := runtime.Caller(2)
-// 	key := fmt.Sprintf("%s_%d", file, line)
-// 	for _, v := range tags {
-// 		key += fmt.Sprintf("_%v", v)
-// 	}
-
-// 	l.Lock()
-// 	defer l.Unlock()
-
-// 	buckets, ok := l.bucketsMap[key]
-// 	if ok {
-// 		return buckets
-// 	}
-
-// 	if constant {
-// 		buckets = make(chan empty, 1)
-// 		interval = interval / time.Duration(times)
-// 		l.trigger.Schedule(0, interval, 0, func() {
-// 			select {
-// 			case buckets <- empty{}:
-// 			default:
-// 			}
-
-// 		})
-// 	} else {
-// 		buckets = make(chan empty, times)
-// 		l.trigger.Schedule(0, interval, 0, func() {
-// 			for i := 0; i < times; i++ {
-// 				select {
-// 				case buckets <- empty{}:
-// 				default:
-// 				}
-// 			}
-// 		})
-// 	}
-
-// 	l.bucketsMap[key] = buckets
-
-// 	return buckets
-// }
-
-// func (l *Limiter) Wait(times int, interval time.Duration, constant bool, timeout time.Duration, tags ...interface{}) error {
-// 	if times < 1 {
-// 		log.Panic("rate Wait failed: [invalid times arg: %v]", times)
-// 	}
-// 	if interval < 1 {
-// 		log.Panic("rate Wait failed: [invalid interval arg: %v]", interval)
-// 	}
-
-// 	if timeout > 0 {
-// 		after := time.NewTimer(timeout)
-// 		defer after.Stop()
-// 		select {
-// 		case <-l.get(times, interval, constant, tags...):
-// 		case <-after.C:
-// 			return ErrTimeout
-// 		}
-// 	} else {
-// 		<-l.get(times, interval, constant, tags...)
-// 	}
-
-// 	return nil
-// }
-
-// func New(tag string) *Limiter {
-// 	return &Limiter{
-// 		trigger:    timer.New(fmt.Sprintf("kiss-limiter-%v", tag)),
-// 		bucketsMap: map[string]chan empty{},
-// 	}
-// }
-
-// func Wait(times int, interval time.Duration, constant bool, timeout time.Duration, tags ...interface{}) error {
-// 	return defaultLimiter.Wait(times, interval, constant, timeout, tags)
-// }
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// default interval for removing idle buckets of limiter
+	DefaultGCInterval = time.Minute
+)
+
+// token bucket
+type Bucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// refill tokens, should be called with lock
+func (b *Bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// take n tokens if there are enough tokens
+func (b *Bucket) AllowN(now time.Time, n int) bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return true
+	}
+	return false
+}
+
+// take 1 token if there is enough token
+func (b *Bucket) Allow() bool {
+	return b.AllowN(time.Now(), 1)
+}
+
+// take n tokens and return how long to wait before the tokens are available,
+// tokens are not taken if the wait is longer than maxWait
+func (b *Bucket) ReserveN(now time.Time, n int, maxWait time.Duration) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+	need := float64(n) - b.tokens
+	if need <= 0 {
+		b.tokens -= float64(n)
+		return 0, true
+	}
+	if b.rate <= 0 {
+		return 0, false
+	}
+	wait := time.Duration(need / b.rate * float64(time.Second))
+	if wait > maxWait {
+		return wait, false
+	}
+	b.tokens -= float64(n)
+	return wait, true
+}
+
+// take 1 token and return how long to wait before the token is available
+func (b *Bucket) Reserve(maxWait time.Duration) (time.Duration, bool) {
+	return b.ReserveN(time.Now(), 1, maxWait)
+}
+
+// current tokens
+func (b *Bucket) Tokens() float64 {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(time.Now())
+	return b.tokens
+}
+
+// whether the bucket is full at now, a full bucket equals to a new one
+func (b *Bucket) full(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// token bucket factory, rate is tokens per second
+func NewBucket(rate float64, burst int) *Bucket {
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// token buckets by key
+type Limiter struct {
+	sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[interface{}]*Bucket
+	lastGC  time.Time
+}
+
+// bucket of key
+func (l *Limiter) bucket(key interface{}, now time.Time) *Bucket {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastGC) > DefaultGCInterval {
+		l.lastGC = now
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewBucket(l.rate, l.burst)
+		b.last = now
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// take 1 token of key if there is enough token
+func (l *Limiter) Allow(key interface{}) bool {
+	now := time.Now()
+	return l.bucket(key, now).AllowN(now, 1)
+}
+
+// take 1 token of key and return how long to wait before the token is available
+func (l *Limiter) Reserve(key interface{}, maxWait time.Duration) (time.Duration, bool) {
+	now := time.Now()
+	return l.bucket(key, now).ReserveN(now, 1, maxWait)
+}
+
+// remove bucket of key
+func (l *Limiter) Remove(key interface{}) {
+	l.Lock()
+	delete(l.buckets, key)
+	l.Unlock()
+}
+
+// bucket num
+func (l *Limiter) Len() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.buckets)
+}
+
+// limiter factory, rate is tokens per second for each key
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: map[interface{}]*Bucket{},
+		lastGC:  time.Now(),
+	}
+}
@@ -0,0 +1,54 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(10, 5)
+	b.last = now
+
+	for i := 0; i < 5; i++ {
+		if !b.AllowN(now, 1) {
+			t.Fatalf("Bucket should allow burst %v", i)
+		}
+	}
+	if b.AllowN(now, 1) {
+		t.Fatalf("Bucket should be empty")
+	}
+
+	now = now.Add(time.Second / 10)
+	if !b.AllowN(now, 1) {
+		t.Fatalf("Bucket should be refilled")
+	}
+
+	wait, ok := b.ReserveN(now, 1, time.Second)
+	if !ok || wait < time.Second/20 || wait > time.Second/10 {
+		t.Fatalf("Bucket ReserveN failed: %v, %v", wait, ok)
+	}
+	if _, ok = b.ReserveN(now, 1, 0); ok {
+		t.Fatalf("Bucket ReserveN should fail with 0 maxWait")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(1, 2)
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatalf("Limiter should allow burst")
+	}
+	if l.Allow("a") {
+		t.Fatalf("Limiter should limit key a")
+	}
+	if !l.Allow("b") {
+		t.Fatalf("Limiter should allow key b")
+	}
+	if l.Len() != 2 {
+		t.Fatalf("Limiter Len should be 2, got %v", l.Len())
+	}
+	l.Remove("a")
+	if !l.Allow("a") {
+		t.Fatalf("Limiter should allow removed key a")
+	}
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	redis "github.com/go-redis/redis"
+	"github.com/nothollyhigh/kiss/metrics"
+	"time"
+)
+
+var (
+	metricCmdDuration = metrics.NewHistogramVec("kiss_redis_command_duration_seconds", "redis command latency", nil, "redis", "cmd")
+	metricCmdErrors   = metrics.NewCounterVec("kiss_redis_command_errors_total", "redis command errors, redis.Nil excluded", "redis", "cmd")
+)
+
+// client which can be instrumented
+type processWrapper interface {
+	WrapProcess(fn func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error)
+	PoolStats() *redis.PoolStats
+}
+
+// observe commands and register pool gauges, returns gauges to unregister when closed
+func instrument(client processWrapper, tag string) []*metrics.GaugeFunc {
+	client.WrapProcess(func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			begin := time.Now()
+			err := oldProcess(cmd)
+			metricCmdDuration.With(tag, cmd.Name()).Since(begin)
+			if err != nil && err != redis.Nil {
+				metricCmdErrors.With(tag, cmd.Name()).Inc()
+			}
+			return err
+		}
+	})
+
+	gauges := []*metrics.GaugeFunc{}
+	for state, fn := range map[string]func(*redis.PoolStats) uint32{
+		"total": func(s *redis.PoolStats) uint32 { return s.TotalConns },
+		"idle":  func(s *redis.PoolStats) uint32 { return s.IdleConns },
+	} {
+		fn := fn
+		g, err := metrics.NewGaugeFunc("kiss_redis_pool_connections", "redis pool connections", metrics.Labels{"redis": tag, "state": state}, func() float64 {
+			return float64(fn(client.PoolStats()))
+		})
+		if err == nil {
+			gauges = append(gauges, g)
+		}
+	}
+	return gauges
+}
+
+// unregister pool gauges
+func uninstrument(gauges []*metrics.GaugeFunc) {
+	for _, g := range gauges {
+		metrics.Unregister(g)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	redis "github.com/go-redis/redis"
 	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/metrics"
 	"github.com/nothollyhigh/kiss/util"
 	"sort"
 	"strings"
@@ -52,6 +53,7 @@ type Redis struct {
 	client  *redis.Client
 	ticker  *time.Ticker
 	scripts map[string]*redisScript
+	gauges  []*metrics.GaugeFunc
 	Conf    Config
 }
 
@@ -134,6 +136,7 @@ func (rds *Redis) Eval(src string, keys []string, args ...interface{}) (interfac
 }
 
 func (rds *Redis) Close() error {
+	uninstrument(rds.gauges)
 	return rds.client.Close()
 }
 
@@ -215,6 +218,7 @@ func New(conf Config) *Redis {
 		client:  client,
 		scripts: map[string]*redisScript{},
 		ticker:  ticker,
+		gauges:  instrument(client, conf.Addr),
 		Conf:    conf,
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	redis "github.com/go-redis/redis"
 	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/metrics"
 	"github.com/nothollyhigh/kiss/util"
 	"strings"
 	"sync"
@@ -40,6 +41,7 @@ type RedisCluster struct {
 	client  *redis.ClusterClient
 	ticker  *time.Ticker
 	scripts map[string]*redisScript
+	gauges  []*metrics.GaugeFunc
 }
 
 func (rds *RedisCluster) Client() *redis.ClusterClient {
@@ -121,6 +123,7 @@ func (rds *RedisCluster) Eval(src string, keys []string, args ...interface{}) (i
 }
 
 func (rds *RedisCluster) Close() error {
+	uninstrument(rds.gauges)
 	return rds.client.Close()
 }
 
@@ -201,5 +204,6 @@ func NewCluster(conf ClusterConfig) *RedisCluster {
 		client:  client,
 		scripts: map[string]*redisScript{},
 		ticker:  ticker,
+		gauges:  instrument(client, strings.Join(conf.Addrs, ",")),
 	}
 }
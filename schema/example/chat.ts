@@ -0,0 +1,135 @@
+// Code generated by kissgen from chat.json. DO NOT EDIT.
+
+export const Cmd = {
+  // login with token
+  Login: 1001,
+  // logout, no payload
+  Logout: 1002,
+  // chat message, sent by client and broadcast by server
+  Chat: 1003,
+  // room info pushed by server
+  RoomUpdate: 1004,
+} as const;
+
+// login request
+export interface LoginReq {
+  user: string;
+  token: string;
+}
+
+// login response
+export interface LoginRsp {
+  // 0 if succeeded
+  code: number;
+  user_id: number;
+}
+
+// chat message
+export interface ChatMsg {
+  from: number;
+  room: string;
+  text: string;
+  image: string;
+}
+
+// members and recent messages of room
+export interface RoomInfo {
+  room: string;
+  // name -> user id
+  members: { [key: string]: number };
+  recent: ChatMsg[];
+}
+
+// json frame of kiss.json subprotocol
+export interface Frame {
+  cmd: number;
+  ext?: number;
+  body?: any;
+  bin?: string;
+}
+
+const CmdPing = 0x1000000;
+const CmdPing2 = 0x1000001;
+
+// websocket client of kiss.json subprotocol
+export class KissClient {
+  readonly ws: WebSocket;
+  private handlers: { [cmd: number]: (body: any, frame: Frame) => void } = {};
+  private timer: any = null;
+
+  // ping every pingInterval milliseconds if pingInterval > 0
+  constructor(url: string, pingInterval: number = 0) {
+    this.ws = new WebSocket(url, "kiss.json");
+    this.ws.onmessage = (ev: MessageEvent) => this.onFrame(JSON.parse(ev.data) as Frame);
+    if (pingInterval > 0) {
+      this.ws.addEventListener("open", () => {
+        this.timer = setInterval(() => this.send(CmdPing), pingInterval);
+      });
+      this.ws.addEventListener("close", () => clearInterval(this.timer));
+    }
+  }
+
+  private onFrame(frame: Frame): void {
+    if (frame.cmd === CmdPing) {
+      this.send(CmdPing2);
+      return;
+    }
+    const h = this.handlers[frame.cmd];
+    if (h) {
+      h(frame.body, frame);
+    }
+  }
+
+  // send body of cmd
+  send(cmd: number, body?: any, ext?: number): void {
+    const frame: Frame = { cmd: cmd };
+    if (ext) {
+      frame.ext = ext;
+    }
+    if (body !== undefined) {
+      frame.body = body;
+    }
+    this.ws.send(JSON.stringify(frame));
+  }
+
+  // handle cmd
+  on(cmd: number, h: (body: any, frame: Frame) => void): void {
+    this.handlers[cmd] = h;
+  }
+
+  // close connection
+  close(): void {
+    clearInterval(this.timer);
+    this.ws.close();
+  }
+
+  // send Cmd.Login
+  login(body: LoginReq): void {
+    this.send(Cmd.Login, body);
+  }
+
+  // handle Cmd.Login
+  onLoginReply(h: (body: LoginRsp) => void): void {
+    this.on(Cmd.Login, h);
+  }
+
+  // send Cmd.Logout
+  logout(): void {
+    this.send(Cmd.Logout);
+  }
+
+  // send Cmd.Chat
+  chat(body: ChatMsg): void {
+    this.send(Cmd.Chat, body);
+  }
+
+  // handle Cmd.Chat
+  onChat(h: (body: ChatMsg) => void): void {
+    this.on(Cmd.Chat, h);
+  }
+
+  // handle Cmd.RoomUpdate
+  onRoomUpdate(h: (body: RoomInfo) => void): void {
+    this.on(Cmd.RoomUpdate, h);
+  }
+}
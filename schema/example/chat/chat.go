@@ -0,0 +1,204 @@
+// Code generated by kissgen from chat.json. DO NOT EDIT.
+
+package chat
+
+import (
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/net"
+)
+
+// codec of payloads
+var Codec net.ICodec = net.DefaultCodec
+
+// cmds
+const (
+	// login with token
+	CmdLogin uint32 = 1001
+	// logout, no payload
+	CmdLogout uint32 = 1002
+	// chat message, sent by client and broadcast by server
+	CmdChat uint32 = 1003
+	// room info pushed by server
+	CmdRoomUpdate uint32 = 1004
+)
+
+// cmd names
+var CmdNames = map[uint32]string{
+	CmdLogin:      "Login",
+	CmdLogout:     "Logout",
+	CmdChat:       "Chat",
+	CmdRoomUpdate: "RoomUpdate",
+}
+
+// login request
+type LoginReq struct {
+	User  string `json:"user" msgpack:"user"`
+	Token string `json:"token" msgpack:"token"`
+}
+
+// login response
+type LoginRsp struct {
+	// 0 if succeeded
+	Code   int32 `json:"code" msgpack:"code"`
+	UserId int64 `json:"user_id" msgpack:"user_id"`
+}
+
+// chat message
+type ChatMsg struct {
+	From  int64  `json:"from" msgpack:"from"`
+	Room  string `json:"room" msgpack:"room"`
+	Text  string `json:"text" msgpack:"text"`
+	Image []byte `json:"image" msgpack:"image"`
+}
+
+// members and recent messages of room
+type RoomInfo struct {
+	Room string `json:"room" msgpack:"room"`
+	// name -> user id
+	Members map[string]int64 `json:"members" msgpack:"members"`
+	Recent  []*ChatMsg       `json:"recent" msgpack:"recent"`
+}
+
+// marshal and send
+func send(sess net.ISession, cmd uint32, v interface{}) error {
+	data, err := Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return sess.SendMsg(net.NewMessage(cmd, data))
+}
+
+// send Login request
+func SendLogin(sess net.ISession, req *LoginReq) error {
+	return send(sess, CmdLogin, req)
+}
+
+// handle Login request, messages failed to unmarshal are dropped
+func HandleLogin(engine *net.TcpEngin, h func(client *net.TcpClient, req *LoginReq)) {
+	engine.Handle(CmdLogin, func(client *net.TcpClient, msg net.IMessage) {
+		req := &LoginReq{}
+		if err := Codec.Unmarshal(msg.Body(), req); err != nil {
+			log.Debug("unmarshal Login request failed: %v, ip: %v", err, client.Ip())
+			return
+		}
+		h(client, req)
+	})
+}
+
+// handle Login request, messages failed to unmarshal are dropped
+func HandleLoginWS(engine *net.WSEngine, h func(cli *net.WSClient, req *LoginReq)) {
+	engine.Handle(CmdLogin, func(cli *net.WSClient, msg net.IMessage) {
+		req := &LoginReq{}
+		if err := Codec.Unmarshal(msg.Body(), req); err != nil {
+			log.Debug("unmarshal Login request failed: %v, ip: %v", err, cli.Ip())
+			return
+		}
+		h(cli, req)
+	})
+}
+
+// send Login response
+func ReplyLogin(sess net.ISession, rsp *LoginRsp) error {
+	return send(sess, CmdLogin, rsp)
+}
+
+// handle Login response, messages failed to unmarshal are dropped
+func HandleLoginReply(engine *net.TcpEngin, h func(client *net.TcpClient, rsp *LoginRsp)) {
+	engine.Handle(CmdLogin, func(client *net.TcpClient, msg net.IMessage) {
+		rsp := &LoginRsp{}
+		if err := Codec.Unmarshal(msg.Body(), rsp); err != nil {
+			log.Debug("unmarshal Login response failed: %v, ip: %v", err, client.Ip())
+			return
+		}
+		h(client, rsp)
+	})
+}
+
+// handle Login response, messages failed to unmarshal are dropped
+func HandleLoginReplyWS(engine *net.WSEngine, h func(cli *net.WSClient, rsp *LoginRsp)) {
+	engine.Handle(CmdLogin, func(cli *net.WSClient, msg net.IMessage) {
+		rsp := &LoginRsp{}
+		if err := Codec.Unmarshal(msg.Body(), rsp); err != nil {
+			log.Debug("unmarshal Login response failed: %v, ip: %v", err, cli.Ip())
+			return
+		}
+		h(cli, rsp)
+	})
+}
+
+// send Logout request
+func SendLogout(sess net.ISession) error {
+	return sess.SendMsg(net.NewMessage(CmdLogout, nil))
+}
+
+// handle Logout request
+func HandleLogout(engine *net.TcpEngin, h func(client *net.TcpClient)) {
+	engine.Handle(CmdLogout, func(client *net.TcpClient, msg net.IMessage) {
+		h(client)
+	})
+}
+
+// handle Logout request
+func HandleLogoutWS(engine *net.WSEngine, h func(cli *net.WSClient)) {
+	engine.Handle(CmdLogout, func(cli *net.WSClient, msg net.IMessage) {
+		h(cli)
+	})
+}
+
+// send Chat request
+func SendChat(sess net.ISession, req *ChatMsg) error {
+	return send(sess, CmdChat, req)
+}
+
+// handle Chat request, messages failed to unmarshal are dropped
+func HandleChat(engine *net.TcpEngin, h func(client *net.TcpClient, req *ChatMsg)) {
+	engine.Handle(CmdChat, func(client *net.TcpClient, msg net.IMessage) {
+		req := &ChatMsg{}
+		if err := Codec.Unmarshal(msg.Body(), req); err != nil {
+			log.Debug("unmarshal Chat request failed: %v, ip: %v", err, client.Ip())
+			return
+		}
+		h(client, req)
+	})
+}
+
+// handle Chat request, messages failed to unmarshal are dropped
+func HandleChatWS(engine *net.WSEngine, h func(cli *net.WSClient, req *ChatMsg)) {
+	engine.Handle(CmdChat, func(cli *net.WSClient, msg net.IMessage) {
+		req := &ChatMsg{}
+		if err := Codec.Unmarshal(msg.Body(), req); err != nil {
+			log.Debug("unmarshal Chat request failed: %v, ip: %v", err, cli.Ip())
+			return
+		}
+		h(cli, req)
+	})
+}
+
+// send RoomUpdate notification
+func SendRoomUpdate(sess net.ISession, req *RoomInfo) error {
+	return send(sess, CmdRoomUpdate, req)
+}
+
+// handle RoomUpdate notification, messages failed to unmarshal are dropped
+func HandleRoomUpdate(engine *net.TcpEngin, h func(client *net.TcpClient, req *RoomInfo)) {
+	engine.Handle(CmdRoomUpdate, func(client *net.TcpClient, msg net.IMessage) {
+		req := &RoomInfo{}
+		if err := Codec.Unmarshal(msg.Body(), req); err != nil {
+			log.Debug("unmarshal RoomUpdate notification failed: %v, ip: %v", err, client.Ip())
+			return
+		}
+		h(client, req)
+	})
+}
+
+// handle RoomUpdate notification, messages failed to unmarshal are dropped
+func HandleRoomUpdateWS(engine *net.WSEngine, h func(cli *net.WSClient, req *RoomInfo)) {
+	engine.Handle(CmdRoomUpdate, func(cli *net.WSClient, msg net.IMessage) {
+		req := &RoomInfo{}
+		if err := Codec.Unmarshal(msg.Body(), req); err != nil {
+			log.Debug("unmarshal RoomUpdate notification failed: %v, ip: %v", err, cli.Ip())
+			return
+		}
+		h(cli, req)
+	})
+}
@@ -0,0 +1,144 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// go type of field type
+func goType(typ string) string {
+	switch {
+	case strings.HasPrefix(typ, "[]"):
+		return "[]" + goType(typ[2:])
+	case strings.HasPrefix(typ, "map[string]"):
+		return "map[string]" + goType(typ[len("map[string]"):])
+	case typ == "bytes":
+		return "[]byte"
+	case primitives[typ]:
+		return typ
+	}
+	return "*" + typ
+}
+
+// write comment lines
+func writeComment(buf *bytes.Buffer, indent string, comment string, dflt string) {
+	if comment == "" {
+		comment = dflt
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintf(buf, "%s// %s\n", indent, line)
+	}
+}
+
+// typed send, reply and handler registration of a payload
+func writeGoHelpers(buf *bytes.Buffer, msg *Message, send string, handle string, typ string, what string) {
+	cmd := "Cmd" + msg.Name
+	if typ == "" {
+		fmt.Fprintf(buf, "\n// send %s %s\n", msg.Name, what)
+		fmt.Fprintf(buf, "func %s(sess net.ISession) error {\n\treturn sess.SendMsg(net.NewMessage(%s, nil))\n}\n", send, cmd)
+		for _, proto := range []struct{ suffix, engine, client, name string }{
+			{"", "*net.TcpEngin", "*net.TcpClient", "client"},
+			{"WS", "*net.WSEngine", "*net.WSClient", "cli"},
+		} {
+			fmt.Fprintf(buf, "\n// handle %s %s\n", msg.Name, what)
+			fmt.Fprintf(buf, "func %s%s(engine %s, h func(%s %s)) {\n", handle, proto.suffix, proto.engine, proto.name, proto.client)
+			fmt.Fprintf(buf, "\tengine.Handle(%s, func(%s %s, msg net.IMessage) {\n\t\th(%s)\n\t})\n}\n", cmd, proto.name, proto.client, proto.name)
+		}
+		return
+	}
+
+	v := "req"
+	if what == "response" {
+		v = "rsp"
+	}
+	fmt.Fprintf(buf, "\n// send %s %s\n", msg.Name, what)
+	fmt.Fprintf(buf, "func %s(sess net.ISession, %s *%s) error {\n\treturn send(sess, %s, %s)\n}\n", send, v, typ, cmd, v)
+	for _, proto := range []struct{ suffix, engine, client, name string }{
+		{"", "*net.TcpEngin", "*net.TcpClient", "client"},
+		{"WS", "*net.WSEngine", "*net.WSClient", "cli"},
+	} {
+		fmt.Fprintf(buf, "\n// handle %s %s, messages failed to unmarshal are dropped\n", msg.Name, what)
+		fmt.Fprintf(buf, "func %s%s(engine %s, h func(%s %s, %s *%s)) {\n", handle, proto.suffix, proto.engine, proto.name, proto.client, v, typ)
+		fmt.Fprintf(buf, "\tengine.Handle(%s, func(%s %s, msg net.IMessage) {\n", cmd, proto.name, proto.client)
+		fmt.Fprintf(buf, "\t\t%s := &%s{}\n", v, typ)
+		fmt.Fprintf(buf, "\t\tif err := Codec.Unmarshal(msg.Body(), %s); err != nil {\n", v)
+		fmt.Fprintf(buf, "\t\t\tlog.Debug(\"unmarshal %s %s failed: %%v, ip: %%v\", err, %s.Ip())\n", msg.Name, what, proto.name)
+		fmt.Fprintf(buf, "\t\t\treturn\n\t\t}\n\t\th(%s, %s)\n\t})\n}\n", proto.name, v)
+	}
+}
+
+// generate go code: cmd constants, payload types, typed send helpers and handler registration for TcpEngin and
+// WSEngine. source is the schema file name noted in the header
+func (s *Schema) GenerateGo(source string) ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	payload := false
+	for _, msg := range s.Messages {
+		if msg.Request != "" || msg.Response != "" {
+			payload = true
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by kissgen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(buf, "package %s\n\n", s.Package)
+	if payload {
+		buf.WriteString("import (\n\t\"github.com/nothollyhigh/kiss/log\"\n\t\"github.com/nothollyhigh/kiss/net\"\n)\n\n")
+		buf.WriteString("// codec of payloads\nvar Codec net.ICodec = net.DefaultCodec\n\n")
+	} else {
+		buf.WriteString("import \"github.com/nothollyhigh/kiss/net\"\n\n")
+	}
+
+	buf.WriteString("// cmds\nconst (\n")
+	for _, msg := range s.Messages {
+		writeComment(buf, "\t", msg.Comment, msg.Name)
+		fmt.Fprintf(buf, "\tCmd%s uint32 = %d\n", msg.Name, msg.Cmd)
+	}
+	buf.WriteString(")\n\n")
+
+	buf.WriteString("// cmd names\nvar CmdNames = map[uint32]string{\n")
+	for _, msg := range s.Messages {
+		fmt.Fprintf(buf, "\tCmd%s: %q,\n", msg.Name, msg.Name)
+	}
+	buf.WriteString("}\n")
+
+	for _, t := range s.Types {
+		buf.WriteString("\n")
+		writeComment(buf, "", t.Comment, t.Name)
+		fmt.Fprintf(buf, "type %s struct {\n", t.Name)
+		for _, f := range t.Fields {
+			if f.Comment != "" {
+				writeComment(buf, "\t", f.Comment, "")
+			}
+			fmt.Fprintf(buf, "\t%s %s `json:\"%s\" msgpack:\"%s\"`\n", goName(f.Name), goType(f.Type), f.Name, f.Name)
+		}
+		buf.WriteString("}\n")
+	}
+
+	if payload {
+		buf.WriteString("\n// marshal and send\nfunc send(sess net.ISession, cmd uint32, v interface{}) error {\n")
+		buf.WriteString("\tdata, err := Codec.Marshal(v)\n\tif err != nil {\n\t\treturn err\n\t}\n")
+		buf.WriteString("\treturn sess.SendMsg(net.NewMessage(cmd, data))\n}\n")
+	}
+
+	for _, msg := range s.Messages {
+		what := "request"
+		if msg.Dir() == DirS2C {
+			what = "notification"
+		}
+		writeGoHelpers(buf, msg, "Send"+msg.Name, "Handle"+msg.Name, msg.Request, what)
+		if msg.Response != "" {
+			writeGoHelpers(buf, msg, "Reply"+msg.Name, "Handle"+msg.Name+"Reply", msg.Response, "response")
+		}
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated go code failed: %v", err)
+	}
+	return src, nil
+}
@@ -0,0 +1,166 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// typescript type of field type, bytes are base64 strings in json
+func tsType(typ string) string {
+	switch {
+	case strings.HasPrefix(typ, "[]"):
+		return tsType(typ[2:]) + "[]"
+	case strings.HasPrefix(typ, "map[string]"):
+		return "{ [key: string]: " + tsType(typ[len("map[string]"):]) + " }"
+	case typ == "bool":
+		return "boolean"
+	case typ == "string" || typ == "bytes":
+		return "string"
+	case primitives[typ]:
+		return "number"
+	}
+	return typ
+}
+
+// typescript client runtime over websocket with kiss.json subprotocol
+const tsClient = `
+// json frame of kiss.json subprotocol
+export interface Frame {
+  cmd: number;
+  ext?: number;
+  body?: any;
+  bin?: string;
+}
+
+const CmdPing = 0x1000000;
+const CmdPing2 = 0x1000001;
+
+// websocket client of kiss.json subprotocol
+export class KissClient {
+  readonly ws: WebSocket;
+  private handlers: { [cmd: number]: (body: any, frame: Frame) => void } = {};
+  private timer: any = null;
+
+  // ping every pingInterval milliseconds if pingInterval > 0
+  constructor(url: string, pingInterval: number = 0) {
+    this.ws = new WebSocket(url, "kiss.json");
+    this.ws.onmessage = (ev: MessageEvent) => this.onFrame(JSON.parse(ev.data) as Frame);
+    if (pingInterval > 0) {
+      this.ws.addEventListener("open", () => {
+        this.timer = setInterval(() => this.send(CmdPing), pingInterval);
+      });
+      this.ws.addEventListener("close", () => clearInterval(this.timer));
+    }
+  }
+
+  private onFrame(frame: Frame): void {
+    if (frame.cmd === CmdPing) {
+      this.send(CmdPing2);
+      return;
+    }
+    const h = this.handlers[frame.cmd];
+    if (h) {
+      h(frame.body, frame);
+    }
+  }
+
+  // send body of cmd
+  send(cmd: number, body?: any, ext?: number): void {
+    const frame: Frame = { cmd: cmd };
+    if (ext) {
+      frame.ext = ext;
+    }
+    if (body !== undefined) {
+      frame.body = body;
+    }
+    this.ws.send(JSON.stringify(frame));
+  }
+
+  // handle cmd
+  on(cmd: number, h: (body: any, frame: Frame) => void): void {
+    this.handlers[cmd] = h;
+  }
+
+  // close connection
+  close(): void {
+    clearInterval(this.timer);
+    this.ws.close();
+  }
+`
+
+// write typescript comment
+func writeTsComment(buf *bytes.Buffer, indent string, comment string) {
+	if comment == "" {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintf(buf, "%s// %s\n", indent, line)
+	}
+}
+
+// write typed send method
+func writeTsSend(buf *bytes.Buffer, method string, cmd string, typ string) {
+	fmt.Fprintf(buf, "\n  // send %s\n", cmd)
+	if typ == "" {
+		fmt.Fprintf(buf, "  %s(): void {\n    this.send(%s);\n  }\n", method, cmd)
+		return
+	}
+	fmt.Fprintf(buf, "  %s(body: %s): void {\n    this.send(%s, body);\n  }\n", method, typ, cmd)
+}
+
+// write typed handler registration
+func writeTsOn(buf *bytes.Buffer, method string, cmd string, typ string) {
+	if typ == "" {
+		typ = "void"
+	}
+	fmt.Fprintf(buf, "\n  // handle %s\n", cmd)
+	fmt.Fprintf(buf, "  %s(h: (body: %s) => void): void {\n    this.on(%s, h);\n  }\n", method, typ, cmd)
+}
+
+// generate typescript code of web frontend: cmd constants, payload interfaces and a websocket client with typed
+// send and handler methods. source is the schema file name noted in the header
+func (s *Schema) GenerateTypeScript(source string) ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by kissgen from %s. DO NOT EDIT.\n\n", source)
+
+	buf.WriteString("export const Cmd = {\n")
+	for _, msg := range s.Messages {
+		writeTsComment(buf, "  ", msg.Comment)
+		fmt.Fprintf(buf, "  %s: %d,\n", msg.Name, msg.Cmd)
+	}
+	buf.WriteString("} as const;\n")
+
+	for _, t := range s.Types {
+		buf.WriteString("\n")
+		writeTsComment(buf, "", t.Comment)
+		fmt.Fprintf(buf, "export interface %s {\n", t.Name)
+		for _, f := range t.Fields {
+			writeTsComment(buf, "  ", f.Comment)
+			fmt.Fprintf(buf, "  %s: %s;\n", f.Name, tsType(f.Type))
+		}
+		buf.WriteString("}\n")
+	}
+
+	buf.WriteString(tsClient)
+	for _, msg := range s.Messages {
+		cmd := "Cmd." + msg.Name
+		dir := msg.Dir()
+		if dir == DirC2S || dir == DirBoth {
+			writeTsSend(buf, lowerName(msg.Name), cmd, msg.Request)
+			if msg.Response != "" {
+				writeTsOn(buf, "on"+msg.Name+"Reply", cmd, msg.Response)
+			}
+		}
+		if dir == DirS2C || dir == DirBoth {
+			writeTsOn(buf, "on"+msg.Name, cmd, msg.Request)
+		}
+	}
+	buf.WriteString("}\n")
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,272 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nothollyhigh/kiss/net"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// directions of message
+const (
+	// client to server, the response is sent back with the same cmd
+	DirC2S = "c2s"
+	// server to client
+	DirS2C = "s2c"
+	// both directions
+	DirBoth = "both"
+)
+
+// primitive field types
+var primitives = map[string]bool{
+	"bool":    true,
+	"int32":   true,
+	"int64":   true,
+	"uint32":  true,
+	"uint64":  true,
+	"float32": true,
+	"float64": true,
+	"string":  true,
+	"bytes":   true,
+}
+
+var (
+	typeNameRegexp  = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+	fieldNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	packageRegexp   = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// field of payload type
+type Field struct {
+	// json name, go field name is its camel case
+	Name string `json:"name"`
+	// bool, int32, int64, uint32, uint64, float32, float64, string, bytes, type name, []T or map[string]T
+	Type    string `json:"type"`
+	Comment string `json:"comment"`
+}
+
+// payload type
+type Type struct {
+	Name    string   `json:"name"`
+	Comment string   `json:"comment"`
+	Fields  []*Field `json:"fields"`
+}
+
+// message of a cmd
+type Message struct {
+	Name string `json:"name"`
+	Cmd  uint32 `json:"cmd"`
+	// request payload type, no payload if empty
+	Request string `json:"request"`
+	// response payload type of c2s message, no response if empty
+	Response string `json:"response"`
+	// c2s, s2c or both, c2s if empty
+	Direction string `json:"direction"`
+	Comment   string `json:"comment"`
+}
+
+// direction, c2s by default
+func (msg *Message) Dir() string {
+	if msg.Direction == "" {
+		return DirC2S
+	}
+	return msg.Direction
+}
+
+// message schema
+type Schema struct {
+	// go package name
+	Package  string     `json:"package"`
+	Types    []*Type    `json:"types"`
+	Messages []*Message `json:"messages"`
+}
+
+// type by name
+func (s *Schema) typeOf(name string) *Type {
+	for _, t := range s.Types {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// validation errors
+type ValidationError []string
+
+// error text, one error a line
+func (errs ValidationError) Error() string {
+	return strings.Join(errs, "\n")
+}
+
+// reserved cmds
+var reservedCmds = map[uint32]string{
+	net.CmdPing:      "CmdPing",
+	net.CmdPing2:     "CmdPing2",
+	net.CmdSetReaIp:  "CmdSetReaIp",
+	net.CmdRpcMethod: "CmdRpcMethod",
+	net.CmdRpcError:  "CmdRpcError",
+}
+
+// names used by generated code
+var reservedNames = map[string]bool{
+	"Codec":      true,
+	"Cmd":        true,
+	"Names":      true,
+	"Frame":      true,
+	"KissClient": true,
+	"Send":       true,
+	"On":         true,
+	"Close":      true,
+	"Ws":         true,
+	"Handlers":   true,
+	"Timer":      true,
+}
+
+// check field type
+func (s *Schema) checkFieldType(typ string) error {
+	switch {
+	case strings.HasPrefix(typ, "[]"):
+		return s.checkFieldType(typ[2:])
+	case strings.HasPrefix(typ, "map[string]"):
+		return s.checkFieldType(typ[len("map[string]"):])
+	case primitives[typ] || s.typeOf(typ) != nil:
+		return nil
+	}
+	return fmt.Errorf("unknown type %q", typ)
+}
+
+// validate names, field types, and cmds: collision with each other or reserved cmds, or greater than CmdUserMax
+func (s *Schema) Validate() error {
+	errs := ValidationError{}
+	addErr := func(format string, v ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, v...))
+	}
+
+	if !packageRegexp.MatchString(s.Package) {
+		addErr("invalid package %q", s.Package)
+	}
+
+	names := map[string]string{}
+	for _, t := range s.Types {
+		if !typeNameRegexp.MatchString(t.Name) {
+			addErr("type %q: invalid name, should be an exported identifier", t.Name)
+		}
+		if _, ok := names[t.Name]; ok {
+			addErr("type %q: duplicate name", t.Name)
+		}
+		names[t.Name] = "type"
+		if reservedNames[t.Name] {
+			addErr("type %q: name is reserved for generated code", t.Name)
+		}
+		fields := map[string]bool{}
+		for _, f := range t.Fields {
+			if !fieldNameRegexp.MatchString(f.Name) {
+				addErr("type %v: field %q: invalid name", t.Name, f.Name)
+			}
+			if fields[goName(f.Name)] {
+				addErr("type %v: field %q: duplicate name", t.Name, f.Name)
+			}
+			fields[goName(f.Name)] = true
+			if err := s.checkFieldType(f.Type); err != nil {
+				addErr("type %v: field %v: %v", t.Name, f.Name, err)
+			}
+		}
+	}
+
+	if len(s.Messages) == 0 {
+		addErr("no messages")
+	}
+	cmds := map[uint32]string{}
+	msgNames := map[string]bool{}
+	for _, msg := range s.Messages {
+		if !typeNameRegexp.MatchString(msg.Name) {
+			addErr("message %q: invalid name, should be an exported identifier", msg.Name)
+		}
+		if msgNames[msg.Name] {
+			addErr("message %q: duplicate name", msg.Name)
+		}
+		msgNames[msg.Name] = true
+		if reservedNames[msg.Name] {
+			addErr("message %q: name is reserved for generated code", msg.Name)
+		}
+		for _, prefix := range []string{"Cmd", "Send", "Handle", "Reply"} {
+			if names[prefix+msg.Name] != "" {
+				addErr("message %v: generated name %v collides with type", msg.Name, prefix+msg.Name)
+			}
+		}
+
+		if name, ok := reservedCmds[msg.Cmd]; ok {
+			addErr("message %v: cmd %d is reserved for %v", msg.Name, msg.Cmd, name)
+		} else if msg.Cmd > net.CmdUserMax {
+			addErr("message %v: cmd %d > %d is reserved for internal", msg.Name, msg.Cmd, net.CmdUserMax)
+		}
+		if other, ok := cmds[msg.Cmd]; ok {
+			addErr("message %v: cmd %d collides with message %v", msg.Name, msg.Cmd, other)
+		} else {
+			cmds[msg.Cmd] = msg.Name
+		}
+
+		switch msg.Dir() {
+		case DirC2S:
+		case DirS2C, DirBoth:
+			if msg.Response != "" {
+				addErr("message %v: only c2s message can have response", msg.Name)
+			}
+		default:
+			addErr("message %v: invalid direction %q, should be c2s, s2c or both", msg.Name, msg.Direction)
+		}
+		for _, typ := range []string{msg.Request, msg.Response} {
+			if typ != "" && s.typeOf(typ) == nil {
+				addErr("message %v: unknown type %q", msg.Name, typ)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// parse and validate json schema
+func Parse(data []byte) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parse and validate json schema file
+func ParseFile(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// go name of json name: user_id -> UserId
+func goName(name string) string {
+	parts := strings.Split(name, "_")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// lower camel name: Login -> login
+func lowerName(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
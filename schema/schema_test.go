@@ -0,0 +1,110 @@
+package schema
+
+import (
+	"bytes"
+	"github.com/nothollyhigh/kiss/net"
+	"github.com/nothollyhigh/kiss/schema/example/chat"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	newSchema := func() *Schema {
+		return &Schema{
+			Package: "msg",
+			Types: []*Type{
+				{Name: "Req", Fields: []*Field{{Name: "id", Type: "int64"}}},
+			},
+			Messages: []*Message{
+				{Name: "Login", Cmd: 1, Request: "Req"},
+			},
+		}
+	}
+	if err := newSchema().Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	for _, c := range []struct {
+		modify func(s *Schema)
+		err    string
+	}{
+		{func(s *Schema) { s.Messages[0].Cmd = net.CmdPing }, "reserved for CmdPing"},
+		{func(s *Schema) { s.Messages[0].Cmd = net.CmdRpcError }, "reserved for CmdRpcError"},
+		{func(s *Schema) { s.Messages[0].Cmd = net.CmdRpcError + 1 }, "reserved for internal"},
+		{func(s *Schema) { s.Messages = append(s.Messages, &Message{Name: "Logout", Cmd: 1}) }, "collides with message Login"},
+		{func(s *Schema) { s.Messages[0].Request = "Unknown" }, `unknown type "Unknown"`},
+		{func(s *Schema) { s.Types[0].Fields[0].Type = "[]Unknown" }, `unknown type "Unknown"`},
+		{func(s *Schema) { s.Messages[0].Direction = DirS2C; s.Messages[0].Response = "Req" }, "only c2s message can have response"},
+		{func(s *Schema) { s.Messages[0].Direction = "up" }, "invalid direction"},
+		{func(s *Schema) { s.Messages[0].Name = "Close" }, "reserved for generated code"},
+		{func(s *Schema) { s.Types[0].Name = "SendLogin"; s.Messages[0].Request = "SendLogin" }, "collides with type"},
+	} {
+		s := newSchema()
+		c.modify(s)
+		err := s.Validate()
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("Validate should fail with %q, got %v", c.err, err)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s, err := ParseFile("example/chat.json")
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	for _, c := range []struct {
+		path     string
+		generate func(string) ([]byte, error)
+	}{
+		{"example/chat/chat.go", s.GenerateGo},
+		{"example/chat.ts", s.GenerateTypeScript},
+	} {
+		data, err := c.generate("chat.json")
+		if err != nil {
+			t.Fatalf("generate %v failed: %v", c.path, err)
+		}
+		golden, err := ioutil.ReadFile(c.path)
+		if err != nil {
+			t.Fatalf("read %v failed: %v", c.path, err)
+		}
+		if !bytes.Equal(data, golden) {
+			t.Fatalf("%v is out of date, run: kissgen -schema example/chat.json -go example/chat/chat.go -ts example/chat.ts", c.path)
+		}
+	}
+}
+
+func TestGeneratedGo(t *testing.T) {
+	engine := net.NewTcpEngine()
+	chat.HandleLogin(engine, func(client *net.TcpClient, req *chat.LoginReq) {
+		if req.Token != "secret" {
+			chat.ReplyLogin(client, &chat.LoginRsp{Code: 1})
+			return
+		}
+		chat.ReplyLogin(client, &chat.LoginRsp{UserId: 10086})
+	})
+
+	h, err := net.NewPipeHarness(engine)
+	if err != nil {
+		t.Fatalf("NewPipeHarness failed: %v", err)
+	}
+	defer h.Close()
+
+	chat.SendLogin(h.Client, &chat.LoginReq{User: "kiss", Token: "secret"})
+	msg, err := h.Expect(chat.CmdLogin, time.Second)
+	if err != nil {
+		t.Fatalf("Expect failed: %v", err)
+	}
+	rsp := &chat.LoginRsp{}
+	if err = chat.Codec.Unmarshal(msg.Body(), rsp); err != nil || rsp.Code != 0 || rsp.UserId != 10086 {
+		t.Fatalf("invalid login response: %v, %+v", err, rsp)
+	}
+
+	// invalid payload is dropped
+	h.Send(net.NewMessage(chat.CmdLogin, []byte("{")))
+	if err = h.ExpectNone(time.Millisecond * 100); err != nil {
+		t.Fatalf("invalid payload should be dropped: %v", err)
+	}
+}
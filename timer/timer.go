@@ -3,6 +3,7 @@ package timer
 import (
 	"container/heap"
 	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/metrics"
 	"github.com/nothollyhigh/kiss/util"
 	"math"
 	"sync"
@@ -108,6 +109,9 @@ type Timer struct {
 	timers  Timers
 	trigger *time.Timer
 	chStop  chan struct{}
+
+	// heap size metric
+	gauge *metrics.GaugeFunc
 }
 
 // block timeout
@@ -268,6 +272,9 @@ func (tm *Timer) Stop() {
 	defer tm.Unlock()
 	close(tm.chStop)
 	tm.trigger.Stop()
+	if tm.gauge != nil {
+		metrics.Unregister(tm.gauge)
+	}
 }
 
 // once
@@ -323,6 +330,14 @@ func New(tag string) *Timer {
 		timers:  []*TimerItem{},
 	}
 
+	var err error
+	tm.gauge, err = metrics.NewGaugeFunc("kiss_timer_size", "timers in heap", metrics.Labels{"timer": tag}, func() float64 {
+		return float64(tm.Size())
+	})
+	if err != nil {
+		log.Debug("timer(%v) metric disabled: %v", tag, err)
+	}
+
 	go func() {
 		defer log.Debug("timer(%v) stopped", tag)
 		for {
@@ -0,0 +1,97 @@
+package trace
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	activeMtx   = sync.RWMutex{}
+	activeSpans = map[uint64]*Span{}
+
+	goroutineLocal int32
+)
+
+// setting whether spans can be activated on goroutines, off by default. active spans are looked up by goroutine
+// id parsed from runtime.Stack, so when on, StartSpan, rpc calls and every log line pay for a stack dump while
+// any span is active, and goroutines started in a span don't inherit it. pass spans explicitly instead if
+// possible, such as RpcContext.Span and RpcClient.CallWithParent
+func SetGoroutineLocal(enabled bool) {
+	v := int32(0)
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&goroutineLocal, v)
+}
+
+// whether spans can be activated on goroutines
+func GoroutineLocal() bool {
+	return atomic.LoadInt32(&goroutineLocal) != 0
+}
+
+// id of current goroutine
+func goroutineId() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	// "goroutine 123 [running]:..."
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// set span active on current goroutine until the returned func is called,
+// which restores the previously active span. no-op unless SetGoroutineLocal(true)
+func Activate(span *Span) func() {
+	if span == nil || !GoroutineLocal() {
+		return func() {}
+	}
+	gid := goroutineId()
+	activeMtx.Lock()
+	prev := activeSpans[gid]
+	activeSpans[gid] = span
+	activeMtx.Unlock()
+	return func() {
+		activeMtx.Lock()
+		if prev != nil {
+			activeSpans[gid] = prev
+		} else {
+			delete(activeSpans, gid)
+		}
+		activeMtx.Unlock()
+	}
+}
+
+// active span of current goroutine, nil if none or SetGoroutineLocal(true) is not set
+func Active() *Span {
+	if !Enabled() || !GoroutineLocal() {
+		return nil
+	}
+	activeMtx.RLock()
+	empty := len(activeSpans) == 0
+	activeMtx.RUnlock()
+	if empty {
+		return nil
+	}
+	gid := goroutineId()
+	activeMtx.RLock()
+	span := activeSpans[gid]
+	activeMtx.RUnlock()
+	return span
+}
+
+// trace id of active span of current goroutine, empty if none
+func ActiveTraceId() string {
+	return Active().TraceId()
+}
+
+// run f with span active on current goroutine
+func WithSpan(span *Span, f func()) {
+	defer Activate(span)()
+	f()
+}
@@ -0,0 +1,156 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// finished span
+type SpanData struct {
+	TraceId    string            `json:"trace_id"`
+	SpanId     string            `json:"span_id"`
+	ParentId   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Duration   time.Duration     `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// span exporter, Export is called by the goroutine finishing the span and should not block
+type Exporter interface {
+	Export(span *SpanData)
+}
+
+// exporter func
+type ExporterFunc func(span *SpanData)
+
+// export span
+func (f ExporterFunc) Export(span *SpanData) {
+	f(span)
+}
+
+// exporter keeping spans in memory, for tests
+type MemoryExporter struct {
+	sync.Mutex
+	spans []*SpanData
+}
+
+// export span
+func (e *MemoryExporter) Export(span *SpanData) {
+	e.Lock()
+	e.spans = append(e.spans, span)
+	e.Unlock()
+}
+
+// exported spans
+func (e *MemoryExporter) Spans() []*SpanData {
+	e.Lock()
+	defer e.Unlock()
+	return append([]*SpanData{}, e.spans...)
+}
+
+// exported spans of trace
+func (e *MemoryExporter) Trace(traceId string) []*SpanData {
+	e.Lock()
+	defer e.Unlock()
+	spans := []*SpanData{}
+	for _, v := range e.spans {
+		if v.TraceId == traceId {
+			spans = append(spans, v)
+		}
+	}
+	return spans
+}
+
+// clear exported spans
+func (e *MemoryExporter) Reset() {
+	e.Lock()
+	e.spans = nil
+	e.Unlock()
+}
+
+// memory exporter factory
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// exporter writing one json span per line to file
+type JsonFileExporter struct {
+	sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// export span
+func (e *JsonFileExporter) Export(span *SpanData) {
+	data, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.Lock()
+	if e.writer != nil {
+		e.writer.Write(data)
+		e.writer.WriteByte('\n')
+	}
+	e.Unlock()
+}
+
+// flush buffered spans to file
+func (e *JsonFileExporter) Flush() error {
+	e.Lock()
+	defer e.Unlock()
+	if e.writer == nil {
+		return nil
+	}
+	return e.writer.Flush()
+}
+
+// flush and close file
+func (e *JsonFileExporter) Close() error {
+	e.Lock()
+	defer e.Unlock()
+	if e.writer == nil {
+		return nil
+	}
+	err := e.writer.Flush()
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	e.writer = nil
+	return err
+}
+
+// json file exporter factory, spans are appended to the file
+func NewJsonFileExporter(path string) (*JsonFileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JsonFileExporter{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// read spans written by json file exporter
+func ReadJsonFile(path string) ([]*SpanData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	spans := []*SpanData{}
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		span := &SpanData{}
+		if err = decoder.Decode(span); err != nil {
+			return spans, err
+		}
+		spans = append(spans, span)
+	}
+	return spans, nil
+}
@@ -0,0 +1,316 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/nothollyhigh/kiss/log"
+	"math"
+	mrand "math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// trace id length
+	TraceIdLen = 16
+	// span id length
+	SpanIdLen = 8
+	// span context length in binary: trace id, span id and flags
+	SpanContextLen = TraceIdLen + SpanIdLen + 1
+
+	// span context flag: sampled
+	FlagSampled = byte(1)
+)
+
+var (
+	ErrInvalidSpanContext = errors.New("invalid span context")
+)
+
+// trace id
+type TraceId [TraceIdLen]byte
+
+// hex string
+func (id TraceId) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// whether id is all zero
+func (id TraceId) IsZero() bool {
+	return id == TraceId{}
+}
+
+// span id
+type SpanId [SpanIdLen]byte
+
+// hex string
+func (id SpanId) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// whether id is all zero
+func (id SpanId) IsZero() bool {
+	return id == SpanId{}
+}
+
+// span context propagated across processes
+type SpanContext struct {
+	TraceId TraceId
+	SpanId  SpanId
+	Sampled bool
+}
+
+// whether trace id and span id are set
+func (sc SpanContext) IsValid() bool {
+	return !sc.TraceId.IsZero() && !sc.SpanId.IsZero()
+}
+
+// append binary span context to data
+func (sc SpanContext) AppendTo(data []byte) []byte {
+	data = append(data, sc.TraceId[:]...)
+	data = append(data, sc.SpanId[:]...)
+	flags := byte(0)
+	if sc.Sampled {
+		flags |= FlagSampled
+	}
+	return append(data, flags)
+}
+
+// parse binary span context
+func ParseSpanContext(data []byte) (SpanContext, error) {
+	sc := SpanContext{}
+	if len(data) != SpanContextLen {
+		return sc, ErrInvalidSpanContext
+	}
+	copy(sc.TraceId[:], data[:TraceIdLen])
+	copy(sc.SpanId[:], data[TraceIdLen:TraceIdLen+SpanIdLen])
+	sc.Sampled = data[SpanContextLen-1]&FlagSampled != 0
+	if !sc.IsValid() {
+		return sc, ErrInvalidSpanContext
+	}
+	return sc, nil
+}
+
+// span kind
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindClient
+	KindServer
+)
+
+// kind name
+func (k Kind) String() string {
+	switch k {
+	case KindClient:
+		return "client"
+	case KindServer:
+		return "server"
+	}
+	return "internal"
+}
+
+// span
+type Span struct {
+	sync.Mutex
+	name       string
+	kind       Kind
+	ctx        SpanContext
+	parentId   SpanId
+	start      time.Time
+	attributes map[string]string
+	err        string
+	finished   bool
+	exporter   Exporter
+}
+
+// span context
+func (span *Span) Context() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return span.ctx
+}
+
+// trace id string, empty if span is nil
+func (span *Span) TraceId() string {
+	if span == nil {
+		return ""
+	}
+	return span.ctx.TraceId.String()
+}
+
+// span name
+func (span *Span) Name() string {
+	if span == nil {
+		return ""
+	}
+	return span.name
+}
+
+// setting attribute
+func (span *Span) SetAttribute(key, value string) {
+	if span == nil {
+		return
+	}
+	span.Lock()
+	if span.attributes == nil {
+		span.attributes = map[string]string{}
+	}
+	span.attributes[key] = value
+	span.Unlock()
+}
+
+// mark span failed
+func (span *Span) SetError(errText string) {
+	if span == nil {
+		return
+	}
+	span.Lock()
+	span.err = errText
+	span.Unlock()
+}
+
+// finish span and export it if sampled, only the first call takes effect
+func (span *Span) Finish() {
+	if span == nil {
+		return
+	}
+	span.Lock()
+	if span.finished {
+		span.Unlock()
+		return
+	}
+	span.finished = true
+	data := span.data(time.Now())
+	span.Unlock()
+
+	if span.ctx.Sampled && span.exporter != nil {
+		span.exporter.Export(data)
+	}
+}
+
+func (span *Span) data(end time.Time) *SpanData {
+	data := &SpanData{
+		TraceId:  span.ctx.TraceId.String(),
+		SpanId:   span.ctx.SpanId.String(),
+		Name:     span.name,
+		Kind:     span.kind.String(),
+		Start:    span.start,
+		End:      end,
+		Duration: end.Sub(span.start),
+		Error:    span.err,
+	}
+	if !span.parentId.IsZero() {
+		data.ParentId = span.parentId.String()
+	}
+	if len(span.attributes) > 0 {
+		data.Attributes = make(map[string]string, len(span.attributes))
+		for k, v := range span.attributes {
+			data.Attributes[k] = v
+		}
+	}
+	return data
+}
+
+var (
+	exporter   atomic.Value
+	sampleBits uint64 = math.Float64bits(1)
+
+	rndMtx = sync.Mutex{}
+	rnd    = mrand.New(mrand.NewSource(seed()))
+)
+
+type exporterHolder struct {
+	exporter Exporter
+}
+
+// setting exporter, tracing is enabled if e is not nil, and log lines are added with active trace id if
+// SetGoroutineLocal(true)
+func SetExporter(e Exporter) {
+	exporter.Store(exporterHolder{e})
+	if e != nil {
+		log.SetTraceIdFunc(ActiveTraceId)
+	} else {
+		log.SetTraceIdFunc(nil)
+	}
+}
+
+// current exporter
+func currentExporter() Exporter {
+	if h, ok := exporter.Load().(exporterHolder); ok {
+		return h.exporter
+	}
+	return nil
+}
+
+// whether tracing is enabled
+func Enabled() bool {
+	return currentExporter() != nil
+}
+
+// setting sample rate of root spans, from 0 to 1, children follow decision of their parents
+func SetSampleRate(rate float64) {
+	if rate < 0 {
+		rate = 0
+	} else if rate > 1 {
+		rate = 1
+	}
+	atomic.StoreUint64(&sampleBits, math.Float64bits(rate))
+}
+
+// sample rate of root spans
+func SampleRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&sampleBits))
+}
+
+// start span as a child of active span of current goroutine, or a root span if none active.
+// returns nil if tracing is not enabled, methods of nil span are no-ops
+func StartSpan(name string, kind Kind) *Span {
+	return StartSpanWithParent(name, kind, Active().Context())
+}
+
+// start span as a child of parent, or a root span if parent is invalid.
+// returns nil if tracing is not enabled, methods of nil span are no-ops
+func StartSpanWithParent(name string, kind Kind, parent SpanContext) *Span {
+	e := currentExporter()
+	if e == nil {
+		return nil
+	}
+
+	span := &Span{
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+		exporter: e,
+	}
+	rndMtx.Lock()
+	if parent.IsValid() {
+		span.ctx.TraceId = parent.TraceId
+		span.ctx.Sampled = parent.Sampled
+		span.parentId = parent.SpanId
+	} else {
+		for span.ctx.TraceId.IsZero() {
+			binary.LittleEndian.PutUint64(span.ctx.TraceId[:8], rnd.Uint64())
+			binary.LittleEndian.PutUint64(span.ctx.TraceId[8:], rnd.Uint64())
+		}
+		span.ctx.Sampled = rnd.Float64() < SampleRate()
+	}
+	for span.ctx.SpanId.IsZero() {
+		binary.LittleEndian.PutUint64(span.ctx.SpanId[:], rnd.Uint64())
+	}
+	rndMtx.Unlock()
+	return span
+}
+
+// seed of id generator
+func seed() int64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
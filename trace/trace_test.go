@@ -0,0 +1,143 @@
+package trace
+
+import (
+	"bytes"
+	"github.com/nothollyhigh/kiss/log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpanContext(t *testing.T) {
+	SetExporter(NewMemoryExporter())
+	defer SetExporter(nil)
+
+	span := StartSpan("root", KindInternal)
+	data := span.Context().AppendTo([]byte("body"))
+	if len(data) != len("body")+SpanContextLen {
+		t.Fatalf("data length should be %d, got %d", len("body")+SpanContextLen, len(data))
+	}
+	sc, err := ParseSpanContext(data[len("body"):])
+	if err != nil {
+		t.Fatalf("ParseSpanContext failed: %v", err)
+	}
+	if sc != span.Context() {
+		t.Fatalf("span context should be %v, got %v", span.Context(), sc)
+	}
+	if _, err = ParseSpanContext(make([]byte, SpanContextLen)); err != ErrInvalidSpanContext {
+		t.Fatalf("zero span context should be invalid, got %v", err)
+	}
+}
+
+func TestSpanTree(t *testing.T) {
+	if StartSpan("disabled", KindInternal) != nil {
+		t.Fatalf("span should be nil if tracing is not enabled")
+	}
+
+	exporter := NewMemoryExporter()
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	// not activated unless goroutine local spans enabled
+	root := StartSpan("root", KindInternal)
+	WithSpan(root, func() {
+		if Active() != nil {
+			t.Fatalf("active span should be nil if goroutine local is not enabled")
+		}
+	})
+	SetGoroutineLocal(true)
+	defer SetGoroutineLocal(false)
+
+	var child *Span
+	WithSpan(root, func() {
+		if Active() != root {
+			t.Fatalf("active span should be root")
+		}
+		child = StartSpan("child", KindClient)
+		child.SetAttribute("k", "v")
+		child.SetError("failed")
+		child.Finish()
+		child.Finish()
+	})
+	if Active() != nil {
+		t.Fatalf("active span should be restored")
+	}
+	root.Finish()
+
+	spans := exporter.Trace(root.TraceId())
+	if len(spans) != 2 {
+		t.Fatalf("spans should be 2, got %d", len(spans))
+	}
+	if spans[0].Name != "child" || spans[0].ParentId != root.Context().SpanId.String() || spans[0].Kind != "client" {
+		t.Fatalf("invalid child span: %+v", spans[0])
+	}
+	if spans[0].Attributes["k"] != "v" || spans[0].Error != "failed" {
+		t.Fatalf("invalid child span: %+v", spans[0])
+	}
+	if spans[1].Name != "root" || spans[1].ParentId != "" {
+		t.Fatalf("invalid root span: %+v", spans[1])
+	}
+
+	SetSampleRate(0)
+	defer SetSampleRate(1)
+	exporter.Reset()
+	unsampled := StartSpan("unsampled", KindInternal)
+	WithSpan(unsampled, func() {
+		StartSpan("child", KindInternal).Finish()
+	})
+	unsampled.Finish()
+	if n := len(exporter.Spans()); n != 0 {
+		t.Fatalf("unsampled spans should not be exported, got %d", n)
+	}
+}
+
+func TestJsonFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := NewJsonFileExporter(path)
+	if err != nil {
+		t.Fatalf("NewJsonFileExporter failed: %v", err)
+	}
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	span := StartSpan("json", KindServer)
+	span.Finish()
+	if err = exporter.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	spans, err := ReadJsonFile(path)
+	if err != nil {
+		t.Fatalf("ReadJsonFile failed: %v", err)
+	}
+	if len(spans) != 1 || spans[0].Name != "json" || spans[0].TraceId != span.TraceId() || spans[0].Kind != "server" {
+		t.Fatalf("invalid spans: %+v", spans)
+	}
+}
+
+func TestLogTraceId(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := log.NewLogger()
+	logger.SetOutput(buf)
+
+	SetExporter(NewMemoryExporter())
+	SetGoroutineLocal(true)
+	defer SetGoroutineLocal(false)
+	span := StartSpan("log", KindInternal)
+	WithSpan(span, func() {
+		logger.Info("in span")
+	})
+	logger.Info("out of span")
+	SetExporter(nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("log lines should be 2, got %d", len(lines))
+	}
+	if !strings.Contains(lines[0], "[trace:"+span.TraceId()+"] in span") {
+		t.Fatalf("log should contain trace id, got %v", lines[0])
+	}
+	if strings.Contains(lines[1], "[trace:") {
+		t.Fatalf("log should not contain trace id, got %v", lines[1])
+	}
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrKeyedQueueFull = errors.New("keyed queue is full")
+)
+
+// tasks with the same key run one by one in order,
+// tasks with different keys run in parallel
+type KeyedQueue struct {
+	sync.Mutex
+	sync.WaitGroup
+	tag     string
+	qCap    int
+	queues  map[interface{}][]func()
+	running bool
+}
+
+func (q *KeyedQueue) runLoop(key interface{}, h func()) {
+	defer q.Done()
+
+	for {
+		Safe(h)
+
+		q.Lock()
+		tasks := q.queues[key]
+		if len(tasks) == 0 {
+			delete(q.queues, key)
+			q.Unlock()
+			return
+		}
+		h = tasks[0]
+		tasks[0] = nil
+		q.queues[key] = tasks[1:]
+		q.Unlock()
+	}
+}
+
+func (q *KeyedQueue) Go(key interface{}, h func()) error {
+	q.Lock()
+
+	if !q.running {
+		q.Unlock()
+		return ErrWorkerPoolStopped
+	}
+
+	if tasks, ok := q.queues[key]; ok {
+		if q.qCap > 0 && len(tasks) >= q.qCap {
+			q.Unlock()
+			return ErrKeyedQueueFull
+		}
+		q.queues[key] = append(tasks, h)
+		q.Unlock()
+		return nil
+	}
+
+	// a goroutine is running for this key until its queue is empty
+	q.queues[key] = nil
+	q.Add(1)
+	q.Unlock()
+
+	Go(func() {
+		q.runLoop(key, h)
+	})
+
+	return nil
+}
+
+// pending task num of the key, not including the running one
+func (q *KeyedQueue) Len(key interface{}) int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.queues[key])
+}
+
+// running key num
+func (q *KeyedQueue) KeyNum() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.queues)
+}
+
+func (q *KeyedQueue) Stop() {
+	q.Lock()
+	q.running = false
+	q.Unlock()
+	q.Wait()
+}
+
+func NewKeyedQueue(tag string, qCap int) *KeyedQueue {
+	return &KeyedQueue{
+		tag:     tag,
+		qCap:    qCap,
+		queues:  map[interface{}][]func(){},
+		running: true,
+	}
+}
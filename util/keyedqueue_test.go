@@ -0,0 +1,64 @@
+package util
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedQueue(t *testing.T) {
+	q := NewKeyedQueue("test", 0)
+
+	mtx := sync.Mutex{}
+	results := map[int][]int{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		key, idx := i%4, i
+		wg.Add(1)
+		err := q.Go(key, func() {
+			defer wg.Done()
+			time.Sleep(time.Millisecond)
+			mtx.Lock()
+			results[key] = append(results[key], idx)
+			mtx.Unlock()
+		})
+		if err != nil {
+			t.Fatalf("KeyedQueue Go failed: %v", err)
+		}
+	}
+	wg.Wait()
+
+	for key, arr := range results {
+		for i := 1; i < len(arr); i++ {
+			if arr[i] <= arr[i-1] {
+				t.Fatalf("KeyedQueue key %v out of order: %v", key, arr)
+			}
+		}
+	}
+
+	q.Stop()
+	if q.KeyNum() != 0 {
+		t.Fatalf("KeyedQueue KeyNum should be 0 after Stop, got %v", q.KeyNum())
+	}
+	if err := q.Go(0, func() {}); err != ErrWorkerPoolStopped {
+		t.Fatalf("KeyedQueue Go after Stop should fail, got %v", err)
+	}
+}
+
+func TestKeyedQueueFull(t *testing.T) {
+	q := NewKeyedQueue("test", 1)
+	defer q.Stop()
+
+	block := make(chan struct{})
+	q.Go(0, func() { <-block })
+	if err := q.Go(0, func() {}); err != nil {
+		t.Fatalf("KeyedQueue Go failed: %v", err)
+	}
+	if err := q.Go(0, func() {}); err != ErrKeyedQueueFull {
+		t.Fatalf("KeyedQueue should be full, got %v", err)
+	}
+	if err := q.Go(1, func() {}); err != nil {
+		t.Fatalf("KeyedQueue other key should not be full, got %v", err)
+	}
+	close(block)
+}